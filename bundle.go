@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/bundle"
+	"github.com/bastjan/saveomat/internal/pkg/mirror"
+	"github.com/bastjan/saveomat/internal/pkg/registry"
+	"github.com/bastjan/saveomat/internal/pkg/sources"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker/client"
+)
+
+func bundleImages(args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	var files sources.Sources
+	fs.Var((*stringsFlag)(&files.ImageLists), "f", "image list in the format of images.txt, may be repeated")
+	fs.Var((*stringsFlag)(&files.ComposeFiles), "compose", "docker-compose.yml to read images from, may be repeated")
+	fs.Var((*stringsFlag)(&files.Manifests), "k8s", "Kubernetes manifests to read images from, may be repeated")
+	output := fs.String("o", "", "file to write the archive to, - for stdout; defaults to images.tar or images.tar.gz")
+	var opts bundle.Options
+	fs.StringVar(&opts.Format, "format", bundle.FormatDocker, `archive format, "docker" or "oci"`)
+	fs.StringVar(&opts.Compression, "compression", bundle.CompressionNone, `"none" or "gzip"`)
+	fs.BoolVar(&opts.Checksums, "checksums", false, "embed a SHA256SUMS file")
+	fs.BoolVar(&opts.Referrers, "referrers", false, "add signatures, attestations and SBOMs of the images, requires -format oci")
+	fs.StringVar(&opts.SBOM, "sbom", "", `generate SBOMs, "spdx" or "cyclonedx"`)
+	platform := fs.String("platform", "", "platform to pull, e.g. linux/arm64")
+	retagPrefix := fs.String("retag-prefix", "", "registry to retag the images for")
+	authFile := fs.String("auth", "", "docker config file with the registry credentials, defaults to the local docker config")
+	noAuth := fs.Bool("no-auth", false, "pull without credentials")
+	quiet := fs.Bool("q", false, "do not show pull progress")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: saveomat bundle [options] -f images.txt|-compose docker-compose.yml|-k8s manifests.yaml")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || len(files.ImageLists)+len(files.ComposeFiles)+len(files.Manifests) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	_, entries, err := files.Read()
+	if err != nil {
+		return err
+	}
+	images, err := bundle.Normalize(entries, *retagPrefix, *platform)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		return fmt.Errorf("no images to bundle")
+	}
+	authn, err := bundleAuth(*authFile, *noAuth)
+	if err != nil {
+		return err
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	mirrors, err := mirror.Parse(os.Getenv("REGISTRY_MIRRORS"))
+	if err != nil {
+		return fmt.Errorf("parsing REGISTRY_MIRRORS: %w", err)
+	}
+	b := &bundle.Bundler{
+		DockerClient:   cli,
+		Mirrors:        mirrors,
+		RegistryClient: registry.NewClient(),
+	}
+	if !*quiet {
+		b.Progress = os.Stderr
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	a, err := b.Build(ctx, authn, images, opts)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	name := *output
+	if name == "" {
+		name = a.Filename
+	}
+	out := os.Stdout
+	if name != "-" {
+		if out, err = os.Create(name); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), a)
+	if err == nil && out != os.Stdout {
+		err = out.Close()
+	}
+	if err != nil {
+		if out != os.Stdout {
+			os.Remove(name)
+		}
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %d images, %s, sha256 %s\n", name, len(a.Images), humanSize(n), hex.EncodeToString(h.Sum(nil)))
+	return nil
+}
+
+// bundleAuth returns the credentials for pulling. Credential helpers of the
+// local docker config are used as configured.
+func bundleAuth(authFile string, noAuth bool) (auth.Authenticator, error) {
+	switch {
+	case noAuth:
+		return auth.EmptyAuthenticator, nil
+	case authFile != "":
+		f, err := os.Open(authFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		authn, err := auth.FromReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", authFile, err)
+		}
+		return authn, nil
+	}
+	cfg, err := config.Load(config.Dir())
+	if err != nil {
+		return nil, err
+	}
+	return auth.FromConfigFile(cfg), nil
+}
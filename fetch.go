@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/sources"
+	saveomat "github.com/bastjan/saveomat/pkg/client"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/term"
+)
+
+// stringsFlag is a flag that may be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func fetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	serverURL := fs.String("server", "http://localhost:8080", "URL of the saveomat server, defaults to $SAVEOMAT_URL if set")
+	var files sources.Sources
+	var params stringsFlag
+	fs.Var((*stringsFlag)(&files.ImageLists), "f", "image list in the format of images.txt, may be repeated")
+	fs.Var((*stringsFlag)(&files.ComposeFiles), "compose", "docker-compose.yml to read images from, may be repeated")
+	fs.Var((*stringsFlag)(&files.Manifests), "k8s", "Kubernetes manifests to read images from, may be repeated")
+	listName := fs.String("list", "", "download the newest archive of a list stored on the server instead")
+	token := fs.String("token", "", "bearer token for lists of servers with a LISTS_TOKEN, defaults to $SAVEOMAT_TOKEN")
+	output := fs.String("o", "images.tar", "file to write the archive to, - for stdout")
+	format := fs.String("format", "", `archive format, "docker" or "oci"`)
+	platform := fs.String("platform", "", "platform to pull, e.g. linux/arm64")
+	retagPrefix := fs.String("retag-prefix", "", "registry to retag the images for")
+	checksums := fs.Bool("checksums", false, "embed checksums and verify them after downloading")
+	fs.Var(&params, "param", "additional request parameter as name=value, may be repeated")
+	noAuth := fs.Bool("no-auth", false, "do not send credentials of the local docker config")
+	keyFile := fs.String("key", "", "PEM encoded Ed25519 public key the archive must be signed with")
+	load := fs.Bool("load", false, "load the archive into the local docker daemon")
+	quiet := fs.Bool("q", false, "do not show progress")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: saveomat fetch [options] -f images.txt|-compose docker-compose.yml|-k8s manifests.yaml|-list name")
+		fs.PrintDefaults()
+	}
+	if v := os.Getenv("SAVEOMAT_URL"); v != "" {
+		*serverURL = v
+	}
+	if v := os.Getenv("SAVEOMAT_TOKEN"); v != "" {
+		*token = v
+	}
+	fs.Parse(args)
+	hasSources := len(files.ImageLists)+len(files.ComposeFiles)+len(files.Manifests) > 0
+	if fs.NArg() != 0 || hasSources == (*listName != "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *output == "-" && (*checksums || *keyFile != "" || *load) {
+		return fmt.Errorf("-checksums, -key and -load need a file to verify or load, use -o instead of writing to stdout")
+	}
+
+	u, err := url.Parse(*serverURL)
+	if err != nil {
+		return fmt.Errorf("parsing server URL: %w", err)
+	}
+	c := &saveomat.Client{URL: u, Token: *token}
+	if !*quiet {
+		c.Progress = progress(os.Stderr)
+	}
+
+	var key ed25519.PublicKey
+	if *keyFile != "" {
+		pem, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		if key, err = archive.ParsePublicKey(pem); err != nil {
+			return fmt.Errorf("parsing %s: %w", *keyFile, err)
+		}
+		*checksums = true
+	}
+
+	req := saveomat.Request{Params: url.Values{}}
+	for name, v := range map[string]string{"format": *format, "platform": *platform, "retag-prefix": *retagPrefix} {
+		if v != "" {
+			req.Params.Set(name, v)
+		}
+	}
+	if *checksums {
+		req.Params.Set("checksums", "true")
+	}
+	for _, p := range params {
+		i := strings.IndexByte(p, '=')
+		if i < 1 {
+			return fmt.Errorf("invalid parameter %q, expected name=value", p)
+		}
+		req.Params.Add(p[:i], p[i+1:])
+	}
+	if hasSources {
+		read, entries, err := files.Read()
+		if err != nil {
+			return err
+		}
+		for _, f := range read {
+			req.Uploads = append(req.Uploads, saveomat.Upload(f))
+		}
+		if !*noAuth {
+			cfg, err := config.Load(config.Dir())
+			if err != nil {
+				return err
+			}
+			images := make([]string, 0, len(entries))
+			for _, e := range entries {
+				images = append(images, e.Source)
+			}
+			creds, err := saveomat.Credentials(cfg, images)
+			if err != nil {
+				return err
+			}
+			req.Uploads = append(req.Uploads, saveomat.Upload{Field: "config.json", Filename: "config.json", Content: creds})
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var res saveomat.Result
+	switch {
+	case *output == "-" && *listName == "":
+		res, err = c.Stream(ctx, req, os.Stdout)
+	case *output == "-":
+		return fmt.Errorf("lists can only be downloaded to a file")
+	case *listName != "":
+		res, err = c.DownloadList(ctx, *listName, *output)
+	default:
+		res, err = c.Download(ctx, req, *output)
+	}
+	if c.Progress != nil {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	resumed := ""
+	if res.Resumed {
+		resumed = ", resumed"
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d images, %s, sha256 %s%s\n", *output, len(res.Images), humanSize(res.Size), res.SHA256, resumed)
+
+	if *checksums {
+		f, err := os.Open(*output)
+		if err != nil {
+			return err
+		}
+		vres, err := archive.Verify(f, key)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("%s: OK, %d files", *output, vres.Files)
+		if vres.Signed {
+			msg += ", signature valid"
+		}
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	if *load {
+		return loadArchive(ctx, *output, *quiet)
+	}
+	return nil
+}
+
+// loadArchive loads the archive into the docker daemon configured in the
+// environment.
+func loadArchive(ctx context.Context, path string, quiet bool) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	resp, err := cli.ImageLoad(ctx, f, quiet)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !resp.JSON {
+		_, err = io.Copy(os.Stderr, resp.Body)
+		return err
+	}
+	fd, isTerm := term.GetFdInfo(os.Stderr)
+	return jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stderr, fd, isTerm, nil)
+}
+
+// progress returns a progress function printing to w. Progress is only shown
+// on terminals and at most ten times a second.
+func progress(w *os.File) func(written, total int64) {
+	if _, isTerm := term.GetFdInfo(w); !isTerm {
+		return nil
+	}
+	var last time.Time
+	return func(written, total int64) {
+		if now := time.Now(); now.Sub(last) >= 100*time.Millisecond || written == total {
+			last = now
+			if total > 0 {
+				fmt.Fprintf(w, "\r%s / %s (%d%%)\033[K", humanSize(written), humanSize(total), written*100/total)
+			} else {
+				fmt.Fprintf(w, "\r%s\033[K", humanSize(written))
+			}
+		}
+	}
+}
+
+// humanSize formats n bytes with a binary unit.
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,179 @@
+// Package archive post-processes image archives created by `docker save`.
+package archive
+
+import (
+	"archive/tar"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"time"
+)
+
+const (
+	// SumsFile lists the SHA-256 checksums of all files in an archive. Links
+	// and other special files are listed with the checksum of their type and
+	// target.
+	SumsFile = "SHA256SUMS"
+	// SignatureFile holds the base64 encoded Ed25519 signature of SumsFile.
+	SignatureFile = "SHA256SUMS.sig"
+)
+
+// File is a file added to an archive.
+type File struct {
+	Name    string
+	Content []byte
+}
+
+type CopyOptions struct {
+	// Skip omits entries of the source archive. Skipped files are still
+	// observed.
+	Skip func(hdr *tar.Header) bool
+	// Observe is called with the content of every regular file copied from
+	// the source archive. It may read as much of the content as it needs.
+	Observe func(hdr *tar.Header, r io.Reader) error
+	// Extra files are appended to the archive.
+	Extra []File
+	// Append returns more files to append once the source archive is copied.
+	Append func() ([]File, error)
+	// Checksums appends a SumsFile listing all files of the archive.
+	Checksums bool
+	// SigningKey signs the SumsFile. Implies Checksums.
+	SigningKey ed25519.PrivateKey
+}
+
+// Copy copies the tar archive r to w, applying the options. Archives with
+// duplicate entries are rejected.
+func Copy(w io.Writer, r io.Reader, opts CopyOptions) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	sums := Sums{}
+	names := entryNames{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if opts.Skip != nil && opts.Skip(hdr) {
+			if opts.Observe != nil && hdr.Typeflag == tar.TypeReg {
+				if err := opts.Observe(hdr, tr); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := names.add(hdr.Name); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir:
+			continue
+		default:
+			sums = append(sums, linkSum(hdr))
+			continue
+		}
+		h := sha256.New()
+		content := io.TeeReader(tr, io.MultiWriter(tw, h))
+		if opts.Observe != nil {
+			if err := opts.Observe(hdr, content); err != nil {
+				return err
+			}
+		}
+		if _, err := io.Copy(ioutil.Discard, content); err != nil {
+			return err
+		}
+		sums = append(sums, Sum{Name: hdr.Name, Digest: h.Sum(nil)})
+	}
+
+	extra := opts.Extra
+	if opts.Append != nil {
+		files, err := opts.Append()
+		if err != nil {
+			return err
+		}
+		extra = append(extra[:len(extra):len(extra)], files...)
+	}
+	for _, f := range extra {
+		if err := names.add(f.Name); err != nil {
+			return err
+		}
+		if err := writeFile(tw, f); err != nil {
+			return err
+		}
+		d := sha256.Sum256(f.Content)
+		sums = append(sums, Sum{Name: f.Name, Digest: d[:]})
+	}
+
+	if opts.Checksums || opts.SigningKey != nil {
+		if err := names.add(SumsFile); err != nil {
+			return err
+		}
+		content := sums.Marshal()
+		if err := writeFile(tw, File{Name: SumsFile, Content: content}); err != nil {
+			return err
+		}
+		if opts.SigningKey != nil {
+			if err := names.add(SignatureFile); err != nil {
+				return err
+			}
+			sig := base64.StdEncoding.EncodeToString(ed25519.Sign(opts.SigningKey, content))
+			if err := writeFile(tw, File{Name: SignatureFile, Content: []byte(sig + "\n")}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+// linkSum returns the checksum of an entry that is neither a regular file nor
+// a directory, like a symlink or hardlink. It covers the type and the link
+// target, as in "2 ../abc/layer.tar".
+func linkSum(hdr *tar.Header) Sum {
+	desc := fmt.Sprintf("%c %s", hdr.Typeflag, hdr.Linkname)
+	if hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock {
+		desc += fmt.Sprintf(" %d %d", hdr.Devmajor, hdr.Devminor)
+	}
+	d := sha256.Sum256([]byte(desc))
+	return Sum{Name: hdr.Name, Digest: d[:]}
+}
+
+// entryNames detects duplicate entries. "abc/", "./abc" and "abc" are the
+// same entry.
+type entryNames map[string]bool
+
+func (n entryNames) add(name string) error {
+	clean := path.Clean(name)
+	if n[clean] {
+		return fmt.Errorf("duplicate entry %s", name)
+	}
+	n[clean] = true
+	return nil
+}
+
+func writeFile(tw *tar.Writer, f File) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     f.Name,
+		Size:     int64(len(f.Content)),
+		Mode:     0644,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(f.Content)
+	return err
+}
@@ -0,0 +1,92 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sum is the SHA-256 checksum of a file.
+type Sum struct {
+	Name   string
+	Digest []byte
+}
+
+// Sums is a list of checksums in the format of sha256sum.
+type Sums []Sum
+
+// Marshal formats the checksums like sha256sum does.
+func (s Sums) Marshal() []byte {
+	b := new(bytes.Buffer)
+	for _, sum := range s {
+		fmt.Fprintf(b, "%x  %s\n", sum.Digest, sum.Name)
+	}
+	return b.Bytes()
+}
+
+// ParseSums parses the output of sha256sum.
+func ParseSums(r io.Reader) (Sums, error) {
+	sums := Sums{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: invalid checksum line", n)
+		}
+		d, err := hex.DecodeString(parts[0])
+		if err != nil || len(d) != 32 {
+			return nil, fmt.Errorf("line %d: invalid SHA-256 checksum", n)
+		}
+		// sha256sum marks binary files with "*"
+		name := strings.TrimPrefix(strings.TrimPrefix(parts[1], " "), "*")
+		sums = append(sums, Sum{Name: name, Digest: d})
+	}
+	return sums, sc.Err()
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8 Ed25519 private key as created
+// by `openssl genpkey -algorithm ed25519`.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an Ed25519 key, got %T", key)
+	}
+	return edKey, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX Ed25519 public key as created by
+// `openssl pkey -pubout`.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an Ed25519 key, got %T", key)
+	}
+	return edKey, nil
+}
@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// maxSumsSize limits the size of SumsFile and SignatureFile read into memory.
+const maxSumsSize = 16 << 20
+
+// VerifyResult describes a verified archive.
+type VerifyResult struct {
+	// SHA256 is the checksum of the whole archive.
+	SHA256 []byte
+	// Files is the number of verified files.
+	Files int
+	// Signed is true if the signature was verified.
+	Signed bool
+}
+
+// Verify checks all files of the archive against its SumsFile. If key is
+// given, the archive must be signed with it. Archives with duplicate entries
+// are rejected.
+func Verify(r io.Reader, key ed25519.PublicKey) (VerifyResult, error) {
+	var res VerifyResult
+	archiveHash := sha256.New()
+	tr := tar.NewReader(io.TeeReader(r, archiveHash))
+
+	actual := map[string][]byte{}
+	names := entryNames{}
+	var sumsContent, sigContent []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		if err := names.add(hdr.Name); err != nil {
+			return res, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir:
+			continue
+		default:
+			actual[hdr.Name] = linkSum(hdr).Digest
+			continue
+		}
+
+		switch hdr.Name {
+		case SumsFile:
+			sumsContent, err = ioutil.ReadAll(io.LimitReader(tr, maxSumsSize))
+		case SignatureFile:
+			sigContent, err = ioutil.ReadAll(io.LimitReader(tr, maxSumsSize))
+		default:
+			h := sha256.New()
+			_, err = io.Copy(h, tr)
+			actual[hdr.Name] = h.Sum(nil)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	// Hash the tar padding after the end-of-archive marker as well.
+	if _, err := io.Copy(ioutil.Discard, io.TeeReader(r, archiveHash)); err != nil {
+		return res, err
+	}
+	res.SHA256 = archiveHash.Sum(nil)
+
+	if sumsContent == nil {
+		return res, fmt.Errorf("archive does not contain %s", SumsFile)
+	}
+
+	if key != nil {
+		if sigContent == nil {
+			return res, fmt.Errorf("archive does not contain %s", SignatureFile)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigContent)))
+		if err != nil {
+			return res, fmt.Errorf("decoding signature: %w", err)
+		}
+		if !ed25519.Verify(key, sumsContent, sig) {
+			return res, errors.New("invalid signature")
+		}
+		res.Signed = true
+	}
+
+	sums, err := ParseSums(bytes.NewReader(sumsContent))
+	if err != nil {
+		return res, fmt.Errorf("parsing %s: %w", SumsFile, err)
+	}
+
+	problems := []string{}
+	for _, sum := range sums {
+		d, ok := actual[sum.Name]
+		switch {
+		case !ok:
+			problems = append(problems, sum.Name+": missing")
+		case !bytes.Equal(d, sum.Digest):
+			problems = append(problems, sum.Name+": checksum mismatch")
+		}
+		delete(actual, sum.Name)
+	}
+	for name := range actual {
+		problems = append(problems, name+": not listed in "+SumsFile)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return res, errors.New(strings.Join(problems, "; "))
+	}
+
+	res.Files = len(sums)
+	return res, nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/docker/cli/cli/config"
 	"github.com/docker/cli/cli/config/configfile"
+	clitypes "github.com/docker/cli/cli/config/types"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 )
@@ -38,18 +39,29 @@ type dockerCliConfigFileWrapper struct {
 
 func (w dockerCliConfigFileWrapper) GetAuthConfig(registryHostname string) (types.AuthConfig, error) {
 	c, err := w.ConfigFile.GetAuthConfig(registryHostname)
-	wrapped := types.AuthConfig{
+	return fromCliAuthConfig(c), err
+}
+
+func fromCliAuthConfig(c clitypes.AuthConfig) types.AuthConfig {
+	return types.AuthConfig{
 		Username:      c.Username,
 		Password:      c.Password,
 		Auth:          c.Auth,
 		IdentityToken: c.IdentityToken,
 		RegistryToken: c.RegistryToken,
 	}
-	return wrapped, err
 }
 
+// FromConfigFile returns an Authenticator for a docker client config file.
+// Besides the hostnames understood by the docker client, keys in "auths" may be
+// wildcards or path-scoped as described for Rules.
 func FromConfigFile(c *configfile.ConfigFile) Authenticator {
-	return dockerCliConfigFileWrapper{c}
+	rules := NewRules()
+	for key, ac := range c.AuthConfigs {
+		// Invalid patterns are left to the docker client lookup.
+		_ = rules.Add(key, fromCliAuthConfig(ac))
+	}
+	return Chain(rules, dockerCliConfigFileWrapper{c})
 }
 
 func FromReader(r io.Reader) (Authenticator, error) {
@@ -61,22 +73,22 @@ func FromReader(r io.Reader) (Authenticator, error) {
 }
 
 func RegistryAuthFor(a Authenticator, image string) (string, error) {
-	distributionRef, err := reference.ParseNormalizedNamed(image)
+	aa, err := AuthConfigFor(a, image)
 	if err != nil {
 		return "", err
 	}
 
-	authKey := reference.Domain(distributionRef)
-	if authKey == defaultRegistry || authKey == defaultLegacyRegistry {
-		authKey = defaultAuthKey
-	}
+	return encodeAuthToBase64(aa)
+}
 
-	aa, err := a.GetAuthConfig(authKey)
+// AuthConfigFor returns the credentials to pull the image.
+func AuthConfigFor(a Authenticator, image string) (types.AuthConfig, error) {
+	distributionRef, err := reference.ParseNormalizedNamed(image)
 	if err != nil {
-		return "", err
+		return types.AuthConfig{}, err
 	}
 
-	return encodeAuthToBase64(aa)
+	return authConfigFor(a, distributionRef.Name())
 }
 
 // encodeAuthToBase64 serializes the auth configuration as JSON base64 payload
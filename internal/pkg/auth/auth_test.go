@@ -50,6 +50,152 @@ func TestRegistryAuthForEmptyAuthenticator(t *testing.T) {
 	assert.Equal(t, types.AuthConfig{}, decodeAuth64(t, rAuth))
 }
 
+var testScopedAuthConf = `{
+	"auths": {
+		"registry.example.com": {
+			"auth": "` + e([]byte("host:host")) + `"
+		},
+		"registry.example.com/team-a": {
+			"auth": "` + e([]byte("team-a:team-a")) + `"
+		},
+		"*.azurecr.io": {
+			"auth": "` + e([]byte("azure:azure")) + `"
+		}
+	}
+}`
+
+func TestRegistryAuthForScopedConfig(t *testing.T) {
+	subject, err := auth.FromReader(strings.NewReader(testScopedAuthConf))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		image    string
+		expected types.AuthConfig
+	}{
+		{"registry.example.com/app", types.AuthConfig{Username: "host", Password: "host"}},
+		{"registry.example.com/team-a/app:1.0", types.AuthConfig{Username: "team-a", Password: "team-a"}},
+		{"registry.example.com/team-ab/app", types.AuthConfig{Username: "host", Password: "host"}},
+		{"myreg.azurecr.io/app", types.AuthConfig{Username: "azure", Password: "azure"}},
+		{"azurecr.io/app", types.AuthConfig{}},
+		{"busybox", types.AuthConfig{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.image, func(t *testing.T) {
+			rAuth, err := auth.RegistryAuthFor(subject, tc.image)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, decodeAuth64(t, rAuth))
+		})
+	}
+}
+
+func TestRulesPrecedence(t *testing.T) {
+	wildcard := types.AuthConfig{Username: "wildcard"}
+	deepWildcard := types.AuthConfig{Username: "deep-wildcard"}
+	host := types.AuthConfig{Username: "host"}
+	team := types.AuthConfig{Username: "team"}
+	project := types.AuthConfig{Username: "project"}
+	hub := types.AuthConfig{Username: "hub"}
+
+	subject := auth.NewRules()
+	assert.NoError(t, subject.Add("*.example.com", wildcard))
+	assert.NoError(t, subject.Add("*.eu.example.com", deepWildcard))
+	assert.NoError(t, subject.Add("registry.example.com", host))
+	assert.NoError(t, subject.Add("registry.example.com/team", team))
+	assert.NoError(t, subject.Add("registry.example.com/team/project", project))
+	assert.NoError(t, subject.Add("https://index.docker.io/v1/", hub))
+	assert.NoError(t, subject.Add("registry.versioned.io/team/v2", team))
+
+	tests := []struct {
+		repository string
+		expected   types.AuthConfig
+	}{
+		{"other.example.com/app", wildcard},
+		{"registry.eu.example.com/app", deepWildcard},
+		{"registry.example.com/app", host},
+		{"registry.example.com/team/app", team},
+		{"registry.example.com/team/project/app", project},
+		{"docker.io/library/busybox", hub},
+		{"registry.versioned.io/team/v2/app", team},
+		{"registry.versioned.io/team/app", types.AuthConfig{}},
+		{"example.com/app", types.AuthConfig{}},
+		{"unknown.io/app", types.AuthConfig{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.repository, func(t *testing.T) {
+			ac, err := subject.GetRepositoryAuthConfig(tc.repository)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, ac)
+		})
+	}
+}
+
+func TestRulesInvalidPatterns(t *testing.T) {
+	subject := auth.NewRules()
+
+	for _, pattern := range []string{"", "*.example.com/team", "registry.*.com"} {
+		assert.Error(t, subject.Add(pattern, types.AuthConfig{}), pattern)
+	}
+}
+
+func TestChain(t *testing.T) {
+	first := auth.NewRules()
+	assert.NoError(t, first.Add("registry.example.com/team", types.AuthConfig{Username: "first"}))
+	second, err := auth.FromReader(strings.NewReader(testAuthConf))
+	assert.NoError(t, err)
+
+	subject := auth.Chain(first, second)
+
+	tests := []struct {
+		image    string
+		expected types.AuthConfig
+	}{
+		{"registry.example.com/team/app", types.AuthConfig{Username: "first"}},
+		{"test.io/busybox", types.AuthConfig{Username: "test", Password: "test"}},
+		{"busybox", types.AuthConfig{Username: "docker", Password: "docker"}},
+		{"registry.example.com/app", types.AuthConfig{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.image, func(t *testing.T) {
+			rAuth, err := auth.RegistryAuthFor(subject, tc.image)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, decodeAuth64(t, rAuth))
+		})
+	}
+}
+
+func TestMirror(t *testing.T) {
+	rules := auth.NewRules()
+	assert.NoError(t, rules.Add("registry.example.com/team", types.AuthConfig{Username: "team"}))
+	assert.NoError(t, rules.Add("harbor.internal/quay", types.AuthConfig{Username: "harbor"}))
+
+	tests := []struct {
+		mirror, original string
+		expected         types.AuthConfig
+	}{
+		{"mirror.internal/team/app:1.0", "registry.example.com/team/app:1.0", types.AuthConfig{Username: "team"}},
+		{"harbor.internal/quay/foo/bar", "quay.io/foo/bar", types.AuthConfig{Username: "harbor"}},
+		{"mirror.internal/app", "registry.example.com/app", types.AuthConfig{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.mirror, func(t *testing.T) {
+			subject, err := auth.Mirror(rules, tc.mirror, tc.original)
+			assert.NoError(t, err)
+			ac, err := auth.AuthConfigFor(subject, tc.mirror)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, ac)
+
+			// Other images don't get the credentials of the original.
+			ac, err = auth.AuthConfigFor(subject, "mirror.internal/other")
+			assert.NoError(t, err)
+			assert.Equal(t, types.AuthConfig{}, ac)
+		})
+	}
+}
+
 func decodeAuth64(t *testing.T, s string) types.AuthConfig {
 	t.Helper()
 
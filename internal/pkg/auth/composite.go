@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+// RepositoryAuthenticator is implemented by authenticators which can scope
+// credentials to a repository instead of a whole registry.
+type RepositoryAuthenticator interface {
+	// GetRepositoryAuthConfig returns the credentials for a fully qualified
+	// repository name like "docker.io/library/busybox".
+	GetRepositoryAuthConfig(repository string) (types.AuthConfig, error)
+}
+
+// Chain returns an Authenticator asking the given authenticators in order.
+// The first non-empty credentials are returned.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+type chain []Authenticator
+
+func (c chain) GetAuthConfig(registryHostname string) (types.AuthConfig, error) {
+	return c.first(func(a Authenticator) (types.AuthConfig, error) {
+		return a.GetAuthConfig(registryHostname)
+	})
+}
+
+func (c chain) GetRepositoryAuthConfig(repository string) (types.AuthConfig, error) {
+	return c.first(func(a Authenticator) (types.AuthConfig, error) {
+		return authConfigFor(a, repository)
+	})
+}
+
+func (c chain) first(get func(Authenticator) (types.AuthConfig, error)) (types.AuthConfig, error) {
+	for _, a := range c {
+		ac, err := get(a)
+		if err != nil {
+			return types.AuthConfig{}, err
+		}
+		if ac != (types.AuthConfig{}) {
+			return ac, nil
+		}
+	}
+	return types.AuthConfig{}, nil
+}
+
+// Rules is an Authenticator selecting credentials by pattern.
+//
+// A pattern is either a hostname ("registry.example.com"), a hostname with a
+// repository path ("registry.example.com/team-a") or a wildcard matching all
+// subdomains ("*.azurecr.io"). If multiple patterns match, path-scoped patterns
+// win over hostnames, which win over wildcards. Longer patterns win over
+// shorter ones of the same kind.
+type Rules struct {
+	rules []rule
+}
+
+type rule struct {
+	host     string
+	path     string
+	wildcard bool
+	auth     types.AuthConfig
+}
+
+// NewRules returns an empty set of rules.
+func NewRules() *Rules {
+	return &Rules{}
+}
+
+// Add registers credentials for the given pattern.
+func (r *Rules) Add(pattern string, ac types.AuthConfig) error {
+	key := normalizeKey(pattern)
+	host, path := splitRepository(key)
+	if host == "" {
+		return fmt.Errorf("invalid registry pattern %q", pattern)
+	}
+
+	rl := rule{host: host, path: path, auth: ac}
+	if strings.HasPrefix(host, "*.") {
+		if path != "" {
+			return fmt.Errorf("invalid registry pattern %q: wildcards can not be combined with a path", pattern)
+		}
+		rl.wildcard = true
+		rl.host = strings.TrimPrefix(host, "*")
+	}
+	if strings.Contains(rl.host, "*") {
+		return fmt.Errorf("invalid registry pattern %q: only leading wildcards are supported", pattern)
+	}
+
+	r.rules = append(r.rules, rl)
+	return nil
+}
+
+func (r *Rules) GetAuthConfig(registryHostname string) (types.AuthConfig, error) {
+	return r.GetRepositoryAuthConfig(normalizeKey(registryHostname))
+}
+
+func (r *Rules) GetRepositoryAuthConfig(repository string) (types.AuthConfig, error) {
+	host, path := splitRepository(repository)
+	ac, _ := r.match(host, path)
+	return ac, nil
+}
+
+func (r *Rules) match(host, path string) (types.AuthConfig, bool) {
+	var best *rule
+	bestKind, bestLen := -1, -1
+	for i := range r.rules {
+		rl := &r.rules[i]
+
+		var kind, length int
+		switch {
+		case rl.wildcard:
+			if !strings.HasSuffix(host, rl.host) {
+				continue
+			}
+			kind, length = 0, len(rl.host)
+		case rl.host != host:
+			continue
+		case rl.path == "":
+			kind, length = 1, len(rl.host)
+		case path == rl.path || strings.HasPrefix(path, rl.path+"/"):
+			kind, length = 2, len(rl.path)
+		default:
+			continue
+		}
+
+		if kind > bestKind || (kind == bestKind && length > bestLen) {
+			best, bestKind, bestLen = rl, kind, length
+		}
+	}
+	if best == nil {
+		return types.AuthConfig{}, false
+	}
+	return best.auth, true
+}
+
+// normalizeKey converts config file keys like "https://index.docker.io/v1/"
+// into the form used by normalized references ("docker.io"). The API version
+// is only stripped from URLs and bare hosts, as "registry.example.com/team/v2"
+// is a repository path.
+func normalizeKey(key string) string {
+	url := strings.HasPrefix(key, "https://") || strings.HasPrefix(key, "http://")
+	key = strings.TrimPrefix(key, "https://")
+	key = strings.TrimPrefix(key, "http://")
+	key = strings.TrimSuffix(key, "/")
+
+	host, path := splitRepository(key)
+	if path == "v1" || path == "v2" {
+		path = ""
+	} else if url {
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "/v1"), "/v2")
+	}
+	host = strings.ToLower(host)
+	if host == defaultLegacyRegistry || host == "registry-1.docker.io" {
+		host = defaultRegistry
+	}
+	if path == "" {
+		return host
+	}
+	return host + "/" + path
+}
+
+func splitRepository(repository string) (host, path string) {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// Mirror returns an Authenticator for pulling the image original through the
+// mirror reference. The credentials of the mirror repository take precedence;
+// without any, the credentials of the original repository are sent to the
+// mirror.
+func Mirror(a Authenticator, mirror, original string) (Authenticator, error) {
+	m, err := reference.ParseNormalizedNamed(mirror)
+	if err != nil {
+		return nil, err
+	}
+	o, err := reference.ParseNormalizedNamed(original)
+	if err != nil {
+		return nil, err
+	}
+	return mirrorAuthenticator{Authenticator: a, mirror: m.Name(), original: o.Name()}, nil
+}
+
+type mirrorAuthenticator struct {
+	Authenticator
+	mirror, original string
+}
+
+func (m mirrorAuthenticator) GetRepositoryAuthConfig(repository string) (types.AuthConfig, error) {
+	ac, err := authConfigFor(m.Authenticator, repository)
+	if err != nil || ac != (types.AuthConfig{}) || repository != m.mirror {
+		return ac, err
+	}
+	return authConfigFor(m.Authenticator, m.original)
+}
+
+// authConfigFor looks up the credentials for a fully qualified repository name.
+func authConfigFor(a Authenticator, repository string) (types.AuthConfig, error) {
+	if ra, ok := a.(RepositoryAuthenticator); ok {
+		return ra.GetRepositoryAuthConfig(repository)
+	}
+
+	authKey, _ := splitRepository(repository)
+	if authKey == defaultRegistry || authKey == defaultLegacyRegistry {
+		authKey = defaultAuthKey
+	}
+	return a.GetAuthConfig(authKey)
+}
@@ -0,0 +1,415 @@
+// Package bundle pulls images and writes them into an archive. It is the core
+// of the saveomat server and the bundle command.
+package bundle
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/cosign"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/mirror"
+	"github.com/bastjan/saveomat/internal/pkg/registry"
+	"github.com/bastjan/saveomat/internal/pkg/sbom"
+	"github.com/bastjan/saveomat/internal/pkg/split"
+	"github.com/bastjan/saveomat/internal/pkg/vuln"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/client"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// FormatDocker is the format written by `docker save`.
+	FormatDocker = "docker"
+	// FormatOCI is an OCI image layout copied from the registries.
+	FormatOCI = "oci"
+
+	// CompressionNone leaves the archive uncompressed.
+	CompressionNone = "none"
+	// CompressionGzip compresses the archive with gzip.
+	CompressionGzip = "gzip"
+)
+
+// ErrNoImages is returned if none of the requested images exist. Only
+// optional images can be missing without failing the archive.
+var ErrNoImages = errors.New("none of the optional images exist")
+
+// Logger receives messages about skipped images and failed mirrors.
+// echo.Logger implements it.
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// Bundler builds archives of images.
+type Bundler struct {
+	// DockerClient pulls and saves images in FormatDocker.
+	DockerClient client.ImageAPIClient
+	// Mirrors are tried before pulling from the original registry.
+	Mirrors mirror.Rules
+	// RegistryClient copies images in FormatOCI. Required for FormatOCI.
+	RegistryClient *registry.Client
+	// Verifier verifies image signatures if set. Only signed images are
+	// bundled.
+	Verifier *cosign.Verifier
+	// SigningKey signs the checksums added to archives. Archives always
+	// contain checksums if a key is set.
+	SigningKey ed25519.PrivateKey
+	// VulnDB enables vulnerability scans of the bundled images.
+	VulnDB *vuln.DB
+	// FailOn is the minimum severity threshold, see Threshold.
+	FailOn vuln.Severity
+	// SpoolDir holds temporary files, defaults to the system's.
+	SpoolDir string
+	// Progress receives the progress of image pulls if set.
+	Progress io.Writer
+	// Logger is optional.
+	Logger Logger
+}
+
+// Options configure an archive.
+type Options struct {
+	// Format is either FormatDocker or FormatOCI. Defaults to FormatDocker.
+	Format string
+	// Compression is either CompressionNone or CompressionGzip. Defaults to
+	// CompressionNone.
+	Compression string
+	// Checksums adds a SHA256SUMS file to the archive.
+	Checksums bool
+	// Referrers adds signatures, attestations and SBOMs of the images.
+	Referrers bool
+	// SBOM is the format of the SBOMs generated for the images, if any.
+	SBOM string
+	// Scan adds vulnerability reports for the images.
+	Scan bool
+	// FailOn requests a severity threshold, see Threshold.
+	FailOn vuln.Severity
+	// Have are the digests of layers the receiver already has. They are
+	// omitted from the archive.
+	Have map[digest.Digest]bool
+	// Split is the size of the parts of a split archive, if any.
+	Split int64
+}
+
+// Validate checks the options independently of the bundler's configuration.
+func (o Options) Validate() error {
+	if o.Format != "" && o.Format != FormatDocker && o.Format != FormatOCI {
+		return fmt.Errorf("invalid format %q, expected %q or %q", o.Format, FormatDocker, FormatOCI)
+	}
+	if o.Compression != "" && o.Compression != CompressionNone && o.Compression != CompressionGzip {
+		return fmt.Errorf("invalid compression %q, expected %q or %q", o.Compression, CompressionNone, CompressionGzip)
+	}
+	if o.Referrers && o.Format != FormatOCI {
+		return errors.New("referrers require format=oci")
+	}
+	if o.SBOM != "" {
+		if err := sbom.ValidateFormat(o.SBOM); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check rejects options requiring features the bundler is not configured
+// for.
+func (b *Bundler) Check(opts Options) error {
+	if b.scans(opts) && b.VulnDB == nil {
+		return errors.New("vulnerability scanning is not configured")
+	}
+	return nil
+}
+
+func (b *Bundler) scans(opts Options) bool {
+	return opts.Scan || b.Threshold(opts.FailOn) != vuln.SeverityUnknown
+}
+
+// Archive is an archive written in the background.
+type Archive struct {
+	io.Reader
+	// Images are the names of the bundled images.
+	Images []string
+	// Digests are the manifest digests of the images, if known.
+	Digests     []digest.Digest
+	Filename    string
+	ContentType string
+	// Reports are the vulnerability reports of the images if they are
+	// scanned. They are set once the archive has been read completely.
+	Reports []vuln.Report
+
+	closers []io.Closer
+}
+
+// Close stops writing the archive.
+func (a *Archive) Close() error {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i].Close()
+	}
+	return nil
+}
+
+// saved describes the images written by pullAndSave or ociLayout.
+type saved struct {
+	images  []string
+	digests []digest.Digest
+	// files must be added to the archive.
+	files []archive.File
+}
+
+// Build pulls the images and returns their archive processed according to
+// opts. The images must have been normalized and opts checked.
+func (b *Bundler) Build(ctx context.Context, authn auth.Authenticator, images []imagelist.Entry, opts Options) (*Archive, error) {
+	a := &Archive{Filename: "images.tar", ContentType: "application/x-tar"}
+	var tar io.ReadCloser
+	var contents saved
+	var err error
+	if opts.Format == FormatOCI {
+		tar, contents, err = b.ociLayout(ctx, authn, images, opts.Referrers)
+	} else {
+		tar, contents, err = b.pullAndSave(ctx, authn, images)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.Images, a.Digests = contents.images, contents.digests
+	a.closers = append(a.closers, tar)
+
+	copyOpts := archive.CopyOptions{
+		Extra:      contents.files,
+		Checksums:  opts.Checksums,
+		SigningKey: b.SigningKey,
+	}
+	if len(opts.Have) > 0 {
+		if tar, err = b.omitLayers(tar, opts.Have, &copyOpts); err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.closers = append(a.closers, tar)
+	}
+	scan := b.scans(opts)
+	var scanner *sbom.Scanner
+	var appends []func() ([]archive.File, error)
+	if opts.SBOM != "" || scan {
+		scanner = sbom.NewScanner()
+		copyOpts.Observe = scanner.Observe
+	}
+	if opts.SBOM != "" {
+		appends = append(appends, func() ([]archive.File, error) {
+			return sbomFiles(scanner, opts.SBOM)
+		})
+	}
+	if scan {
+		appends = append(appends, func() ([]archive.File, error) {
+			files, r, err := scanFiles(b.VulnDB, scanner)
+			a.Reports = r
+			return files, err
+		})
+	}
+	if len(appends) > 0 {
+		copyOpts.Append = func() ([]archive.File, error) {
+			var files []archive.File
+			for _, a := range appends {
+				f, err := a()
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, f...)
+			}
+			return files, nil
+		}
+	}
+	if opts.Checksums || b.SigningKey != nil || len(copyOpts.Extra) > 0 || scanner != nil {
+		tar = postProcess(tar, copyOpts)
+		a.closers = append(a.closers, tar)
+	}
+
+	if opts.Compression == CompressionGzip {
+		tar = compress(tar)
+		a.closers = append(a.closers, tar)
+		a.Filename, a.ContentType = "images.tar.gz", "application/gzip"
+	}
+	if opts.Split > 0 {
+		tar = splitArchive(tar, a.Filename, opts.Split)
+		a.closers = append(a.closers, tar)
+		a.Filename, a.ContentType = "images.zip", "application/zip"
+	}
+	a.Reader = tar
+	return a, nil
+}
+
+// background returns the output of write, which runs in the background.
+func background(write func(w io.Writer) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+	return pr
+}
+
+// postProcess rewrites the archive in the background.
+func postProcess(r io.Reader, opts archive.CopyOptions) io.ReadCloser {
+	return background(func(w io.Writer) error {
+		return archive.Copy(w, r, opts)
+	})
+}
+
+// splitArchive splits the archive into parts in the background, see split.Zip.
+func splitArchive(r io.Reader, name string, size int64) io.ReadCloser {
+	return background(func(w io.Writer) error {
+		return split.Zip(w, r, name, size)
+	})
+}
+
+// compress compresses r with gzip in the background.
+func compress(r io.Reader) io.ReadCloser {
+	return background(func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if _, err := io.Copy(zw, r); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// sbomFiles encodes the SBOMs of all scanned images.
+func sbomFiles(scanner *sbom.Scanner, format string) ([]archive.File, error) {
+	images, err := scanner.Images()
+	if err != nil {
+		return nil, fmt.Errorf("generating SBOMs: %w", err)
+	}
+	now := time.Now()
+	files := make([]archive.File, 0, len(images))
+	for _, img := range images {
+		content, err := sbom.Encode(img, format, now)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, archive.File{Name: sbom.FileName(img, format), Content: content})
+	}
+	return files, nil
+}
+
+// Normalize validates and normalizes all entries and sets their targets.
+// Images without an explicit target are moved below retagPrefix if it is set.
+// Entries without a platform get the given default platform. A target can
+// only hold one image and platform, so conflicting entries are errors.
+func Normalize(entries []imagelist.Entry, retagPrefix, platform string) ([]imagelist.Entry, error) {
+	if platform != "" {
+		if err := imagelist.ValidatePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := imagelist.Normalize(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs imagelist.Errors
+	normalized := make([]imagelist.Entry, 0, len(entries))
+	// targets are the indexes of the entries by target.
+	targets := map[string]int{}
+	for _, e := range entries {
+		switch {
+		case e.Target != "":
+		case retagPrefix != "":
+			target, err := Retag(e.Source, retagPrefix)
+			if err != nil {
+				errs = append(errs, &imagelist.Error{File: e.File, Line: e.Line, Entry: e.Source, Message: err.Error()})
+				continue
+			}
+			e.Target = target
+		default:
+			e.Target = e.Source
+		}
+		if e.Platform == "" {
+			e.Platform = platform
+		}
+		if i, ok := targets[e.Target]; ok {
+			prev := &normalized[i]
+			switch {
+			case prev.Source != e.Source:
+				errs = append(errs, &imagelist.Error{File: e.File, Line: e.Line, Entry: e.Source, Message: fmt.Sprintf(
+					"%s is already the target of %s", e.Target, prev.Source)})
+			case prev.Platform != e.Platform:
+				errs = append(errs, &imagelist.Error{File: e.File, Line: e.Line, Entry: e.Source, Message: fmt.Sprintf(
+					"%s is already requested for %s; use a different target for each platform", e.Target, platformName(prev.Platform))})
+			default:
+				prev.Optional = prev.Optional && e.Optional
+			}
+			continue
+		}
+		targets[e.Target] = len(normalized)
+		normalized = append(normalized, e)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return normalized, nil
+}
+
+func platformName(platform string) string {
+	if platform == "" {
+		return "the default platform"
+	}
+	return "platform " + platform
+}
+
+// Retag moves the image below the given registry prefix.
+// Images from Docker Hub lose their "library/" path. Images pinned by digest
+// only have no tag to keep and need an explicit target.
+func Retag(img, prefix string) (string, error) {
+	ref, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return "", err
+	}
+	_, tagged := ref.(reference.Tagged)
+	if _, digested := ref.(reference.Digested); digested && !tagged {
+		return "", fmt.Errorf("%s is pinned by digest only and needs an explicit target to be retagged", img)
+	}
+	path := reference.Path(ref)
+	if reference.Domain(ref) == "docker.io" {
+		path = strings.TrimPrefix(path, "library/")
+	}
+
+	retagged := strings.TrimSuffix(prefix, "/") + "/" + path
+	if tagged, ok := ref.(reference.Tagged); ok {
+		retagged += ":" + tagged.Tag()
+	}
+	if _, err := reference.ParseNormalizedNamed(retagged); err != nil {
+		return "", fmt.Errorf("invalid retag prefix %q: %w", prefix, err)
+	}
+	return retagged, nil
+}
+
+func (b *Bundler) progress() io.Writer {
+	if b.Progress == nil {
+		return ioutil.Discard
+	}
+	return b.Progress
+}
+
+func (b *Bundler) infof(format string, args ...interface{}) {
+	if b.Logger != nil {
+		b.Logger.Infof(format, args...)
+	}
+}
+
+func (b *Bundler) warnf(format string, args ...interface{}) {
+	if b.Logger != nil {
+		b.Logger.Warnf(format, args...)
+	}
+}
+
+func isNotFound(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not found") || strings.Contains(msg, "manifest unknown")
+}
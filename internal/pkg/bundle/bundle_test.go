@@ -0,0 +1,178 @@
+//go:generate go run github.com/golang/mock/mockgen -package bundle -destination image_api_client_mock_test.go github.com/docker/docker/client ImageAPIClient
+
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/vuln"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	emptyAuth, err := auth.RegistryAuthFor(auth.EmptyAuthenticator, "busybox")
+	assert.NoError(t, err)
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "docker.io/library/busybox:latest", types.ImagePullOptions{RegistryAuth: emptyAuth, Platform: "linux/arm64"}).
+		Return(ioutil.NopCloser(strings.NewReader(`{"status":"Digest: sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}`)), nil)
+	mc.EXPECT().ImageTag(gomock.Any(), "docker.io/library/busybox:latest", "registry.airgap.local/busybox:latest").Return(nil)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"registry.airgap.local/busybox:latest"}).Return(ioutil.NopCloser(bytes.NewReader(tarOf(t, "images", "test tar"))), nil)
+
+	images, err := Normalize([]imagelist.Entry{{Source: "busybox"}}, "registry.airgap.local", "linux/arm64")
+	assert.NoError(t, err)
+	progress := new(bytes.Buffer)
+	subject := &Bundler{DockerClient: mc, Progress: progress}
+	a, err := subject.Build(context.Background(), auth.EmptyAuthenticator, images, Options{Checksums: true, Compression: CompressionGzip})
+	assert.NoError(t, err)
+	defer a.Close()
+
+	assert.Equal(t, []string{"registry.airgap.local/busybox:latest"}, a.Images)
+	assert.Equal(t, "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", a.Digests[0].String())
+	assert.Equal(t, "images.tar.gz", a.Filename)
+	assert.Equal(t, "application/gzip", a.ContentType)
+
+	zr, err := gzip.NewReader(a)
+	assert.NoError(t, err)
+	res, err := archive.Verify(zr, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Files)
+	assert.Contains(t, progress.String(), "Digest: sha256:0123")
+}
+
+func TestBuildSkipsMissingOptionalImages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/gone:latest", gomock.Any()).Return(nil, errors.New("manifest for gone:latest not found"))
+
+	images, err := Normalize([]imagelist.Entry{{Source: "gone", Optional: true}}, "", "")
+	assert.NoError(t, err)
+	subject := &Bundler{DockerClient: mc}
+	_, err = subject.Build(context.Background(), auth.EmptyAuthenticator, images, Options{})
+	assert.True(t, errors.Is(err, ErrNoImages))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	assert.NoError(t, Options{}.Validate())
+	assert.NoError(t, Options{Format: FormatOCI, Referrers: true, Compression: CompressionNone, SBOM: "spdx"}.Validate())
+
+	for opts, msg := range map[*Options]string{
+		{Format: "zip"}:       `invalid format "zip", expected "docker" or "oci"`,
+		{Compression: "zstd"}: `invalid compression "zstd", expected "none" or "gzip"`,
+		{Referrers: true}:     "referrers require format=oci",
+	} {
+		assert.EqualError(t, opts.Validate(), msg)
+	}
+	assert.Error(t, Options{SBOM: "xml"}.Validate())
+}
+
+func TestCheck(t *testing.T) {
+	subject := &Bundler{}
+	assert.NoError(t, subject.Check(Options{}))
+	assert.EqualError(t, subject.Check(Options{Scan: true}), "vulnerability scanning is not configured")
+	assert.EqualError(t, subject.Check(Options{FailOn: vuln.SeverityHigh}), "vulnerability scanning is not configured")
+}
+
+func TestThreshold(t *testing.T) {
+	b := &Bundler{}
+	assert.Equal(t, vuln.SeverityUnknown, b.Threshold(vuln.SeverityUnknown))
+	assert.Equal(t, vuln.SeverityHigh, b.Threshold(vuln.SeverityHigh))
+
+	b.FailOn = vuln.SeverityHigh
+	assert.Equal(t, vuln.SeverityHigh, b.Threshold(vuln.SeverityUnknown))
+	assert.Equal(t, vuln.SeverityHigh, b.Threshold(vuln.SeverityCritical))
+	assert.Equal(t, vuln.SeverityLow, b.Threshold(vuln.SeverityLow))
+}
+
+func TestFailedReports(t *testing.T) {
+	reports := []vuln.Report{
+		{Image: "clean"},
+		{Image: "medium", Vulnerabilities: []vuln.Finding{{ID: "A", Severity: vuln.SeverityMedium}}},
+		{Image: "critical", Vulnerabilities: []vuln.Finding{{ID: "B", Severity: vuln.SeverityCritical}}},
+		{Image: "unreadable", Errors: []string{"RPM database /var/lib/rpm/Packages could not be read: unknown database format"}},
+	}
+	images := func(reports []vuln.Report) []string {
+		names := []string{}
+		for _, r := range reports {
+			names = append(names, r.Image)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"critical", "unreadable"}, images(FailedReports(reports, vuln.SeverityHigh)))
+	assert.Equal(t, []string{"medium", "critical", "unreadable"}, images(FailedReports(reports, vuln.SeverityLow)))
+}
+
+func TestNormalize(t *testing.T) {
+	images, err := Normalize([]imagelist.Entry{
+		{Source: "busybox"},
+		{Source: "nginx:1.25", Target: "registry.airgap.local/nginx:1.25"},
+		{Source: "ghcr.io/foo/bar:v1", Platform: "linux/arm64"},
+	}, "registry.airgap.local/mirror", "linux/amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, []imagelist.Entry{
+		{Source: "docker.io/library/busybox:latest", Target: "registry.airgap.local/mirror/busybox:latest", Platform: "linux/amd64"},
+		{Source: "docker.io/library/nginx:1.25", Target: "registry.airgap.local/nginx:1.25", Platform: "linux/amd64"},
+		{Source: "ghcr.io/foo/bar:v1", Target: "registry.airgap.local/mirror/foo/bar:v1", Platform: "linux/arm64"},
+	}, images)
+
+	_, err = Normalize([]imagelist.Entry{{Line: 3, Source: "busybox", Target: "Invalid"}}, "", "")
+	assert.Error(t, err)
+	_, err = Normalize([]imagelist.Entry{{Source: "busybox"}}, "Invalid Prefix", "")
+	assert.Error(t, err)
+	_, err = Normalize([]imagelist.Entry{{Source: "busybox"}}, "", "linux")
+	assert.Error(t, err)
+
+	_, err = Normalize([]imagelist.Entry{
+		{Line: 1, Source: "busybox"},
+		{Line: 2, Source: "busybox", Platform: "linux/arm64"},
+	}, "", "")
+	assert.EqualError(t, err, ":2: docker.io/library/busybox:latest is already requested for the default platform; use a different target for each platform")
+	images, err = Normalize([]imagelist.Entry{
+		{Source: "busybox", Platform: "linux/amd64"},
+		{Source: "busybox", Target: "busybox:arm64", Platform: "linux/arm64"},
+		{Source: "busybox"},
+	}, "", "linux/amd64")
+	assert.NoError(t, err)
+	assert.Len(t, images, 2)
+	_, err = Normalize([]imagelist.Entry{
+		{Line: 1, Source: "busybox", Target: "registry.airgap.local/base"},
+		{Line: 2, Source: "alpine", Target: "registry.airgap.local/base"},
+	}, "", "")
+	assert.EqualError(t, err, ":2: registry.airgap.local/base:latest is already the target of docker.io/library/busybox:latest")
+
+	digest := "sha256:" + strings.Repeat("0", 64)
+	_, err = Normalize([]imagelist.Entry{{Line: 2, Source: "busybox@" + digest}}, "registry.airgap.local", "")
+	assert.EqualError(t, err, ":2: docker.io/library/busybox@"+digest+" is pinned by digest only and needs an explicit target to be retagged")
+	images, err = Normalize([]imagelist.Entry{
+		{Source: "busybox:1.36@" + digest},
+		{Source: "alpine@" + digest, Target: "registry.airgap.local/alpine:3.19"},
+	}, "registry.airgap.local", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.airgap.local/busybox:1.36", images[0].Target)
+	assert.Equal(t, "registry.airgap.local/alpine:3.19", images[1].Target)
+}
+
+func tarOf(t *testing.T, name, content string) []byte {
+	t.Helper()
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(content)), Mode: 0o644}))
+	_, err := io.WriteString(tw, content)
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	return b.Bytes()
+}
@@ -0,0 +1,61 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/delta"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// omitLayers spools the archive to find its layers and configures opts to
+// omit the layers the receiver already has. The omitted layers are listed in
+// delta.ManifestFile.
+func (b *Bundler) omitLayers(r io.Reader, have map[digest.Digest]bool, opts *archive.CopyOptions) (io.ReadCloser, error) {
+	f, err := Spool(b.SpoolDir, r)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := delta.Inspect(f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		CleanupSpool(f)
+		return nil, err
+	}
+
+	manifest := delta.Manifest{Omitted: []delta.Layer{}}
+	skip := map[string]bool{}
+	for _, l := range contents.Layers {
+		if have[l.Digest] {
+			manifest.Omitted = append(manifest.Omitted, l)
+			skip[l.Name] = true
+		}
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		CleanupSpool(f)
+		return nil, err
+	}
+
+	opts.Skip = func(hdr *tar.Header) bool {
+		return skip[path.Clean(hdr.Name)]
+	}
+	opts.Extra = append(opts.Extra, archive.File{Name: delta.ManifestFile, Content: append(content, '\n')})
+	return spooledFile{f}, nil
+}
+
+// spooledFile removes the spooled file once it is closed.
+type spooledFile struct {
+	*os.File
+}
+
+func (f spooledFile) Close() error {
+	CleanupSpool(f.File)
+	return nil
+}
@@ -0,0 +1,160 @@
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	digest "github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
+)
+
+// pullAndSave pulls the images and returns the archive written by the docker
+// daemon and its contents. If signature verification is enabled, images are
+// pulled by their verified digest.
+func (b *Bundler) pullAndSave(ctx context.Context, authn auth.Authenticator, images []imagelist.Entry) (io.ReadCloser, saved, error) {
+
+	g, errCtx := errgroup.WithContext(ctx)
+
+	pulled := make([]bool, len(images))
+	digests := make([]digest.Digest, len(images))
+	signatures := make([][]archive.File, len(images))
+	for i, img := range images {
+		i, img := i, img
+		g.Go(func() error {
+			source := img.Source
+			if b.Verifier != nil {
+				pinned, files, err := b.verifyImage(errCtx, authn, img.Source)
+				if err != nil && img.Optional && isNotFound(err) {
+					b.infof("skipping optional image %s: %v", img.Source, err)
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				source, signatures[i] = pinned, files
+			}
+
+			local, d, err := b.pullImage(errCtx, authn, source, img.Platform)
+			if err != nil && img.Optional && isNotFound(err) {
+				b.infof("skipping optional image %s: %v", img.Source, err)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			pulled[i], digests[i] = true, d
+			if local == img.Target {
+				return nil
+			}
+			return b.DockerClient.ImageTag(errCtx, local, img.Target)
+		})
+
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, saved{}, err
+	}
+
+	var contents saved
+	seen := map[string]bool{}
+	for i, img := range images {
+		if !pulled[i] {
+			continue
+		}
+		contents.images = append(contents.images, img.Target)
+		contents.digests = append(contents.digests, digests[i])
+		for _, f := range signatures[i] {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				contents.files = append(contents.files, f)
+			}
+		}
+	}
+	if len(contents.images) == 0 {
+		return nil, saved{}, ErrNoImages
+	}
+	tar, err := b.DockerClient.ImageSave(ctx, contents.images)
+	return tar, contents, err
+}
+
+// pullImage pulls the image through the first matching mirror, falling back to
+// the original registry. It returns the reference and the digest of the pulled
+// image. Images pulled from a mirror are tagged with their original reference
+// unless they are referenced by digest only. Mirrors without credentials of
+// their own get the credentials of the original registry.
+func (b *Bundler) pullImage(ctx context.Context, authn auth.Authenticator, img, platform string) (string, digest.Digest, error) {
+	mirrored, ok, err := b.Mirrors.Rewrite(img)
+	if err != nil {
+		return "", "", err
+	}
+	if ok {
+		mirrorAuth, err := auth.Mirror(authn, mirrored, img)
+		if err != nil {
+			return "", "", err
+		}
+		d, err := b.pull(ctx, mirrorAuth, mirrored, platform)
+		if err == nil {
+			if !isTagged(img) {
+				return mirrored, d, nil
+			}
+			return img, d, b.DockerClient.ImageTag(ctx, mirrored, img)
+		}
+		b.warnf("pulling %s from mirror %s failed, falling back to original: %v", img, mirrored, err)
+	}
+	d, err := b.pull(ctx, authn, img, platform)
+	return img, d, err
+}
+
+func isTagged(img string) bool {
+	ref, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return false
+	}
+	_, ok := ref.(reference.Tagged)
+	return ok
+}
+
+// pull pulls the image and returns its digest as reported by the daemon, if
+// any.
+func (b *Bundler) pull(ctx context.Context, authn auth.Authenticator, img, platform string) (digest.Digest, error) {
+	encodedAuth, err := auth.RegistryAuthFor(authn, img)
+	if err != nil {
+		return "", err
+	}
+	rc, err := b.DockerClient.ImagePull(ctx, img, types.ImagePullOptions{
+		RegistryAuth: encodedAuth,
+		Platform:     platform,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var d digest.Digest
+	dec := json.NewDecoder(rc)
+	for {
+		var jm jsonmessage.JSONMessage
+		if err := dec.Decode(&jm); err == io.EOF {
+			return d, nil
+		} else if err != nil {
+			return "", err
+		}
+		if jm.Error != nil {
+			return "", jm.Error
+		}
+		if strings.HasPrefix(jm.Status, "Digest: ") {
+			d = digest.Digest(strings.TrimPrefix(jm.Status, "Digest: "))
+		}
+		if err := jm.Display(b.progress(), false); err != nil {
+			return "", err
+		}
+	}
+}
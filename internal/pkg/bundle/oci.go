@@ -0,0 +1,318 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/ocilayout"
+	"github.com/bastjan/saveomat/internal/pkg/registry"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
+)
+
+// cosignTagSuffixes are the tags cosign stores signatures, attestations and
+// SBOMs under, following the "sha256-<digest>" prefix.
+var cosignTagSuffixes = []string{".sig", ".att", ".sbom"}
+
+// ociImage is an image resolved for an OCI layout.
+type ociImage struct {
+	// manifests are listed in the index of the layout.
+	manifests []ocispec.Descriptor
+	// blobs are all blobs of the image and its artifacts in copy order.
+	blobs []ociBlob
+}
+
+// ociBlob is a blob copied from a registry.
+type ociBlob struct {
+	repo reference.Named
+	ac   types.AuthConfig
+	desc ocispec.Descriptor
+	// content is set for manifests, which are fetched while resolving.
+	content []byte
+}
+
+// ociLayout copies the images from their registries into an OCI image
+// layout without involving the docker daemon. Manifests are resolved before
+// the archive is returned, blobs are streamed while reading it.
+func (b *Bundler) ociLayout(ctx context.Context, authn auth.Authenticator, images []imagelist.Entry, withReferrers bool) (io.ReadCloser, saved, error) {
+	g, errCtx := errgroup.WithContext(ctx)
+
+	resolved := make([]*ociImage, len(images))
+	signatures := make([][]archive.File, len(images))
+	for i, img := range images {
+		i, img := i, img
+		g.Go(func() error {
+			r, files, err := b.resolveOCI(errCtx, authn, img, withReferrers)
+			if err != nil && img.Optional && isNotFound(err) {
+				b.infof("skipping optional image %s: %v", img.Source, err)
+				return nil
+			}
+			resolved[i], signatures[i] = r, files
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, saved{}, err
+	}
+
+	var contents saved
+	var included []*ociImage
+	seen := map[string]bool{}
+	for i, img := range images {
+		if resolved[i] == nil {
+			continue
+		}
+		contents.images = append(contents.images, img.Target)
+		contents.digests = append(contents.digests, resolved[i].manifests[0].Digest)
+		included = append(included, resolved[i])
+		for _, f := range signatures[i] {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				contents.files = append(contents.files, f)
+			}
+		}
+	}
+	if len(included) == 0 {
+		return nil, saved{}, ErrNoImages
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(b.writeOCILayout(ctx, pw, included))
+	}()
+	return pr, contents, nil
+}
+
+func (b *Bundler) writeOCILayout(ctx context.Context, w io.Writer, images []*ociImage) error {
+	lw := ocilayout.NewWriter(w)
+	listed := map[string]bool{}
+	for _, img := range images {
+		for _, blob := range img.blobs {
+			if lw.HasBlob(blob.desc.Digest) {
+				continue
+			}
+			if err := b.copyBlob(ctx, lw, blob); err != nil {
+				return err
+			}
+		}
+		for _, m := range img.manifests {
+			key := m.Digest.String() + " " + m.Annotations[ocispec.AnnotationRefName]
+			if !listed[key] {
+				listed[key] = true
+				lw.AddManifest(m)
+			}
+		}
+	}
+	return lw.Close()
+}
+
+func (b *Bundler) copyBlob(ctx context.Context, lw *ocilayout.Writer, blob ociBlob) error {
+	if blob.content != nil {
+		return lw.WriteBlob(blob.desc, bytes.NewReader(blob.content))
+	}
+	rc, err := b.RegistryClient.Blob(ctx, blob.repo, blob.desc.Digest, blob.ac)
+	if err != nil {
+		return fmt.Errorf("copying blob %s of %s: %w", blob.desc.Digest, blob.repo.Name(), err)
+	}
+	defer rc.Close()
+	return lw.WriteBlob(blob.desc, rc)
+}
+
+// resolveOCI resolves the image through the first matching mirror, falling
+// back to the original registry.
+func (b *Bundler) resolveOCI(ctx context.Context, authn auth.Authenticator, img imagelist.Entry, withReferrers bool) (*ociImage, []archive.File, error) {
+	source := img.Source
+	var files []archive.File
+	if b.Verifier != nil {
+		pinned, signatures, err := b.verifyImage(ctx, authn, img.Source)
+		if err != nil {
+			return nil, nil, err
+		}
+		source, files = pinned, signatures
+	}
+
+	mirrored, ok, err := b.Mirrors.Rewrite(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		mirrorAuth, err := auth.Mirror(authn, mirrored, source)
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := b.resolveOCIFrom(ctx, mirrorAuth, mirrored, img, withReferrers)
+		if err == nil {
+			return r, files, nil
+		}
+		b.warnf("resolving %s from mirror %s failed, falling back to original: %v", img.Source, mirrored, err)
+	}
+	r, err := b.resolveOCIFrom(ctx, authn, source, img, withReferrers)
+	return r, files, err
+}
+
+func (b *Bundler) resolveOCIFrom(ctx context.Context, authn auth.Authenticator, source string, img imagelist.Entry, withReferrers bool) (*ociImage, error) {
+	ref, err := reference.ParseNormalizedNamed(source)
+	if err != nil {
+		return nil, err
+	}
+	target, err := reference.ParseNormalizedNamed(img.Target)
+	if err != nil {
+		return nil, err
+	}
+	ac, err := auth.AuthConfigFor(authn, source)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := b.RegistryClient.Manifest(ctx, ref, ac)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ociImage{}
+	subjects := []digest.Digest{}
+	desc, err := r.addManifest(ctx, b.RegistryClient, ref, ac, m, img.Platform, &subjects)
+	if err != nil {
+		return nil, err
+	}
+	desc.Annotations = map[string]string{
+		ocispec.AnnotationRefName:               img.Target,
+		ocilayout.AnnotationContainerdImageName: img.Target,
+	}
+	r.manifests = append(r.manifests, desc)
+
+	if withReferrers {
+		for _, subject := range subjects {
+			if err := r.addArtifacts(ctx, b.RegistryClient, ref, target, ac, subject); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// addManifest adds the manifest and everything it references. Of an index,
+// only the manifest matching the platform is added if one is requested. The
+// digests of all added manifests are appended to subjects unless it is nil,
+// including the digest of an index that is left out, as signatures usually
+// refer to it.
+func (r *ociImage) addManifest(ctx context.Context, c *registry.Client, repo reference.Named, ac types.AuthConfig, m *registry.Manifest, platform string, subjects *[]digest.Digest) (ocispec.Descriptor, error) {
+	if m.IsIndex() {
+		idx, err := m.Index()
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+
+		var children []ocispec.Descriptor
+		for _, d := range idx.Manifests {
+			if platform == "" || (d.Platform != nil && MatchesPlatform(PlatformString(*d.Platform), platform)) {
+				children = append(children, d)
+			}
+		}
+		if len(children) == 0 {
+			return ocispec.Descriptor{}, fmt.Errorf("platform %s is not available", platform)
+		}
+		if platform != "" {
+			children = children[:1]
+		}
+
+		for _, d := range children {
+			digested, err := reference.WithDigest(reference.TrimNamed(repo), d.Digest)
+			if err != nil {
+				return ocispec.Descriptor{}, err
+			}
+			child, err := c.Manifest(ctx, digested, ac)
+			if err != nil {
+				return ocispec.Descriptor{}, err
+			}
+			childDesc, err := r.addManifest(ctx, c, repo, ac, child, "", subjects)
+			if err != nil {
+				return ocispec.Descriptor{}, err
+			}
+			if platform != "" {
+				childDesc.Platform = d.Platform
+				if subjects != nil {
+					*subjects = append(*subjects, m.Digest)
+				}
+				return childDesc, nil
+			}
+		}
+	} else {
+		mf, err := m.Image()
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		for _, d := range append([]ocispec.Descriptor{mf.Config}, mf.Layers...) {
+			r.blobs = append(r.blobs, ociBlob{repo: repo, ac: ac, desc: d})
+		}
+	}
+
+	desc := ocispec.Descriptor{MediaType: m.MediaType, Digest: m.Digest, Size: m.Size}
+	r.blobs = append(r.blobs, ociBlob{repo: repo, ac: ac, desc: desc, content: m.Content})
+	if subjects != nil {
+		*subjects = append(*subjects, m.Digest)
+	}
+	return desc, nil
+}
+
+// addArtifacts adds the referrers of the subject and the artifacts stored
+// using cosign's tag scheme. Tagged artifacts are named below the target
+// repository.
+func (r *ociImage) addArtifacts(ctx context.Context, c *registry.Client, repo, target reference.Named, ac types.AuthConfig, subject digest.Digest) error {
+	referrers, err := c.Referrers(ctx, repo, subject, ac)
+	if err != nil {
+		return fmt.Errorf("listing referrers of %s: %w", subject, err)
+	}
+	for _, d := range referrers {
+		digested, err := reference.WithDigest(reference.TrimNamed(repo), d.Digest)
+		if err != nil {
+			return err
+		}
+		m, err := c.Manifest(ctx, digested, ac)
+		if err != nil {
+			return fmt.Errorf("fetching referrer %s: %w", d.Digest, err)
+		}
+		desc, err := r.addManifest(ctx, c, repo, ac, m, "", nil)
+		if err != nil {
+			return err
+		}
+		r.manifests = append(r.manifests, desc)
+	}
+
+	for _, suffix := range cosignTagSuffixes {
+		tag := subject.Algorithm().String() + "-" + subject.Encoded() + suffix
+		tagged, err := reference.WithTag(reference.TrimNamed(repo), tag)
+		if err != nil {
+			return err
+		}
+		m, err := c.Manifest(ctx, tagged, ac)
+		var regErr *registry.Error
+		if errors.As(err, &regErr) && regErr.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("fetching %s: %w", tagged, err)
+		}
+		desc, err := r.addManifest(ctx, c, repo, ac, m, "", nil)
+		if err != nil {
+			return err
+		}
+		name := target.Name() + ":" + tag
+		desc.Annotations = map[string]string{
+			ocispec.AnnotationRefName:               name,
+			ocilayout.AnnotationContainerdImageName: name,
+		}
+		r.manifests = append(r.manifests, desc)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package bundle
+
+import (
+	"runtime"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PlatformString formats the platform like "linux/arm64/v8".
+func PlatformString(p ocispec.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// DefaultPlatform returns the platform the docker daemon pulls if none is
+// requested, assuming it runs on the same architecture as saveomat.
+func DefaultPlatform(platform string) string {
+	if platform == "" {
+		return "linux/" + runtime.GOARCH
+	}
+	return platform
+}
+
+// MatchesPlatform reports whether the platform satisfies the wanted
+// platform. The variant is only compared if requested.
+func MatchesPlatform(platform, want string) bool {
+	want = DefaultPlatform(want)
+	return platform == want || (strings.Count(want, "/") == 1 && strings.HasPrefix(platform, want+"/"))
+}
@@ -0,0 +1,51 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/sbom"
+	"github.com/bastjan/saveomat/internal/pkg/vuln"
+)
+
+// Threshold returns the stricter of the bundler's and the requested severity
+// threshold. SeverityUnknown disables the gate.
+func (b *Bundler) Threshold(requested vuln.Severity) vuln.Severity {
+	if b.FailOn == vuln.SeverityUnknown || (requested != vuln.SeverityUnknown && requested < b.FailOn) {
+		return requested
+	}
+	return b.FailOn
+}
+
+// FailedReports returns the reports with vulnerabilities at or above the
+// threshold and those of images that could not be scanned completely.
+func FailedReports(reports []vuln.Report, threshold vuln.Severity) []vuln.Report {
+	var failed []vuln.Report
+	for _, r := range reports {
+		if len(r.Errors) > 0 || len(r.AtLeast(threshold)) > 0 {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// scanFiles scans all images and encodes a report per image.
+func scanFiles(db *vuln.DB, scanner *sbom.Scanner) ([]archive.File, []vuln.Report, error) {
+	images, err := scanner.Images()
+	if err != nil {
+		return nil, nil, fmt.Errorf("scanning images: %w", err)
+	}
+	files := make([]archive.File, 0, len(images))
+	reports := make([]vuln.Report, 0, len(images))
+	for _, img := range images {
+		report := db.Scan(img)
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, archive.File{Name: "scans/" + sbom.BaseName(img) + ".json", Content: append(content, '\n')})
+		reports = append(reports, report)
+	}
+	return files, reports, nil
+}
@@ -0,0 +1,31 @@
+package bundle
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Spool copies r into a temporary file in dir. The returned file is positioned
+// at its start and must be removed using CleanupSpool.
+func Spool(dir string, r io.Reader) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, "saveomat-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		CleanupSpool(f)
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		CleanupSpool(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// CleanupSpool closes and removes a file created by Spool.
+func CleanupSpool(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,55 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/cosign"
+	"github.com/docker/distribution/reference"
+)
+
+// verifyImage checks the cosign signatures of the image. It returns the
+// image pinned to the verified digest and the signatures to add to the
+// archive.
+func (b *Bundler) verifyImage(ctx context.Context, authn auth.Authenticator, img string) (string, []archive.File, error) {
+	ref, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return "", nil, err
+	}
+	ac, err := auth.AuthConfigFor(authn, img)
+	if err != nil {
+		return "", nil, err
+	}
+
+	res, err := b.Verifier.Verify(ctx, ref, ac)
+	if errors.Is(err, cosign.ErrNoValidSignature) {
+		return "", nil, fmt.Errorf("image %s: %w", img, err)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	pinned, err := reference.WithDigest(reference.TrimNamed(ref), res.Digest)
+	if err != nil {
+		return "", nil, err
+	}
+	return pinned.String(), signatureFiles(res), nil
+}
+
+// signatureFiles stores the signatures below cosign/<signature tag>/ so they
+// can be checked with `cosign verify-blob`.
+func signatureFiles(res cosign.Result) []archive.File {
+	dir := "cosign/" + cosign.SignatureTag(res.Digest) + "/"
+	files := make([]archive.File, 0, 2*len(res.Signatures))
+	for i, sig := range res.Signatures {
+		name := fmt.Sprintf("%spayload-%d.json", dir, i)
+		files = append(files,
+			archive.File{Name: name, Content: sig.Payload},
+			archive.File{Name: name + ".sig", Content: []byte(sig.Base64Signature)},
+		)
+	}
+	return files
+}
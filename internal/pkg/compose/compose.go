@@ -0,0 +1,186 @@
+// Package compose extracts image references from docker-compose files.
+package compose
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type composeFile struct {
+	Services map[string]struct {
+		Image string `yaml:"image"`
+	} `yaml:"services"`
+}
+
+// Images returns the images of all services defined in the given compose
+// files. Later files override earlier ones like `docker-compose -f a -f b` does.
+// Variables in image references are interpolated from env.
+func Images(files []io.Reader, env map[string]string) ([]string, error) {
+	services := map[string]string{}
+	for _, f := range files {
+		var cf composeFile
+		if err := yaml.NewDecoder(f).Decode(&cf); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parsing compose file: %w", err)
+		}
+		for name, svc := range cf.Services {
+			if svc.Image != "" {
+				services[name] = svc.Image
+			}
+		}
+	}
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := map[string]bool{}
+	images := make([]string, 0, len(names))
+	for _, name := range names {
+		img, err := Interpolate(services[name], env)
+		if err != nil {
+			return nil, fmt.Errorf("service %q: %w", name, err)
+		}
+		if img == "" || seen[img] {
+			continue
+		}
+		seen[img] = true
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// ParseEnv parses a .env file of KEY=VALUE lines.
+func ParseEnv(r io.Reader) (map[string]string, error) {
+	env := map[string]string{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch {
+		case len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0]:
+			value = value[1 : len(value)-1]
+		case strings.Contains(value, " #"):
+			value = strings.TrimSpace(value[:strings.Index(value, " #")])
+		}
+		env[key] = value
+	}
+	return env, sc.Err()
+}
+
+// Interpolate replaces $VAR, ${VAR}, ${VAR:-default}, ${VAR-default},
+// ${VAR:?error} and ${VAR?error} in s. $$ is an escaped $.
+func Interpolate(s string, env map[string]string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := closingBrace(s[i+2:])
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable in %q", s)
+			}
+			v, err := expand(s[i+2:i+2+end], env)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i += 2 + end
+		case isNameChar(next, true):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j], false) {
+				j++
+			}
+			b.WriteString(env[s[i+1:j]])
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+// closingBrace returns the index of the brace closing a variable whose
+// expression starts s, skipping nested variables in defaults, or -1.
+func closingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+func expand(expr string, env map[string]string) (string, error) {
+	n := 0
+	for n < len(expr) && isNameChar(expr[n], n == 0) {
+		n++
+	}
+	name, op := expr[:n], expr[n:]
+	if name == "" {
+		return "", fmt.Errorf("invalid variable ${%s}", expr)
+	}
+	value, set := env[name]
+
+	switch {
+	case op == "":
+		return value, nil
+	case strings.HasPrefix(op, ":-"):
+		if value == "" {
+			return Interpolate(op[2:], env)
+		}
+	case strings.HasPrefix(op, "-"):
+		if !set {
+			return Interpolate(op[1:], env)
+		}
+	case strings.HasPrefix(op, ":?"):
+		if value == "" {
+			return "", fmt.Errorf("required variable %s is missing a value: %s", name, op[2:])
+		}
+	case strings.HasPrefix(op, "?"):
+		if !set {
+			return "", fmt.Errorf("required variable %s is missing a value: %s", name, op[1:])
+		}
+	default:
+		return "", fmt.Errorf("invalid variable ${%s}", expr)
+	}
+	return value, nil
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
@@ -0,0 +1,101 @@
+package compose_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/compose"
+	"github.com/stretchr/testify/assert"
+)
+
+var testCompose = `
+version: "3.8"
+services:
+  web:
+    image: "nginx:${NGINX_VERSION:-1.25}"
+  app:
+    image: ${REGISTRY}/app:$TAG
+  worker:
+    image: ${REGISTRY}/app:$TAG
+  builder:
+    build: .
+`
+
+var testOverride = `
+services:
+  web:
+    image: nginx:1.25-alpine
+  db:
+    image: postgres:15
+`
+
+func TestImages(t *testing.T) {
+	env := map[string]string{"REGISTRY": "registry.example.com", "TAG": "v1"}
+
+	images, err := compose.Images([]io.Reader{strings.NewReader(testCompose)}, env)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry.example.com/app:v1", "nginx:1.25"}, images)
+
+	images, err = compose.Images([]io.Reader{strings.NewReader(testCompose), strings.NewReader(testOverride)}, env)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry.example.com/app:v1", "postgres:15", "nginx:1.25-alpine"}, images)
+
+	_, err = compose.Images([]io.Reader{strings.NewReader("services: [")}, env)
+	assert.Error(t, err)
+}
+
+func TestParseEnv(t *testing.T) {
+	env, err := compose.ParseEnv(strings.NewReader(`
+# comment
+REGISTRY=registry.example.com
+export TAG="v1 beta"
+EMPTY=
+INLINE=value # comment
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"REGISTRY": "registry.example.com",
+		"TAG":      "v1 beta",
+		"EMPTY":    "",
+		"INLINE":   "value",
+	}, env)
+
+	_, err = compose.ParseEnv(strings.NewReader("NOVALUE"))
+	assert.Error(t, err)
+}
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"SET": "set", "EMPTY": ""}
+
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"$SET/${SET}", "set/set"},
+		{"$UNSET", ""},
+		{"${EMPTY:-default}", "default"},
+		{"${EMPTY-default}", ""},
+		{"${UNSET-default}", "default"},
+		{"${UNSET:-$SET}", "set"},
+		{"${UNSET:-${SET}}/app", "set/app"},
+		{"${UNSET:-${OTHER:-${SET}-x}}:1.0", "set-x:1.0"},
+		{"${SET:-${UNSET}}", "set"},
+		{"${UNSET:-$${SET}}", "${SET}"},
+		{"$${SET}", "${SET}"},
+		{"price: 5$", "price: 5$"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			out, err := compose.Interpolate(tc.in, env)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, out)
+		})
+	}
+
+	for _, in := range []string{"${EMPTY:?required}", "${UNSET?required}", "${SET", "${}", "${SET/x}", "${UNSET:-${SET}"} {
+		_, err := compose.Interpolate(in, env)
+		assert.Error(t, err, in)
+	}
+}
@@ -0,0 +1,228 @@
+// Package cosign verifies cosign image signatures against local public keys.
+//
+// Signatures are looked up using cosign's tag scheme: the signatures of an
+// image with digest sha256:abc are stored in the same repository under the tag
+// "sha256-abc.sig". Transparency logs and keyless signatures are not supported.
+package cosign
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/registry"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// MediaTypeSimpleSigning is the media type of signature payloads.
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// AnnotationSignature holds the base64 encoded signature of a payload.
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+)
+
+// maxPayloadSize limits the size of signature payloads read into memory.
+const maxPayloadSize = 1 << 20
+
+// ErrNoValidSignature is returned if an image has no signature matching any
+// of the keys.
+var ErrNoValidSignature = errors.New("no valid signature")
+
+// Signature is a signed simple signing payload.
+type Signature struct {
+	Payload []byte
+	// Base64Signature is the signature of Payload as stored in the
+	// signature annotation.
+	Base64Signature string
+}
+
+// Payload is the simple signing payload signed by cosign.
+type Payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional,omitempty"`
+}
+
+// Result describes a verified image.
+type Result struct {
+	// Digest is the verified manifest digest of the image.
+	Digest digest.Digest
+	// Signatures are the signatures matching a key.
+	Signatures []Signature
+}
+
+// Verifier verifies image signatures.
+type Verifier struct {
+	Client *registry.Client
+	Keys   []crypto.PublicKey
+}
+
+// SignatureTag returns the tag holding the signatures of the manifest digest.
+func SignatureTag(dgst digest.Digest) string {
+	return dgst.Algorithm().String() + "-" + dgst.Encoded() + ".sig"
+}
+
+// Verify resolves the image and checks that it has at least one signature
+// created by one of the keys. Errors wrap ErrNoValidSignature if the image
+// is unsigned.
+func (v *Verifier) Verify(ctx context.Context, ref reference.Named, ac types.AuthConfig) (Result, error) {
+	m, err := v.Client.Manifest(ctx, ref, ac)
+	if err != nil {
+		return Result{}, err
+	}
+
+	sigs, err := v.Signatures(ctx, ref, m.Digest, ac)
+	if err != nil {
+		return Result{}, err
+	}
+	valid := VerifySignatures(sigs, v.Keys, m.Digest)
+	if len(valid) == 0 {
+		return Result{}, fmt.Errorf("%s@%s: %w", ref.Name(), m.Digest, ErrNoValidSignature)
+	}
+	return Result{Digest: m.Digest, Signatures: valid}, nil
+}
+
+// Signatures fetches the signatures of the manifest digest from the
+// repository. A missing signature tag is reported as ErrNoValidSignature.
+func (v *Verifier) Signatures(ctx context.Context, repo reference.Named, dgst digest.Digest, ac types.AuthConfig) ([]Signature, error) {
+	sigRef, err := reference.WithTag(reference.TrimNamed(repo), SignatureTag(dgst))
+	if err != nil {
+		return nil, err
+	}
+	m, err := v.Client.Manifest(ctx, sigRef, ac)
+	if err != nil {
+		var re *registry.Error
+		if errors.As(err, &re) && re.StatusCode == 404 {
+			return nil, fmt.Errorf("%s@%s: %w: image is not signed", repo.Name(), dgst, ErrNoValidSignature)
+		}
+		return nil, fmt.Errorf("fetching signatures: %w", err)
+	}
+	mf, err := m.Image()
+	if err != nil {
+		return nil, fmt.Errorf("parsing signature manifest: %w", err)
+	}
+
+	sigs := []Signature{}
+	for _, l := range mf.Layers {
+		sig, ok := l.Annotations[AnnotationSignature]
+		if !ok || l.MediaType != MediaTypeSimpleSigning {
+			continue
+		}
+		payload, err := v.blob(ctx, sigRef, l.Digest, ac)
+		if err != nil {
+			return nil, fmt.Errorf("fetching signature payload: %w", err)
+		}
+		sigs = append(sigs, Signature{Payload: payload, Base64Signature: sig})
+	}
+	return sigs, nil
+}
+
+func (v *Verifier) blob(ctx context.Context, repo reference.Named, dgst digest.Digest, ac types.AuthConfig) ([]byte, error) {
+	rc, err := v.Client.Blob(ctx, repo, dgst, ac)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(rc, maxPayloadSize))
+	if err != nil {
+		return nil, err
+	}
+	if digest.FromBytes(content) != dgst {
+		return nil, fmt.Errorf("blob %s: digest mismatch", dgst)
+	}
+	return content, nil
+}
+
+// VerifySignatures returns the signatures created by one of the keys whose
+// payload refers to the manifest digest.
+func VerifySignatures(sigs []Signature, keys []crypto.PublicKey, dgst digest.Digest) []Signature {
+	valid := []Signature{}
+	for _, sig := range sigs {
+		var p Payload
+		if err := json.Unmarshal(sig.Payload, &p); err != nil {
+			continue
+		}
+		if p.Critical.Image.DockerManifestDigest != dgst.String() {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(sig.Base64Signature)
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			if verifySignature(key, sig.Payload, raw) {
+				valid = append(valid, sig)
+				break
+			}
+		}
+	}
+	return valid
+}
+
+func verifySignature(key crypto.PublicKey, payload, sig []byte) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(payload)
+		return ecdsa.VerifyASN1(k, h[:], sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256(payload)
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil {
+			return true
+		}
+		return rsa.VerifyPSS(k, crypto.SHA256, h[:], sig, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	}
+	return false
+}
+
+// ParsePublicKeys parses all PEM encoded public keys in data as written by
+// `cosign generate-key-pair`.
+func ParsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	keys := []crypto.PublicKey{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if !strings.HasSuffix(block.Type, "PUBLIC KEY") {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	return keys, nil
+}
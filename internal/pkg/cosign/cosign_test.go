@@ -0,0 +1,90 @@
+package cosign_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/cosign"
+	"github.com/bastjan/saveomat/internal/pkg/cosign/cosigntest"
+	"github.com/bastjan/saveomat/internal/pkg/registry"
+	"github.com/bastjan/saveomat/internal/pkg/registry/registrytest"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	key, pubPEM := cosigntest.GenerateKey()
+	otherKey, _ := cosigntest.GenerateKey()
+	keys, err := cosign.ParsePublicKeys(pubPEM)
+	assert.NoError(t, err)
+
+	signed := reg.PushImage("app/signed", "1.0", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("signed"))
+	cosigntest.PushSignatures(reg, "app/signed", signed.Digest, otherKey, key)
+	foreign := reg.PushImage("app/foreign", "1.0", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("foreign"))
+	cosigntest.PushSignatures(reg, "app/foreign", foreign.Digest, otherKey)
+	reg.PushImage("app/unsigned", "1.0", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("unsigned"))
+	// A valid signature for another image must not be accepted.
+	replayed := reg.PushImage("app/replayed", "1.0", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("replayed"))
+	payload := cosigntest.Payload(reg.Host()+"/app/signed", signed.Digest)
+	cosigntest.PushSignatureManifest(reg, "app/replayed", cosign.SignatureTag(replayed.Digest),
+		cosign.Signature{Payload: payload, Base64Signature: cosigntest.Sign(key, payload)})
+
+	v := &cosign.Verifier{Client: registry.NewClient(), Keys: keys}
+
+	res, err := v.Verify(context.Background(), parse(t, reg.Host()+"/app/signed:1.0"), types.AuthConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, signed.Digest, res.Digest)
+	if assert.Len(t, res.Signatures, 1) {
+		assert.Contains(t, string(res.Signatures[0].Payload), signed.Digest.String())
+	}
+
+	for _, img := range []string{"app/foreign:1.0", "app/unsigned:1.0", "app/replayed:1.0"} {
+		_, err = v.Verify(context.Background(), parse(t, reg.Host()+"/"+img), types.AuthConfig{})
+		assert.True(t, errors.Is(err, cosign.ErrNoValidSignature), "%s: %v", img, err)
+	}
+
+	_, err = v.Verify(context.Background(), parse(t, reg.Host()+"/app/missing:1.0"), types.AuthConfig{})
+	assert.EqualError(t, err, "not found: manifest unknown")
+}
+
+func TestVerifySignaturesKeyTypes(t *testing.T) {
+	ecKey, _ := cosigntest.GenerateKey()
+	edPub, edKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	dgst := digest.Digest("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+	for name, key := range map[string]crypto.Signer{"ecdsa": ecKey, "ed25519": edKey} {
+		payload := cosigntest.Payload("example.com/app", dgst)
+		sigs := []cosign.Signature{{Payload: payload, Base64Signature: cosigntest.Sign(key, payload)}}
+		valid := cosign.VerifySignatures(sigs, []crypto.PublicKey{ecKey.Public(), edPub}, dgst)
+		assert.Len(t, valid, 1, name)
+	}
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	_, a := cosigntest.GenerateKey()
+	_, b := cosigntest.GenerateKey()
+
+	keys, err := cosign.ParsePublicKeys(append(a, b...))
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	_, err = cosign.ParsePublicKeys([]byte("nothing"))
+	assert.EqualError(t, err, "no public keys found")
+}
+
+func parse(t *testing.T, s string) reference.Named {
+	t.Helper()
+	ref, err := reference.ParseNormalizedNamed(s)
+	assert.NoError(t, err)
+	return ref
+}
@@ -0,0 +1,88 @@
+// Package cosigntest signs images in a registrytest.Registry like cosign does.
+package cosigntest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/bastjan/saveomat/internal/pkg/cosign"
+	"github.com/bastjan/saveomat/internal/pkg/registry/registrytest"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// GenerateKey returns a new ECDSA P-256 key like `cosign generate-key-pair`
+// and its PEM encoded public key.
+func GenerateKey() (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		panic(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// Payload returns a simple signing payload for the image.
+func Payload(image string, dgst digest.Digest) []byte {
+	var p cosign.Payload
+	p.Critical.Identity.DockerReference = image
+	p.Critical.Image.DockerManifestDigest = dgst.String()
+	p.Critical.Type = "cosign container image signature"
+	b, _ := json.Marshal(p)
+	return b
+}
+
+// Sign signs the payload with key.
+func Sign(key crypto.Signer, payload []byte) string {
+	var sig []byte
+	var err error
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		sig, err = key.Sign(rand.Reader, payload, crypto.Hash(0))
+	} else {
+		h := sha256.Sum256(payload)
+		sig, err = key.Sign(rand.Reader, h[:], crypto.SHA256)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// PushSignatures stores signatures of the image with the given digest in repo.
+// Each key signs a payload referring to the digest.
+func PushSignatures(r *registrytest.Registry, repo string, dgst digest.Digest, keys ...crypto.Signer) ocispec.Descriptor {
+	sigs := make([]cosign.Signature, 0, len(keys))
+	for _, key := range keys {
+		payload := Payload(fmt.Sprintf("%s/%s", r.Host(), repo), dgst)
+		sigs = append(sigs, cosign.Signature{Payload: payload, Base64Signature: Sign(key, payload)})
+	}
+	return PushSignatureManifest(r, repo, cosign.SignatureTag(dgst), sigs...)
+}
+
+// PushSignatureManifest stores the signatures under the tag.
+func PushSignatureManifest(r *registrytest.Registry, repo, tag string, sigs ...cosign.Signature) ocispec.Descriptor {
+	mf := ocispec.Manifest{
+		Config: r.PushBlob("application/vnd.oci.image.config.v1+json", []byte("{}")),
+		Layers: []ocispec.Descriptor{},
+	}
+	mf.SchemaVersion = 2
+	for _, sig := range sigs {
+		l := r.PushBlob(cosign.MediaTypeSimpleSigning, sig.Payload)
+		l.Annotations = map[string]string{cosign.AnnotationSignature: sig.Base64Signature}
+		mf.Layers = append(mf.Layers, l)
+	}
+	content, _ := json.Marshal(mf)
+	return r.PushManifest(repo, tag, ocispec.MediaTypeImageManifest, content)
+}
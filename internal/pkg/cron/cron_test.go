@@ -0,0 +1,62 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/cron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2024, 1, 3, 10, 17, 30, 0, time.UTC)
+	for expr, expected := range map[string]string{
+		"* * * * *":          "2024-01-03T10:18:00Z",
+		"0 2 * * *":          "2024-01-04T02:00:00Z",
+		"@daily":             "2024-01-04T00:00:00Z",
+		"@hourly":            "2024-01-03T11:00:00Z",
+		"*/15 * * * *":       "2024-01-03T10:30:00Z",
+		"5/20 * * * *":       "2024-01-03T10:25:00Z",
+		"0 9-17/4 * * *":     "2024-01-03T13:00:00Z",
+		"30 2 * * mon-fri":   "2024-01-04T02:30:00Z",
+		"0 0 * * 0":          "2024-01-07T00:00:00Z",
+		"0 0 * * 7":          "2024-01-07T00:00:00Z",
+		"0 0 * * sun":        "2024-01-07T00:00:00Z",
+		"0 0 1 * *":          "2024-02-01T00:00:00Z",
+		"0 0 1,15 * mon":     "2024-01-08T00:00:00Z",
+		"0 0 */2 * mon":      "2024-01-15T00:00:00Z",
+		"0 0 29 feb *":       "2024-02-29T00:00:00Z",
+		"@yearly":            "2025-01-01T00:00:00Z",
+		"17 10 3 1 *":        "2025-01-03T10:17:00Z",
+		"0 12 31 4,6,9,11 *": "0001-01-01T00:00:00Z",
+	} {
+		s, err := cron.Parse(expr)
+		if !assert.NoError(t, err, expr) {
+			continue
+		}
+		assert.Equal(t, expected, s.Next(now).Format(time.RFC3339), expr)
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	s, err := cron.Parse("0 2 * * *")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 4, 2, 0, 0, 0, loc), s.Next(time.Date(2024, 1, 4, 0, 30, 0, 0, time.UTC).In(loc)))
+}
+
+func TestParseErrors(t *testing.T) {
+	for expr, msg := range map[string]string{
+		"* * * *":      `invalid cron expression "* * * *": expected 5 fields, got 4`,
+		"60 * * * *":   `invalid cron expression "60 * * * *": invalid minute "60", expected 0-59`,
+		"* * 0 * *":    `invalid cron expression "* * 0 * *": invalid day of month "0", expected 1-31`,
+		"* * * foo *":  `invalid cron expression "* * * foo *": invalid month "foo", expected 1-12`,
+		"*/0 * * * *":  `invalid cron expression "*/0 * * * *": invalid step in minute field "*/0"`,
+		"* 10-2 * * *": `invalid cron expression "* 10-2 * * *": invalid range in hour field "10-2"`,
+		"@fortnightly": `invalid cron expression "@fortnightly": expected 5 fields, got 1`,
+	} {
+		_, err := cron.Parse(expr)
+		assert.EqualError(t, err, msg)
+	}
+}
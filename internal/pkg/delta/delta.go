@@ -0,0 +1,214 @@
+// Package delta creates and reconstructs archives omitting the layers the
+// receiver already has.
+package delta
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// ManifestFile lists the layers omitted from a delta archive.
+const ManifestFile = "delta.json"
+
+// maxMetadataSize limits the size of the JSON files read from archives.
+const maxMetadataSize = 4 << 20
+
+// Manifest is the content of ManifestFile.
+type Manifest struct {
+	// Omitted are the layers that must be restored from a layer store.
+	Omitted []Layer `json:"omitted"`
+}
+
+// Layer is a layer file of an archive.
+type Layer struct {
+	// Name is the path of the file in the archive.
+	Name   string        `json:"name"`
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+}
+
+// Contents describes an archive created by `docker save` or in the OCI image
+// layout.
+type Contents struct {
+	// Layers are the layer files contained in the archive.
+	Layers []Layer
+	// Delta is set for delta archives.
+	Delta *Manifest
+	// Checksums reports whether the archive contains archive.SumsFile.
+	Checksums bool
+}
+
+// ParseDigests parses a list of SHA-256 digests, one per line. Digests may
+// omit the "sha256:" prefix and may be followed by a file name as printed by
+// sha256sum, so the SHA256SUMS of a previous archive can be used as well.
+// Empty lines and lines starting with # are ignored.
+func ParseDigests(r io.Reader) ([]digest.Digest, error) {
+	var digests []digest.Digest
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		d := digest.Digest(fields[0])
+		if !strings.Contains(fields[0], ":") {
+			d = digest.NewDigestFromEncoded(digest.SHA256, fields[0])
+		}
+		if d.Algorithm() != digest.SHA256 {
+			return nil, fmt.Errorf("line %d: unsupported digest %q", n, fields[0])
+		}
+		if err := d.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: invalid digest %q: %w", n, fields[0], err)
+		}
+		digests = append(digests, d)
+	}
+	return digests, sc.Err()
+}
+
+// Inspect reads the archive and lists its layers.
+func Inspect(r io.Reader) (Contents, error) {
+	var contents Contents
+	sizes := map[string]int64{}
+	metadata := map[string][]byte{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return contents, err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		sizes[name] = hdr.Size
+		switch {
+		case name == archive.SumsFile:
+			contents.Checksums = true
+		case name == ManifestFile:
+			contents.Delta = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(contents.Delta); err != nil {
+				return contents, fmt.Errorf("decoding %s: %w", ManifestFile, err)
+			}
+		case hdr.Size <= maxMetadataSize:
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return contents, err
+			}
+			if t := bytes.TrimSpace(b); len(t) > 0 && (t[0] == '{' || t[0] == '[') {
+				metadata[name] = b
+			}
+		}
+	}
+
+	var err error
+	if _, ok := metadata["manifest.json"]; ok {
+		contents.Layers, err = dockerLayers(metadata, sizes)
+	} else if _, ok := metadata["index.json"]; ok {
+		contents.Layers, err = ociLayers(metadata, sizes)
+	} else {
+		err = fmt.Errorf("archive contains neither manifest.json nor index.json")
+	}
+	return contents, err
+}
+
+// dockerLayers lists the layers of an archive created by `docker save`. Layer
+// files are identified by the diff IDs of their image configs.
+func dockerLayers(metadata map[string][]byte, sizes map[string]int64) ([]Layer, error) {
+	var manifest []struct {
+		Config string
+		Layers []string
+	}
+	if err := json.Unmarshal(metadata["manifest.json"], &manifest); err != nil {
+		return nil, fmt.Errorf("decoding manifest.json: %w", err)
+	}
+
+	layers := []Layer{}
+	seen := map[string]bool{}
+	for _, m := range manifest {
+		var config struct {
+			RootFS struct {
+				DiffIDs []digest.Digest `json:"diff_ids"`
+			} `json:"rootfs"`
+		}
+		if err := json.Unmarshal(metadata[path.Clean(m.Config)], &config); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", m.Config, err)
+		}
+		if len(config.RootFS.DiffIDs) != len(m.Layers) {
+			return nil, fmt.Errorf("%s lists %d layers, manifest.json %d", m.Config, len(config.RootFS.DiffIDs), len(m.Layers))
+		}
+		for i, l := range m.Layers {
+			name := path.Clean(l)
+			size, ok := sizes[name]
+			if !ok || seen[name] {
+				continue
+			}
+			seen[name] = true
+			layers = append(layers, Layer{Name: name, Digest: config.RootFS.DiffIDs[i], Size: size})
+		}
+	}
+	return layers, nil
+}
+
+// ociLayers lists the layers of all images of an OCI image layout. Layer
+// files are identified by their blob digest.
+func ociLayers(metadata map[string][]byte, sizes map[string]int64) ([]Layer, error) {
+	type descriptor struct {
+		Digest digest.Digest `json:"digest"`
+	}
+	var walk func(name string) error
+
+	layers := []Layer{}
+	seen := map[string]bool{}
+	walk = func(name string) error {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		b, ok := metadata[name]
+		if !ok {
+			// Manifests of other platforms may be missing.
+			return nil
+		}
+		var m struct {
+			Manifests []descriptor `json:"manifests"`
+			Layers    []descriptor `json:"layers"`
+		}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return fmt.Errorf("decoding %s: %w", name, err)
+		}
+		for _, d := range m.Manifests {
+			if err := walk(blobPath(d.Digest)); err != nil {
+				return err
+			}
+		}
+		for _, d := range m.Layers {
+			name := blobPath(d.Digest)
+			size, ok := sizes[name]
+			if !ok || seen[name] {
+				continue
+			}
+			seen[name] = true
+			layers = append(layers, Layer{Name: name, Digest: d.Digest, Size: size})
+		}
+		return nil
+	}
+	return layers, walk("index.json")
+}
+
+func blobPath(d digest.Digest) string {
+	return path.Join("blobs", d.Algorithm().String(), d.Encoded())
+}
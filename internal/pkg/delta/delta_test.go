@@ -0,0 +1,166 @@
+package delta
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDigests(t *testing.T) {
+	digests, err := ParseDigests(strings.NewReader(`# layers at site A
+sha256:` + hex("base") + `
+
+` + hex("app") + `  abc/layer.tar
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []digest.Digest{digest.FromString("base"), digest.FromString("app")}, digests)
+
+	_, err = ParseDigests(strings.NewReader("sha512:abc\n"))
+	assert.EqualError(t, err, `line 1: unsupported digest "sha512:abc"`)
+	_, err = ParseDigests(strings.NewReader("\nabc\n"))
+	assert.EqualError(t, err, `line 2: invalid digest "abc": invalid checksum digest length`)
+}
+
+func TestInspectDocker(t *testing.T) {
+	contents, err := Inspect(bytes.NewReader(dockerArchive(t, nil)))
+	assert.NoError(t, err)
+	assert.Nil(t, contents.Delta)
+	assert.False(t, contents.Checksums)
+	assert.Equal(t, []Layer{
+		{Name: "base/layer.tar", Digest: digest.FromString("base"), Size: 4},
+		{Name: "app/layer.tar", Digest: digest.FromString("app"), Size: 3},
+	}, contents.Layers)
+}
+
+func TestInspectOCI(t *testing.T) {
+	layer := digest.FromString("layer")
+	manifest := fmt.Sprintf(`{"config":{"digest":%q},"layers":[{"digest":%q},{"digest":"sha256:%s"}]}`, digest.FromString("{}"), layer, hex("foreign"))
+	index := fmt.Sprintf(`{"manifests":[{"digest":%q},{"digest":"sha256:%s"}]}`, digest.FromString(manifest), hex("other platform"))
+	files := map[string]string{
+		"oci-layout":                    `{"imageLayoutVersion":"1.0.0"}`,
+		"blobs/sha256/" + hex("{}"):     "{}",
+		"blobs/sha256/" + hex("layer"):  "layer",
+		"blobs/sha256/" + hex(manifest): manifest,
+		"blobs/sha256/" + hex(index):    index,
+		"index.json":                    fmt.Sprintf(`{"manifests":[{"digest":%q}]}`, digest.FromString(index)),
+		archive.SumsFile:                "",
+	}
+
+	contents, err := Inspect(bytes.NewReader(tarOf(t, files)))
+	assert.NoError(t, err)
+	assert.True(t, contents.Checksums)
+	assert.Equal(t, []Layer{{Name: "blobs/sha256/" + hex("layer"), Digest: layer, Size: 5}}, contents.Layers)
+
+	_, err = Inspect(bytes.NewReader(tarOf(t, map[string]string{"foo": "bar"})))
+	assert.EqualError(t, err, "archive contains neither manifest.json nor index.json")
+}
+
+func TestReconstruct(t *testing.T) {
+	store := Store{Dir: t.TempDir()}
+	full := dockerArchive(t, nil)
+
+	// The layers of full archives are stored.
+	out := new(bytes.Buffer)
+	assert.NoError(t, Reconstruct(out, bytes.NewReader(full), inspect(t, full), store))
+	assert.Equal(t, readTar(t, full), readTar(t, out.Bytes()))
+	digests, err := store.Digests()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []digest.Digest{digest.FromString("base"), digest.FromString("app")}, digests)
+
+	delta := dockerArchive(t, &Manifest{Omitted: []Layer{{Name: "base/layer.tar", Digest: digest.FromString("base"), Size: 4}}})
+	out.Reset()
+	assert.NoError(t, Reconstruct(out, bytes.NewReader(delta), inspect(t, delta), store))
+	assert.Equal(t, readTar(t, full), readTar(t, out.Bytes()))
+
+	err = Reconstruct(ioutil.Discard, bytes.NewReader(delta), inspect(t, delta), Store{Dir: t.TempDir()})
+	assert.EqualError(t, err, "layer "+digest.FromString("base").String()+" (base/layer.tar) is not in the layer store")
+}
+
+func TestReconstructCorruptStore(t *testing.T) {
+	store := Store{Dir: t.TempDir()}
+	assert.NoError(t, store.Put(digest.FromString("base"), strings.NewReader("base")))
+	assert.EqualError(t, store.Put(digest.FromString("app"), strings.NewReader("evil")), "layer "+digest.FromString("app").String()+": digest mismatch")
+	assert.False(t, store.Has(digest.FromString("app")))
+	assert.NoError(t, ioutil.WriteFile(store.path(digest.FromString("base")), []byte("evil"), 0644))
+
+	delta := dockerArchive(t, &Manifest{Omitted: []Layer{{Name: "base/layer.tar", Digest: digest.FromString("base"), Size: 4}}})
+	err := Reconstruct(ioutil.Discard, bytes.NewReader(delta), inspect(t, delta), store)
+	assert.EqualError(t, err, "layer "+digest.FromString("base").String()+" in the layer store is corrupt")
+}
+
+// dockerArchive creates an archive in the format of `docker save` with two
+// layers. Omitted layers are left out and listed in ManifestFile.
+func dockerArchive(t *testing.T, m *Manifest) []byte {
+	t.Helper()
+	files := map[string]string{
+		"base/layer.tar": "base",
+		"app/layer.tar":  "app",
+		"config.json":    fmt.Sprintf(`{"rootfs":{"type":"layers","diff_ids":[%q,%q]}}`, digest.FromString("base"), digest.FromString("app")),
+		"manifest.json":  `[{"Config":"config.json","RepoTags":["app:v1"],"Layers":["base/layer.tar","app/layer.tar"]}]`,
+	}
+	if m != nil {
+		for _, l := range m.Omitted {
+			delete(files, l.Name)
+		}
+		b, err := json.Marshal(m)
+		assert.NoError(t, err)
+		files[ManifestFile] = string(b)
+		files[archive.SumsFile] = ""
+	}
+	return tarOf(t, files)
+}
+
+func inspect(t *testing.T, b []byte) Contents {
+	t.Helper()
+	contents, err := Inspect(bytes.NewReader(b))
+	assert.NoError(t, err)
+	return contents
+}
+
+func tarOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	for _, name := range names {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(files[name])), Mode: 0644}))
+		_, err := tw.Write([]byte(files[name]))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	return b.Bytes()
+}
+
+func readTar(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		files[hdr.Name] = string(content)
+	}
+	return files
+}
+
+func hex(s string) string {
+	return digest.FromString(s).Encoded()
+}
@@ -0,0 +1,94 @@
+package delta
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+)
+
+// Reconstruct copies the archive r, as described by Inspect, to w and restores
+// the omitted layers from the store. Layers contained in r are added to the
+// store, so full archives can be used to fill it. The checksum files and
+// ManifestFile are dropped, as they do not describe the full archive.
+func Reconstruct(w io.Writer, r io.Reader, contents Contents, store Store) error {
+	layers := map[string]Layer{}
+	for _, l := range contents.Layers {
+		layers[l.Name] = l
+	}
+	if contents.Delta != nil {
+		for _, l := range contents.Delta.Omitted {
+			if !store.Has(l.Digest) {
+				return fmt.Errorf("layer %s (%s) is not in the layer store", l.Digest, l.Name)
+			}
+		}
+	}
+
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if name == ManifestFile || name == archive.SumsFile || name == archive.SignatureFile {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		l, ok := layers[name]
+		if ok && !store.Has(l.Digest) {
+			err = store.Put(l.Digest, io.TeeReader(tr, tw))
+		} else {
+			_, err = io.Copy(tw, tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if contents.Delta != nil {
+		for _, l := range contents.Delta.Omitted {
+			if err := restore(tw, l, store); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+func restore(tw *tar.Writer, l Layer, store Store) error {
+	f, err := store.Open(l.Digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     l.Name,
+		Size:     l.Size,
+		Mode:     0644,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+	v := l.Digest.Verifier()
+	if _, err := io.Copy(tw, io.TeeReader(io.LimitReader(f, l.Size), v)); err != nil {
+		return err
+	}
+	if !v.Verified() {
+		return fmt.Errorf("layer %s in the layer store is corrupt", l.Digest)
+	}
+	return nil
+}
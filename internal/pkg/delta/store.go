@@ -0,0 +1,89 @@
+package delta
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// Store keeps layers in a directory, named like the blobs of an OCI image
+// layout, e.g. sha256/<hex>.
+type Store struct {
+	Dir string
+}
+
+func (s Store) path(d digest.Digest) string {
+	return filepath.Join(s.Dir, d.Algorithm().String(), d.Encoded())
+}
+
+// Has reports whether the store contains the layer.
+func (s Store) Has(d digest.Digest) bool {
+	_, err := os.Stat(s.path(d))
+	return err == nil
+}
+
+// Open opens the layer.
+func (s Store) Open(d digest.Digest) (*os.File, error) {
+	return os.Open(s.path(d))
+}
+
+// Put stores the content of r if it matches the digest.
+func (s Store) Put(d digest.Digest, r io.Reader) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	dir := filepath.Join(s.Dir, d.Algorithm().String())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	v := d.Verifier()
+	if _, err := io.Copy(f, io.TeeReader(r, v)); err != nil {
+		return err
+	}
+	if !v.Verified() {
+		return fmt.Errorf("layer %s: digest mismatch", d)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(d))
+}
+
+// Digests lists all layers of the store.
+func (s Store) Digests() ([]digest.Digest, error) {
+	digests := []digest.Digest{}
+	algs, err := ioutil.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return digests, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, alg := range algs {
+		if !alg.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(s.Dir, alg.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			d := digest.NewDigestFromEncoded(digest.Algorithm(alg.Name()), f.Name())
+			if d.Validate() == nil {
+				digests = append(digests, d)
+			}
+		}
+	}
+	return digests, nil
+}
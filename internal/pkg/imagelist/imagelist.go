@@ -0,0 +1,290 @@
+// Package imagelist parses images.txt files.
+//
+// Each line holds at most one entry. Everything after a "#" at the beginning
+// of a line or after whitespace is a comment.
+//
+//	# pull busybox and save it as is
+//	busybox:1.36
+//	# save nginx under a different name
+//	nginx:1.25 => registry.airgap.local/mirror/nginx:1.25
+//	# pull a specific platform
+//	alpine:3.18 platform=linux/arm64
+//	# skip the image if it does not exist
+//	registry.example.com/nightly:latest optional
+//	# insert the entries of another uploaded list
+//	include base-images.txt
+//
+// Options can be combined and follow the image reference in any order.
+package imagelist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+const (
+	// MaxIncludeDepth limits nested includes.
+	MaxIncludeDepth = 10
+	// MaxEntries limits the entries of a list including its includes.
+	MaxEntries = 10000
+)
+
+// errTooManyEntries stops parsing once MaxEntries is exceeded.
+var errTooManyEntries = errors.New("too many entries")
+
+// Entry is an image to bundle.
+type Entry struct {
+	// File and Line locate the entry in its source. Line is 0 for entries
+	// not read from a list.
+	File string
+	Line int
+
+	// Source is the reference to pull.
+	Source string
+	// Target is the name the image is saved as. Empty if the image keeps its
+	// name.
+	Target string
+	// Platform is the platform to pull, e.g. "linux/arm64".
+	Platform string
+	// Optional entries are skipped if the image does not exist.
+	Optional bool
+}
+
+// Resolver opens the list named in an include directive.
+type Resolver func(name string) (io.ReadCloser, error)
+
+// Error is a problem with a single line of a list.
+type Error struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Entry   string `json:"entry,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+}
+
+// Errors collects all problems found in a list.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Parse parses a list. Includes are opened using resolve, which may be nil
+// if includes are not supported. A file included more than once is only read
+// the first time, as its entries are already part of the list. Syntax errors
+// and lists with more than MaxEntries entries are returned as Errors.
+func Parse(name string, r io.Reader, resolve Resolver) ([]Entry, error) {
+	p := parser{resolve: resolve, entries: []Entry{}, included: map[string]bool{name: true}}
+	if err := p.parse(name, r, []string{name}); err != nil && !errors.Is(err, errTooManyEntries) {
+		return nil, err
+	}
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+	return p.entries, nil
+}
+
+type parser struct {
+	resolve  Resolver
+	entries  []Entry
+	errs     Errors
+	included map[string]bool
+}
+
+func (p *parser) parse(name string, r io.Reader, stack []string) error {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(strings.ReplaceAll(stripComment(sc.Text()), "=>", " => "))
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "include" && len(fields) == 2 {
+			if err := p.include(name, n, fields[1], stack); err != nil {
+				return err
+			}
+			continue
+		}
+
+		e, err := parseEntry(fields)
+		if err != nil {
+			p.errs = append(p.errs, &Error{File: name, Line: n, Entry: strings.Join(fields, " "), Message: err.Error()})
+			continue
+		}
+		if len(p.entries) == MaxEntries {
+			p.errs = append(p.errs, &Error{File: name, Line: n, Entry: strings.Join(fields, " "), Message: fmt.Sprintf("lists are limited to %d entries", MaxEntries)})
+			return errTooManyEntries
+		}
+		e.File, e.Line = name, n
+		p.entries = append(p.entries, e)
+	}
+	return sc.Err()
+}
+
+func (p *parser) include(name string, line int, included string, stack []string) error {
+	fail := func(format string, args ...interface{}) error {
+		p.errs = append(p.errs, &Error{File: name, Line: line, Entry: "include " + included, Message: fmt.Sprintf(format, args...)})
+		return nil
+	}
+
+	if p.resolve == nil {
+		return fail("includes are not supported")
+	}
+	for _, s := range stack {
+		if s == included {
+			return fail("include cycle: %s -> %s", strings.Join(stack, " -> "), included)
+		}
+	}
+	if len(stack) > MaxIncludeDepth {
+		return fail("includes nested deeper than %d levels", MaxIncludeDepth)
+	}
+	if p.included[included] {
+		return nil
+	}
+	p.included[included] = true
+
+	rc, err := p.resolve(included)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer rc.Close()
+	return p.parse(included, rc, append(stack[:len(stack):len(stack)], included))
+}
+
+func parseEntry(fields []string) (Entry, error) {
+	e := Entry{Source: fields[0]}
+	if e.Source == "=>" {
+		return e, fmt.Errorf("missing image before =>")
+	}
+	if _, err := normalizeReference(e.Source); err != nil {
+		return e, err
+	}
+
+	for i := 1; i < len(fields); i++ {
+		f := fields[i]
+		switch {
+		case f == "=>":
+			if i+1 == len(fields) {
+				return e, fmt.Errorf("missing target after =>")
+			}
+			if e.Target != "" {
+				return e, fmt.Errorf("multiple targets")
+			}
+			i++
+			e.Target = fields[i]
+			if _, err := normalizeTarget(e.Target); err != nil {
+				return e, err
+			}
+		case f == "optional":
+			e.Optional = true
+		case strings.HasPrefix(f, "platform="):
+			e.Platform = strings.TrimPrefix(f, "platform=")
+			if err := ValidatePlatform(e.Platform); err != nil {
+				return e, err
+			}
+		default:
+			return e, fmt.Errorf("unknown option %q", f)
+		}
+	}
+	return e, nil
+}
+
+// Normalize expands all references to their fully qualified form, for example
+// "busybox" to "docker.io/library/busybox:latest", and collapses duplicates.
+// An entry is only optional if all its duplicates are.
+// Invalid references are returned as Errors.
+func Normalize(entries []Entry) ([]Entry, error) {
+	var errs Errors
+	seen := map[Entry]int{}
+	normalized := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		orig := e
+		fail := func(err error) {
+			errs = append(errs, &Error{File: orig.File, Line: orig.Line, Entry: orig.Source, Message: err.Error()})
+		}
+
+		src, err := normalizeReference(e.Source)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		e.Source = src
+		if e.Target != "" {
+			target, err := normalizeTarget(e.Target)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			e.Target = target
+		}
+
+		key := Entry{Source: e.Source, Target: e.Target, Platform: e.Platform}
+		if i, ok := seen[key]; ok {
+			normalized[i].Optional = normalized[i].Optional && e.Optional
+			continue
+		}
+		seen[key] = len(normalized)
+		normalized = append(normalized, e)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return normalized, nil
+}
+
+func normalizeReference(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid reference %q: %w", ref, err)
+	}
+	return reference.TagNameOnly(named).String(), nil
+}
+
+func normalizeTarget(target string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return "", fmt.Errorf("invalid target %q: targets can not contain a digest", target)
+	}
+	return reference.TagNameOnly(named).String(), nil
+}
+
+// ValidatePlatform checks the platform is of the form os/arch[/variant].
+func ValidatePlatform(platform string) error {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid platform %q: expected os/arch[/variant]", platform)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return fmt.Errorf("invalid platform %q: expected os/arch[/variant]", platform)
+		}
+	}
+	return nil
+}
+
+func stripComment(line string) string {
+	for i, c := range line {
+		if c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
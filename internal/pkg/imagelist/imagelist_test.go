@@ -0,0 +1,151 @@
+package imagelist_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	list := `# comment
+busybox
+
+nginx:1.25 => registry.airgap.local/mirror/nginx:1.25 # trailing comment
+	alpine:3.18	platform=linux/arm64/v8
+registry.example.com/nightly:latest optional platform=linux/amd64 =>registry.airgap.local/nightly
+golang@sha256:0000000000000000000000000000000000000000000000000000000000000000
+include base.txt
+`
+	resolve := files(map[string]string{
+		"base.txt": "debian:bookworm\ninclude more.txt\n",
+		"more.txt": "ubuntu:22.04",
+	})
+
+	entries, err := imagelist.Parse("images.txt", strings.NewReader(list), resolve)
+	assert.NoError(t, err)
+	assert.Equal(t, []imagelist.Entry{
+		{File: "images.txt", Line: 2, Source: "busybox"},
+		{File: "images.txt", Line: 4, Source: "nginx:1.25", Target: "registry.airgap.local/mirror/nginx:1.25"},
+		{File: "images.txt", Line: 5, Source: "alpine:3.18", Platform: "linux/arm64/v8"},
+		{File: "images.txt", Line: 6, Source: "registry.example.com/nightly:latest", Target: "registry.airgap.local/nightly", Platform: "linux/amd64", Optional: true},
+		{File: "images.txt", Line: 7, Source: "golang@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+		{File: "base.txt", Line: 1, Source: "debian:bookworm"},
+		{File: "more.txt", Line: 1, Source: "ubuntu:22.04"},
+	}, entries)
+}
+
+func TestParseErrors(t *testing.T) {
+	list := `busybox
+busybox =>
+=> busybox
+busybox => a => b
+busybox platform=linux
+busybox latest
+include missing.txt
+include self.txt
+busybox#no-comment
+Busybox
+busybox => registry.airgap.local/busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000
+`
+	resolve := files(map[string]string{
+		"self.txt": "include images.txt",
+	})
+
+	_, err := imagelist.Parse("images.txt", strings.NewReader(list), resolve)
+
+	var errs imagelist.Errors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, imagelist.Errors{
+		{File: "images.txt", Line: 2, Entry: "busybox =>", Message: "missing target after =>"},
+		{File: "images.txt", Line: 3, Entry: "=> busybox", Message: "missing image before =>"},
+		{File: "images.txt", Line: 4, Entry: "busybox => a => b", Message: "multiple targets"},
+		{File: "images.txt", Line: 5, Entry: "busybox platform=linux", Message: `invalid platform "linux": expected os/arch[/variant]`},
+		{File: "images.txt", Line: 6, Entry: "busybox latest", Message: `unknown option "latest"`},
+		{File: "images.txt", Line: 7, Entry: "include missing.txt", Message: "missing.txt not found"},
+		{File: "self.txt", Line: 1, Entry: "include images.txt", Message: "include cycle: images.txt -> self.txt -> images.txt"},
+		{File: "images.txt", Line: 9, Entry: "busybox#no-comment", Message: `invalid reference "busybox#no-comment": invalid reference format`},
+		{File: "images.txt", Line: 10, Entry: "Busybox", Message: `invalid reference "Busybox": invalid reference format: repository name must be lowercase`},
+		{File: "images.txt", Line: 11, Entry: "busybox => registry.airgap.local/busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000", Message: `invalid target "registry.airgap.local/busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000": targets can not contain a digest`},
+	}, errs)
+	assert.Contains(t, err.Error(), "images.txt:2: missing target after =>")
+}
+
+func TestNormalize(t *testing.T) {
+	entries, err := imagelist.Normalize([]imagelist.Entry{
+		{Line: 1, Source: "busybox", Optional: true},
+		{Line: 2, Source: "docker.io/library/busybox:latest"},
+		{Line: 3, Source: "index.docker.io/library/busybox", Platform: "linux/arm64"},
+		{Line: 4, Source: "quay.io/foo/bar:v1", Target: "registry.airgap.local/bar"},
+		{Line: 5, Source: "quay.io/foo/bar:v1", Target: "registry.airgap.local/bar:latest", Optional: true},
+		{Line: 6, Source: "golang@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []imagelist.Entry{
+		{Line: 1, Source: "docker.io/library/busybox:latest"},
+		{Line: 3, Source: "docker.io/library/busybox:latest", Platform: "linux/arm64"},
+		{Line: 4, Source: "quay.io/foo/bar:v1", Target: "registry.airgap.local/bar:latest"},
+		{Line: 6, Source: "docker.io/library/golang@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+	}, entries)
+}
+
+func TestNormalizeErrors(t *testing.T) {
+	_, err := imagelist.Normalize([]imagelist.Entry{
+		{File: "docker-compose.yml", Source: "Invalid"},
+		{File: "docker-compose.yml", Source: "busybox"},
+		{File: "images.txt", Line: 2, Source: "busybox", Target: "in valid"},
+	})
+	assert.Equal(t, imagelist.Errors{
+		{File: "docker-compose.yml", Entry: "Invalid", Message: `invalid reference "Invalid": invalid reference format: repository name must be lowercase`},
+		{File: "images.txt", Line: 2, Entry: "busybox", Message: `invalid target "in valid": invalid reference format`},
+	}, err)
+}
+
+func TestParseRepeatedIncludes(t *testing.T) {
+	opened := map[string]int{}
+	resolve := files(map[string]string{
+		"a.txt": strings.Repeat("include b.txt\n", 2000),
+		"b.txt": "busybox\n" + strings.Repeat("include c.txt\n", 2000),
+		"c.txt": "alpine\n",
+	})
+	counting := func(name string) (io.ReadCloser, error) {
+		opened[name]++
+		return resolve(name)
+	}
+
+	entries, err := imagelist.Parse("images.txt", strings.NewReader("include a.txt\ninclude c.txt\nnginx\n"), counting)
+	assert.NoError(t, err)
+	assert.Equal(t, []imagelist.Entry{
+		{File: "b.txt", Line: 1, Source: "busybox"},
+		{File: "c.txt", Line: 1, Source: "alpine"},
+		{File: "images.txt", Line: 3, Source: "nginx"},
+	}, entries)
+	assert.Equal(t, map[string]int{"a.txt": 1, "b.txt": 1, "c.txt": 1}, opened)
+}
+
+func TestParseMaxEntries(t *testing.T) {
+	resolve := files(map[string]string{
+		"more.txt": strings.Repeat("busybox\n", imagelist.MaxEntries),
+	})
+	_, err := imagelist.Parse("images.txt", strings.NewReader("alpine\ninclude more.txt\n"), resolve)
+	assert.EqualError(t, err, "more.txt:10000: lists are limited to 10000 entries")
+}
+
+func TestParseWithoutResolver(t *testing.T) {
+	_, err := imagelist.Parse("query", strings.NewReader("include base.txt"), nil)
+	assert.EqualError(t, err, "query:1: includes are not supported")
+}
+
+func files(content map[string]string) imagelist.Resolver {
+	return func(name string) (io.ReadCloser, error) {
+		c, ok := content[name]
+		if !ok {
+			return nil, errors.New(name + " not found")
+		}
+		return ioutil.NopCloser(strings.NewReader(c)), nil
+	}
+}
@@ -0,0 +1,87 @@
+// Package kube extracts image references from Kubernetes manifests.
+package kube
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// object is a decoded document. Documents are not decoded into structs, so
+// custom resources of any shape don't fail the whole stream.
+type object = map[string]interface{}
+
+// Images returns the deduplicated container images of all workloads in a
+// multi-document YAML stream, for example the output of `helm template`.
+func Images(r io.Reader) ([]string, error) {
+	images := []string{}
+	seen := map[string]bool{}
+	add := func(spec object) {
+		for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+			containers, _ := spec[field].([]interface{})
+			for _, c := range containers {
+				c, _ := c.(object)
+				img, _ := c["image"].(string)
+				if img != "" && !seen[img] {
+					seen[img] = true
+					images = append(images, img)
+				}
+			}
+		}
+	}
+
+	dec := yaml.NewDecoder(r)
+	for n := 1; ; n++ {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", n, err)
+		}
+		obj, _ := doc.(object)
+		for _, spec := range podSpecs(obj) {
+			add(spec)
+		}
+	}
+	return images, nil
+}
+
+// podSpecs returns the pod specs of a workload. Unknown kinds and documents
+// not shaped like their kind are skipped.
+func podSpecs(obj object) []object {
+	var spec object
+	switch kind, _ := obj["kind"].(string); kind {
+	case "Pod":
+		spec = lookup(obj, "spec")
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job":
+		spec = lookup(obj, "spec", "template", "spec")
+	case "PodTemplate":
+		spec = lookup(obj, "template", "spec")
+	case "CronJob":
+		spec = lookup(obj, "spec", "jobTemplate", "spec", "template", "spec")
+	case "List":
+		items, _ := obj["items"].([]interface{})
+		specs := []object{}
+		for _, item := range items {
+			if item, ok := item.(object); ok {
+				specs = append(specs, podSpecs(item)...)
+			}
+		}
+		return specs
+	}
+	if spec == nil {
+		return nil
+	}
+	return []object{spec}
+}
+
+// lookup returns the nested object at path or nil.
+func lookup(obj object, path ...string) object {
+	for _, key := range path {
+		obj, _ = obj[key].(object)
+	}
+	return obj
+}
@@ -0,0 +1,134 @@
+package kube_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/kube"
+	"github.com/stretchr/testify/assert"
+)
+
+var testManifests = `
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: registry.example.com/app-migrations:v1
+      containers:
+      - name: app
+        image: registry.example.com/app:v1
+      - name: proxy
+        image: envoyproxy/envoy:v1.27.0
+---
+---
+apiVersion: apps/v1
+kind: StatefulSet
+spec:
+  template:
+    spec:
+      containers:
+      - image: postgres:15
+---
+apiVersion: apps/v1
+kind: DaemonSet
+spec:
+  template:
+    spec:
+      containers:
+      - image: envoyproxy/envoy:v1.27.0
+---
+apiVersion: batch/v1
+kind: Job
+spec:
+  template:
+    spec:
+      containers:
+      - image: busybox:1.36
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - image: bitnami/kubectl:1.28
+---
+apiVersion: v1
+kind: Pod
+spec:
+  containers:
+  - image: nginx:1.25
+  ephemeralContainers:
+  - image: nicolaka/netshoot
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  spec:
+    containers:
+    - image: redis:7
+---
+apiVersion: v1
+kind: PodTemplate
+template:
+  spec:
+    containers:
+    - image: alpine:3.19
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+spec:
+  template: "{{ .Values.template }}"
+  containers: 3
+---
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers: "not a list"
+      initContainers:
+      - "not a container"
+      - image: 42
+---
+- not
+- an object
+---
+apiVersion: v1
+kind: Service
+spec:
+  ports:
+  - port: 80
+`
+
+func TestImages(t *testing.T) {
+	images, err := kube.Images(strings.NewReader(testManifests))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"registry.example.com/app-migrations:v1",
+		"registry.example.com/app:v1",
+		"envoyproxy/envoy:v1.27.0",
+		"postgres:15",
+		"busybox:1.36",
+		"bitnami/kubectl:1.28",
+		"nginx:1.25",
+		"nicolaka/netshoot",
+		"redis:7",
+		"alpine:3.19",
+	}, images)
+}
+
+func TestImagesInvalid(t *testing.T) {
+	_, err := kube.Images(strings.NewReader("kind: Pod\n---\nkind: [\n"))
+	assert.EqualError(t, err, "document 2: yaml: line 3: did not find expected node content")
+}
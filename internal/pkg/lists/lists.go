@@ -0,0 +1,330 @@
+// Package lists stores named image lists and the archives built from them.
+//
+// A list named "base" is stored as base.json in the store's directory, its
+// archives as base/<id>.tar next to their metadata in base/<id>.json.
+package lists
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/cron"
+)
+
+// DefaultKeep is the number of archives kept per list if not configured.
+const DefaultKeep = 3
+
+// ErrNotFound is returned for unknown lists and archives.
+var ErrNotFound = errors.New("not found")
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// List is a named image list.
+type List struct {
+	Name string `json:"name"`
+	// Images is an image list in the format of images.txt.
+	Images string `json:"images"`
+	// Schedule is a cron expression. Archives are only built on request
+	// if it is empty.
+	Schedule string `json:"schedule,omitempty"`
+	// Keep is the number of archives kept. Defaults to DefaultKeep.
+	Keep int `json:"keep,omitempty"`
+	// Options are request parameters applied when building archives, e.g.
+	// "format" or "checksums".
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Validate checks the name, schedule and number of kept archives. The
+// images and options are left to the caller.
+func (l List) Validate() error {
+	if err := ValidateName(l.Name); err != nil {
+		return err
+	}
+	if l.Schedule != "" {
+		if _, err := cron.Parse(l.Schedule); err != nil {
+			return err
+		}
+	}
+	if l.Keep < 0 {
+		return fmt.Errorf("invalid keep %d, expected a positive number", l.Keep)
+	}
+	return nil
+}
+
+// ValidateName checks that the name is safe to use in paths and URLs.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid list name %q, expected up to 63 lowercase letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+// Archive is an archive built from a list.
+type Archive struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Images  []string  `json:"images"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+}
+
+// Store keeps lists and their archives in a directory.
+type Store struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// Get returns the list or ErrNotFound.
+func (s *Store) Get(name string) (List, error) {
+	var l List
+	if err := ValidateName(name); err != nil {
+		return l, ErrNotFound
+	}
+	err := readJSON(filepath.Join(s.Dir, name+".json"), &l)
+	return l, err
+}
+
+// All returns all lists ordered by name.
+func (s *Store) All() ([]List, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	lists := make([]List, 0, len(matches))
+	for _, m := range matches {
+		l, err := s.Get(strings.TrimSuffix(filepath.Base(m), ".json"))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, nil
+}
+
+// Put creates or replaces the list. It reports whether the list was created.
+// Archives exceeding the list's Keep are removed.
+func (s *Store) Put(l List) (bool, error) {
+	if err := l.Validate(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(filepath.Join(s.Dir, l.Name+".json"))
+	created := os.IsNotExist(err)
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err := writeFile(filepath.Join(s.Dir, l.Name+".json"), b); err != nil {
+		return false, err
+	}
+	return created, s.prune(l)
+}
+
+// Delete removes the list and its archives.
+func (s *Store) Delete(name string) error {
+	if err := ValidateName(name); err != nil {
+		return ErrNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(filepath.Join(s.Dir, name+".json"))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.Dir, name))
+}
+
+// Archives returns the archives of the list, newest first.
+func (s *Store) Archives(name string) ([]Archive, error) {
+	if _, err := s.Get(name); err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(s.Dir, name, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	archives := make([]Archive, 0, len(matches))
+	for _, m := range matches {
+		var a Archive
+		if err := readJSON(m, &a); err != nil {
+			return nil, err
+		}
+		archives = append(archives, a)
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		if archives[i].Created.Equal(archives[j].Created) {
+			return archives[i].ID > archives[j].ID
+		}
+		return archives[i].Created.After(archives[j].Created)
+	})
+	return archives, nil
+}
+
+// Open opens the archive with the given ID. The ID "latest" opens the newest
+// archive.
+func (s *Store) Open(name, id string) (*os.File, Archive, error) {
+	archives, err := s.Archives(name)
+	if err != nil {
+		return nil, Archive{}, err
+	}
+	for _, a := range archives {
+		if a.ID == id || id == "latest" {
+			f, err := os.Open(filepath.Join(s.Dir, name, a.ID+".tar"))
+			if os.IsNotExist(err) {
+				// Pruned in the meantime.
+				return nil, Archive{}, ErrNotFound
+			}
+			return f, a, err
+		}
+	}
+	return nil, Archive{}, ErrNotFound
+}
+
+// Add stores the archive read from r for the list. check is called once r
+// has been read completely; if it fails, the archive is discarded. The
+// oldest archives exceeding the list's Keep are removed.
+func (s *Store) Add(name string, r io.Reader, images []string, check func() error) (Archive, error) {
+	if _, err := s.Get(name); err != nil {
+		return Archive{}, err
+	}
+	dir := filepath.Join(s.Dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Archive{}, err
+	}
+	f, err := ioutil.TempFile(dir, ".archive-*")
+	if err != nil {
+		return Archive{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(f, io.TeeReader(r, h))
+	if err != nil {
+		return Archive{}, err
+	}
+	if err := f.Close(); err != nil {
+		return Archive{}, err
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return Archive{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The list may have been deleted or changed while building.
+	l, err := s.Get(name)
+	if err != nil {
+		return Archive{}, err
+	}
+	a := Archive{
+		Created: time.Now().UTC(),
+		Images:  images,
+		Size:    size,
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+	}
+	a.ID = a.Created.Format("20060102T150405Z")
+	for i := 1; exists(filepath.Join(dir, a.ID+".json")); i++ {
+		a.ID = a.Created.Format("20060102T150405Z") + "-" + strconv.Itoa(i)
+	}
+	if err := os.Rename(f.Name(), filepath.Join(dir, a.ID+".tar")); err != nil {
+		return Archive{}, err
+	}
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return Archive{}, err
+	}
+	if err := writeFile(filepath.Join(dir, a.ID+".json"), b); err != nil {
+		os.Remove(filepath.Join(dir, a.ID+".tar"))
+		return Archive{}, err
+	}
+	return a, s.prune(l)
+}
+
+// prune removes the oldest archives exceeding the list's Keep. It must be
+// called with s.mu held.
+func (s *Store) prune(l List) error {
+	keep := l.Keep
+	if keep == 0 {
+		keep = DefaultKeep
+	}
+	archives, err := s.Archives(l.Name)
+	if err != nil || len(archives) <= keep {
+		return err
+	}
+	for _, a := range archives[keep:] {
+		// Remove the metadata first, archives without it are not listed.
+		if err := os.Remove(filepath.Join(s.Dir, l.Name, a.ID+".json")); err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(s.Dir, l.Name, a.ID+".tar")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFile replaces the file atomically.
+func writeFile(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
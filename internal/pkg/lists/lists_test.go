@@ -0,0 +1,110 @@
+package lists_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/lists"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	subject := &lists.Store{Dir: t.TempDir() + "/lists"}
+
+	created, err := subject.Put(lists.List{Name: "base", Images: "busybox\n", Schedule: "@daily"})
+	assert.NoError(t, err)
+	assert.True(t, created)
+	created, err = subject.Put(lists.List{Name: "base", Images: "busybox\nalpine\n", Keep: 2})
+	assert.NoError(t, err)
+	assert.False(t, created)
+	_, err = subject.Put(lists.List{Name: "apps", Images: "nginx\n", Options: map[string]string{"format": "oci"}})
+	assert.NoError(t, err)
+
+	l, err := subject.Get("base")
+	assert.NoError(t, err)
+	assert.Equal(t, lists.List{Name: "base", Images: "busybox\nalpine\n", Keep: 2}, l)
+	all, err := subject.All()
+	assert.NoError(t, err)
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, "apps", all[0].Name)
+		assert.Equal(t, map[string]string{"format": "oci"}, all[0].Options)
+	}
+
+	_, err = subject.Get("missing")
+	assert.True(t, errors.Is(err, lists.ErrNotFound))
+	_, err = subject.Get("../base")
+	assert.True(t, errors.Is(err, lists.ErrNotFound))
+
+	assert.NoError(t, subject.Delete("apps"))
+	assert.True(t, errors.Is(subject.Delete("apps"), lists.ErrNotFound))
+	all, err = subject.All()
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestArchives(t *testing.T) {
+	subject := &lists.Store{Dir: t.TempDir()}
+	_, err := subject.Put(lists.List{Name: "base", Images: "busybox\n", Keep: 2})
+	assert.NoError(t, err)
+
+	var ids []string
+	for _, content := range []string{"first", "second", "third"} {
+		a, err := subject.Add("base", strings.NewReader(content), []string{"docker.io/library/busybox:latest"}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(content)), a.Size)
+		ids = append(ids, a.ID)
+	}
+	assert.Equal(t, "b1e99324505bd32da0e1f85dcf5e19a09db0481e8a15f62c41eb320304a8e927", sha256Of(t, subject, "base", ids[2]))
+
+	archives, err := subject.Archives("base")
+	assert.NoError(t, err)
+	if assert.Len(t, archives, 2) {
+		assert.Equal(t, ids[2], archives[0].ID)
+		assert.Equal(t, ids[1], archives[1].ID)
+		assert.Equal(t, []string{"docker.io/library/busybox:latest"}, archives[0].Images)
+	}
+
+	f, a, err := subject.Open("base", "latest")
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "third", string(b))
+	assert.Equal(t, ids[2], a.ID)
+
+	_, _, err = subject.Open("base", ids[0])
+	assert.True(t, errors.Is(err, lists.ErrNotFound), "pruned")
+
+	_, err = subject.Add("base", strings.NewReader("rejected"), nil, func() error { return errors.New("vulnerable") })
+	assert.EqualError(t, err, "vulnerable")
+	archives, err = subject.Archives("base")
+	assert.NoError(t, err)
+	assert.Equal(t, ids[2], archives[0].ID)
+
+	_, err = subject.Put(lists.List{Name: "base", Images: "busybox\n", Keep: 1})
+	assert.NoError(t, err)
+	archives, err = subject.Archives("base")
+	assert.NoError(t, err)
+	assert.Len(t, archives, 1)
+
+	_, err = subject.Add("missing", strings.NewReader(""), nil, nil)
+	assert.True(t, errors.Is(err, lists.ErrNotFound))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, lists.List{Name: "platform-base_1.0"}.Validate())
+	assert.EqualError(t, lists.List{Name: "Base"}.Validate(), `invalid list name "Base", expected up to 63 lowercase letters, digits, '.', '_' or '-'`)
+	assert.EqualError(t, lists.List{Name: ".hidden"}.Validate(), `invalid list name ".hidden", expected up to 63 lowercase letters, digits, '.', '_' or '-'`)
+	assert.EqualError(t, lists.List{Name: "base", Schedule: "daily"}.Validate(), `invalid cron expression "daily": expected 5 fields, got 1`)
+	assert.EqualError(t, lists.List{Name: "base", Keep: -1}.Validate(), "invalid keep -1, expected a positive number")
+}
+
+func sha256Of(t *testing.T, s *lists.Store, name, id string) string {
+	t.Helper()
+	f, a, err := s.Open(name, id)
+	assert.NoError(t, err)
+	f.Close()
+	return a.SHA256
+}
@@ -0,0 +1,82 @@
+// Package mirror rewrites image references to pull them through registry
+// mirrors or pull-through caches.
+package mirror
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// Rule redirects pulls from a source registry to a mirror.
+//
+// Source is a registry hostname, optionally with a repository path prefix
+// ("docker.io", "quay.io/foo"). Mirror is the registry and path prefix the
+// remainder of the repository is appended to ("harbor.internal/dockerhub").
+type Rule struct {
+	Source string
+	Mirror string
+}
+
+type Rules []Rule
+
+// Parse parses a comma or whitespace separated list of "source=mirror" rules.
+func Parse(s string) (Rules, error) {
+	rules := Rules{}
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	}) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid mirror rule %q: expected source=mirror", field)
+		}
+		rules = append(rules, Rule{Source: normalizeSource(parts[0]), Mirror: strings.TrimSuffix(parts[1], "/")})
+	}
+	return rules, nil
+}
+
+// Rewrite returns the reference to pull the given image from.
+// The boolean is false if no rule matches the image.
+func (r Rules) Rewrite(image string) (string, bool, error) {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", false, err
+	}
+	name := ref.Name()
+
+	var best Rule
+	for _, rule := range r {
+		src := normalizeSource(rule.Source)
+		if name != src && !strings.HasPrefix(name, src+"/") {
+			continue
+		}
+		if len(src) > len(best.Source) {
+			best = Rule{Source: src, Mirror: rule.Mirror}
+		}
+	}
+	if best.Source == "" {
+		return "", false, nil
+	}
+
+	rewritten := strings.TrimSuffix(best.Mirror, "/") + strings.TrimPrefix(name, best.Source)
+	if tagged, ok := ref.(reference.Tagged); ok {
+		rewritten += ":" + tagged.Tag()
+	}
+	if digested, ok := ref.(reference.Digested); ok {
+		rewritten += "@" + digested.Digest().String()
+	}
+	return rewritten, true, nil
+}
+
+func normalizeSource(source string) string {
+	source = strings.TrimPrefix(source, "https://")
+	source = strings.TrimPrefix(source, "http://")
+	source = strings.ToLower(strings.TrimSuffix(source, "/"))
+	for _, legacy := range []string{"index.docker.io", "registry-1.docker.io"} {
+		if source == legacy || strings.HasPrefix(source, legacy+"/") {
+			source = "docker.io" + strings.TrimPrefix(source, legacy)
+		}
+	}
+	return source
+}
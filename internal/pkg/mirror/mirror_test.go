@@ -0,0 +1,60 @@
+package mirror_test
+
+import (
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/mirror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	subject, err := mirror.Parse("docker.io=harbor.internal/dockerhub/, https://quay.io=harbor.internal/quay")
+	assert.NoError(t, err)
+	assert.Equal(t, mirror.Rules{
+		{Source: "docker.io", Mirror: "harbor.internal/dockerhub"},
+		{Source: "quay.io", Mirror: "harbor.internal/quay"},
+	}, subject)
+
+	subject, err = mirror.Parse("")
+	assert.NoError(t, err)
+	assert.Empty(t, subject)
+
+	_, err = mirror.Parse("docker.io")
+	assert.Error(t, err)
+	_, err = mirror.Parse("docker.io=")
+	assert.Error(t, err)
+}
+
+func TestRewrite(t *testing.T) {
+	subject := mirror.Rules{
+		{Source: "index.docker.io", Mirror: "harbor.internal/dockerhub"},
+		{Source: "docker.io/bitnami", Mirror: "harbor.internal/bitnami"},
+		{Source: "quay.io", Mirror: "harbor.internal/quay"},
+	}
+
+	tests := []struct {
+		image    string
+		expected string
+		matched  bool
+	}{
+		{"busybox", "harbor.internal/dockerhub/library/busybox", true},
+		{"busybox:1.36", "harbor.internal/dockerhub/library/busybox:1.36", true},
+		{"bitnami/redis:7", "harbor.internal/bitnami/redis:7", true},
+		{"bitnamifoo/redis", "harbor.internal/dockerhub/bitnamifoo/redis", true},
+		{"quay.io/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000000", "harbor.internal/quay/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000000", true},
+		{"ghcr.io/foo/bar", "", false},
+		{"quay.io.evil/foo/bar", "", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.image, func(t *testing.T) {
+			rewritten, matched, err := subject.Rewrite(tc.image)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.matched, matched)
+			assert.Equal(t, tc.expected, rewritten)
+		})
+	}
+
+	_, _, err := subject.Rewrite("Invalid")
+	assert.Error(t, err)
+}
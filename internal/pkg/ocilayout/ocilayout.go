@@ -0,0 +1,118 @@
+// Package ocilayout writes OCI image layouts as tar archives.
+//
+// See https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+package ocilayout
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// AnnotationContainerdImageName is the image name used by `ctr import`.
+	AnnotationContainerdImageName = "io.containerd.image.name"
+)
+
+// Writer writes blobs and an index into a tar archive.
+type Writer struct {
+	tw      *tar.Writer
+	written map[digest.Digest]bool
+	index   ocispec.Index
+}
+
+// NewWriter returns a Writer writing to w. The layout is complete after
+// calling Close.
+func NewWriter(w io.Writer) *Writer {
+	idx := ocispec.Index{Manifests: []ocispec.Descriptor{}}
+	idx.SchemaVersion = 2
+	return &Writer{
+		tw:      tar.NewWriter(w),
+		written: map[digest.Digest]bool{},
+		index:   idx,
+	}
+}
+
+// HasBlob reports whether the blob was already written.
+func (w *Writer) HasBlob(dgst digest.Digest) bool {
+	return w.written[dgst]
+}
+
+// WriteBlob copies the blob from r. Blobs already written are skipped. The
+// content must match the digest and size of the descriptor.
+func (w *Writer) WriteBlob(desc ocispec.Descriptor, r io.Reader) error {
+	if w.written[desc.Digest] {
+		return nil
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return err
+	}
+
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     fmt.Sprintf("blobs/%s/%s", desc.Digest.Algorithm(), desc.Digest.Encoded()),
+		Size:     desc.Size,
+		Mode:     0444,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(w.tw, verifier), io.LimitReader(r, desc.Size))
+	if err != nil {
+		return err
+	}
+	if n != desc.Size || !verifier.Verified() {
+		return fmt.Errorf("blob %s: content does not match descriptor", desc.Digest)
+	}
+	w.written[desc.Digest] = true
+	return nil
+}
+
+// AddManifest lists the manifest in the index of the layout. The manifest
+// blob must be written separately.
+func (w *Writer) AddManifest(desc ocispec.Descriptor) {
+	w.index.Manifests = append(w.index.Manifests, desc)
+}
+
+// Close writes the index and the layout marker and flushes the archive.
+func (w *Writer) Close() error {
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := w.writeFile(ocispec.ImageLayoutFile, layout); err != nil {
+		return err
+	}
+
+	index, err := json.Marshal(w.index)
+	if err != nil {
+		return err
+	}
+	if err := w.writeFile("index.json", index); err != nil {
+		return err
+	}
+	return w.tw.Close()
+}
+
+func (w *Writer) writeFile(name string, content []byte) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(content)),
+		Mode:     0444,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tw.Write(content)
+	return err
+}
@@ -0,0 +1,64 @@
+package ocilayout
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter(t *testing.T) {
+	b := new(bytes.Buffer)
+	subject := NewWriter(b)
+
+	blob := []byte("blob")
+	desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	assert.False(t, subject.HasBlob(desc.Digest))
+	assert.NoError(t, subject.WriteBlob(desc, bytes.NewReader(blob)))
+	assert.True(t, subject.HasBlob(desc.Digest))
+	assert.NoError(t, subject.WriteBlob(desc, bytes.NewReader(blob)), "duplicates are skipped")
+	desc.Annotations = map[string]string{ocispec.AnnotationRefName: "example.com/app:v1"}
+	subject.AddManifest(desc)
+	assert.NoError(t, subject.Close())
+
+	files := map[string][]byte{}
+	names := []string{}
+	tr := tar.NewReader(b)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+		files[hdr.Name] = content
+	}
+
+	assert.Equal(t, []string{"blobs/sha256/" + desc.Digest.Encoded(), "oci-layout", "index.json"}, names)
+	assert.JSONEq(t, `{"imageLayoutVersion":"1.0.0"}`, string(files["oci-layout"]))
+	var idx ocispec.Index
+	assert.NoError(t, json.Unmarshal(files["index.json"], &idx))
+	assert.Equal(t, 2, idx.SchemaVersion)
+	assert.Equal(t, []ocispec.Descriptor{desc}, idx.Manifests)
+}
+
+func TestWriterVerifiesContent(t *testing.T) {
+	subject := NewWriter(ioutil.Discard)
+
+	desc := ocispec.Descriptor{Digest: digest.FromString("blob"), Size: 4}
+	err := subject.WriteBlob(desc, strings.NewReader("evil"))
+	assert.EqualError(t, err, "blob "+desc.Digest.String()+": content does not match descriptor")
+	assert.False(t, subject.HasBlob(desc.Digest))
+
+	err = subject.WriteBlob(desc, strings.NewReader("bl"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,262 @@
+// Package openapi reads the subset of OpenAPI 3 documents needed to describe
+// the saveomat API and validates requests against it.
+//
+// Parameters and request bodies are validated by their schema's type, enum,
+// items, properties, required properties, additional properties and minimum.
+// References are only supported to components.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Document is an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []Server             `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lower case HTTP methods to operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	In          string  `json:"in,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Ref         string                `json:"$ref,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string                `json:"$ref,omitempty"`
+	Type                 string                `json:"type,omitempty"`
+	Format               string                `json:"format,omitempty"`
+	Description          string                `json:"description,omitempty"`
+	Enum                 []string              `json:"enum,omitempty"`
+	Minimum              *float64              `json:"minimum,omitempty"`
+	Items                *Schema               `json:"items,omitempty"`
+	Properties           map[string]*Schema    `json:"properties,omitempty"`
+	Required             []string              `json:"required,omitempty"`
+	AdditionalProperties *AdditionalProperties `json:"additionalProperties,omitempty"`
+}
+
+// AdditionalProperties is either a boolean or a schema. Additional
+// properties are allowed if it is missing.
+type AdditionalProperties struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *AdditionalProperties) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(b, &a.Schema)
+}
+
+func (a AdditionalProperties) MarshalJSON() ([]byte, error) {
+	if a.Schema != nil {
+		return json.Marshal(a.Schema)
+	}
+	return json.Marshal(a.Allowed)
+}
+
+type Components struct {
+	Schemas    map[string]*Schema    `json:"schemas,omitempty"`
+	Parameters map[string]*Parameter `json:"parameters,omitempty"`
+	Responses  map[string]*Response  `json:"responses,omitempty"`
+}
+
+// Load parses the document and resolves its references.
+func Load(b []byte) (*Document, error) {
+	var d Document
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+	if err := d.resolve(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Operation returns the operation of the path template, e.g.
+// "/lists/{name}", or nil.
+func (d *Document) Operation(method, path string) *Operation {
+	item := d.Paths[path]
+	if item == nil {
+		return nil
+	}
+	return (*item)[strings.ToLower(method)]
+}
+
+// Operations returns all operations as "METHOD /path", sorted.
+func (d *Document) Operations() []string {
+	var ops []string
+	for path, item := range d.Paths {
+		for method := range *item {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// resolve replaces references with the referenced components. Referenced
+// components are not copied, so recursive schemas are possible.
+func (d *Document) resolve() error {
+	r := resolver{components: d.Components}
+	for name, s := range d.Components.Schemas {
+		d.Components.Schemas[name] = r.schema(s)
+	}
+	for name, p := range d.Components.Parameters {
+		d.Components.Parameters[name] = r.parameter(p)
+	}
+	for name, resp := range d.Components.Responses {
+		d.Components.Responses[name] = r.response(resp)
+	}
+	for path, item := range d.Paths {
+		if item == nil {
+			return fmt.Errorf("path %s: missing operations", path)
+		}
+		for method, op := range *item {
+			if op == nil {
+				return fmt.Errorf("%s %s: missing operation", method, path)
+			}
+			for i, p := range op.Parameters {
+				op.Parameters[i] = r.parameter(p)
+			}
+			if op.RequestBody != nil {
+				r.content(op.RequestBody.Content)
+			}
+			for status, resp := range op.Responses {
+				op.Responses[status] = r.response(resp)
+			}
+		}
+	}
+	return r.err
+}
+
+type resolver struct {
+	components Components
+	// err is the first unresolvable reference.
+	err error
+}
+
+func (r *resolver) target(ref, kind string) string {
+	prefix := "#/components/" + kind + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		r.fail(ref)
+		return ""
+	}
+	return strings.TrimPrefix(ref, prefix)
+}
+
+func (r *resolver) fail(ref string) {
+	if r.err == nil {
+		r.err = fmt.Errorf("unresolvable reference %q", ref)
+	}
+}
+
+func (r *resolver) schema(s *Schema) *Schema {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		if target := r.components.Schemas[r.target(s.Ref, "schemas")]; target != nil {
+			return target
+		}
+		r.fail(s.Ref)
+		return s
+	}
+	s.Items = r.schema(s.Items)
+	for name, p := range s.Properties {
+		s.Properties[name] = r.schema(p)
+	}
+	if s.AdditionalProperties != nil {
+		s.AdditionalProperties.Schema = r.schema(s.AdditionalProperties.Schema)
+	}
+	return s
+}
+
+func (r *resolver) parameter(p *Parameter) *Parameter {
+	if p.Ref != "" {
+		if target := r.components.Parameters[r.target(p.Ref, "parameters")]; target != nil {
+			return target
+		}
+		r.fail(p.Ref)
+		return p
+	}
+	p.Schema = r.schema(p.Schema)
+	return p
+}
+
+func (r *resolver) response(resp *Response) *Response {
+	if resp.Ref != "" {
+		if target := r.components.Responses[r.target(resp.Ref, "responses")]; target != nil {
+			return target
+		}
+		r.fail(resp.Ref)
+		return resp
+	}
+	for _, h := range resp.Headers {
+		h.Schema = r.schema(h.Schema)
+	}
+	r.content(resp.Content)
+	return resp
+}
+
+func (r *resolver) content(content map[string]*MediaType) {
+	for _, mt := range content {
+		mt.Schema = r.schema(mt.Schema)
+	}
+}
@@ -0,0 +1,204 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDocument = `{
+	"openapi": "3.0.3",
+	"info": {"title": "test", "version": "1"},
+	"paths": {
+		"/items/{id}": {
+			"get": {
+				"operationId": "getItem",
+				"parameters": [
+					{"$ref": "#/components/parameters/id"},
+					{"name": "tag", "in": "query", "schema": {"type": "array", "items": {"type": "string"}}},
+					{"name": "count", "in": "query", "schema": {"type": "integer", "minimum": 1}}
+				],
+				"responses": {"200": {"$ref": "#/components/responses/Item"}}
+			},
+			"put": {
+				"operationId": "putItem",
+				"parameters": [{"$ref": "#/components/parameters/id"}],
+				"requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}},
+				"responses": {"200": {"$ref": "#/components/responses/Item"}}
+			},
+			"post": {
+				"operationId": "uploadItem",
+				"requestBody": {"content": {"multipart/form-data": {"schema": {
+					"type": "object",
+					"required": ["content"],
+					"properties": {
+						"content": {"type": "string", "format": "binary"},
+						"public": {"type": "boolean"}
+					},
+					"additionalProperties": false
+				}}}},
+				"responses": {"204": {"description": "Uploaded."}}
+			}
+		}
+	},
+	"components": {
+		"parameters": {
+			"id": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}
+		},
+		"schemas": {
+			"Item": {
+				"type": "object",
+				"required": ["name"],
+				"properties": {
+					"name": {"type": "string"},
+					"kind": {"type": "string", "enum": ["a", "b"]},
+					"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+					"children": {"type": "array", "items": {"$ref": "#/components/schemas/Item"}}
+				},
+				"additionalProperties": false
+			}
+		},
+		"responses": {
+			"Item": {"description": "The item.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}}
+		}
+	}
+}`
+
+func loadTestDocument(t *testing.T) *Document {
+	t.Helper()
+	doc, err := Load([]byte(testDocument))
+	assert.NoError(t, err)
+	return doc
+}
+
+func TestLoad(t *testing.T) {
+	doc := loadTestDocument(t)
+	assert.Equal(t, []string{"GET /items/{id}", "POST /items/{id}", "PUT /items/{id}"}, doc.Operations())
+
+	op := doc.Operation(http.MethodGet, "/items/{id}")
+	if assert.NotNil(t, op) {
+		assert.Equal(t, "id", op.Parameters[0].Name)
+		assert.Equal(t, "The item.", op.Responses["200"].Description)
+	}
+	item := doc.Components.Schemas["Item"]
+	assert.Same(t, item, item.Properties["children"].Items)
+	assert.Nil(t, doc.Operation(http.MethodDelete, "/items/{id}"))
+	assert.Nil(t, doc.Operation(http.MethodGet, "/other"))
+
+	_, err := Load([]byte(`{"paths": {"/": {"get": {"responses": {"200": {"$ref": "#/components/responses/Missing"}}}}}}`))
+	assert.EqualError(t, err, `unresolvable reference "#/components/responses/Missing"`)
+	_, err = Load([]byte(`{"components": {"schemas": {"A": {"$ref": "other.json#/A"}}}}`))
+	assert.EqualError(t, err, `unresolvable reference "other.json#/A"`)
+}
+
+func TestValidateParameters(t *testing.T) {
+	op := loadTestDocument(t).Operation(http.MethodGet, "/items/{id}")
+	for query, msg := range map[string]string{
+		"":                   "",
+		"?tag=a&tag=b":       "",
+		"?count=3":           "",
+		"?count=0":           `invalid query parameter "count": 0 is less than 1`,
+		"?count=1.5":         `invalid query parameter "count": "1.5" is not a valid integer`,
+		"?count=1&count=2":   `query parameter "count" must not be repeated`,
+		"?limit=1":           `unknown query parameter "limit"`,
+		"?tag=a&limit=1&z=2": `unknown query parameter "limit"`,
+	} {
+		err := ValidateRequest(op, httptest.NewRequest(http.MethodGet, "/items/1"+query, nil), map[string]string{"id": "1"})
+		if msg == "" {
+			assert.NoError(t, err, query)
+		} else {
+			assert.EqualError(t, err, msg, query)
+		}
+	}
+
+	err := ValidateRequest(op, httptest.NewRequest(http.MethodGet, "/items/x", nil), map[string]string{"id": "x"})
+	assert.EqualError(t, err, `invalid path parameter "id": "x" is not a valid integer`)
+}
+
+func TestValidateJSON(t *testing.T) {
+	op := loadTestDocument(t).Operation(http.MethodPut, "/items/{id}")
+	for body, msg := range map[string]string{
+		`{"name": "x", "kind": "a", "labels": {"k": "v"}, "children": [{"name": "y"}]}`: "",
+		`{"kind": "a"}`:                              "body.name is required",
+		`{"name": 1}`:                                "body.name must be of type string",
+		`{"name": "x", "kind": "c"}`:                 `body.kind: "c" is not one of a, b`,
+		`{"name": "x", "other": true}`:               "body.other is not allowed",
+		`{"name": "x", "labels": {"k": 1}}`:          "body.labels.k must be of type string",
+		`{"name": "x", "children": [{"kind": "a"}]}`: "body.children[0].name is required",
+		`[]`: "body must be of type object",
+		`{`:  "invalid JSON body: unexpected EOF",
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/items/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		err := ValidateRequest(op, req, map[string]string{"id": "1"})
+		if msg != "" {
+			assert.EqualError(t, err, msg, body)
+			continue
+		}
+		if assert.NoError(t, err, body) {
+			// The body can still be read by the handler.
+			b, _ := ioutil.ReadAll(req.Body)
+			assert.Equal(t, body, string(b))
+		}
+	}
+
+	err := ValidateRequest(op, httptest.NewRequest(http.MethodPut, "/items/1", nil), map[string]string{"id": "1"})
+	assert.EqualError(t, err, "missing request body")
+
+	req := httptest.NewRequest(http.MethodPut, "/items/1", strings.NewReader("name: x"))
+	req.Header.Set("Content-Type", "application/yaml")
+	err = ValidateRequest(op, req, map[string]string{"id": "1"})
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, http.StatusUnsupportedMediaType, e.Status)
+		assert.Equal(t, `unsupported content type "application/yaml", expected one of application/json`, e.Message)
+	}
+}
+
+func TestValidateForm(t *testing.T) {
+	op := loadTestDocument(t).Operation(http.MethodPost, "/items/{id}")
+	type field struct{ name, value string }
+	for _, tc := range []struct {
+		files, values []field
+		msg           string
+	}{
+		{files: []field{{"content", "x"}}, values: []field{{"public", "true"}}},
+		{values: []field{{"public", "true"}}, msg: `missing form field "content"`},
+		{files: []field{{"content", "x"}, {"content", "y"}}, msg: `form field "content" must not be repeated`},
+		{values: []field{{"content", "x"}}, msg: `form field "content" must be a file`},
+		{files: []field{{"content", "x"}}, values: []field{{"public", "yes"}}, msg: `invalid form field "public": "yes" is not a boolean`},
+		{files: []field{{"content", "x"}, {"other", "y"}}, msg: `unknown form field "other"`},
+	} {
+		body := new(bytes.Buffer)
+		mpw := multipart.NewWriter(body)
+		for _, f := range tc.files {
+			fw, err := mpw.CreateFormFile(f.name, f.name)
+			assert.NoError(t, err)
+			fw.Write([]byte(f.value))
+		}
+		for _, f := range tc.values {
+			assert.NoError(t, mpw.WriteField(f.name, f.value))
+		}
+		assert.NoError(t, mpw.Close())
+		req := httptest.NewRequest(http.MethodPost, "/items/1", body)
+		req.Header.Set("Content-Type", mpw.FormDataContentType())
+
+		err := ValidateRequest(op, req, map[string]string{"id": "1"})
+		if tc.msg == "" {
+			assert.NoError(t, err)
+			assert.NotNil(t, req.MultipartForm)
+		} else {
+			assert.EqualError(t, err, tc.msg)
+		}
+	}
+
+	// The body is optional.
+	assert.NoError(t, ValidateRequest(op, httptest.NewRequest(http.MethodPost, "/items/1", nil), nil))
+}
@@ -0,0 +1,320 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxMemory is the memory used for parsing multipart forms, like
+// echo.Context.MultipartForm does.
+const maxMemory = 32 << 20
+
+// Error is a request not matching the document.
+type Error struct {
+	// Status is http.StatusBadRequest or http.StatusUnsupportedMediaType.
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func invalid(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// ValidateRequest checks the parameters and the body of the request. Query
+// parameters not described by the operation are rejected. pathParams are the
+// values of the path parameters. The body remains readable; multipart forms
+// are parsed into r.MultipartForm.
+func ValidateRequest(op *Operation, r *http.Request, pathParams map[string]string) error {
+	query := r.URL.Query()
+	known := map[string]bool{}
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			if err := validateParam("path parameter", p.Name, p.Schema, []string{pathParams[p.Name]}); err != nil {
+				return err
+			}
+		case "query":
+			known[p.Name] = true
+			values, ok := query[p.Name]
+			if !ok {
+				if p.Required {
+					return invalid("missing query parameter %q", p.Name)
+				}
+				continue
+			}
+			if err := validateParam("query parameter", p.Name, p.Schema, values); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range sortedKeys(map[string][]string(query)) {
+		if !known[name] {
+			return invalid("unknown query parameter %q", name)
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	return validateBody(op.RequestBody, r)
+}
+
+func validateBody(body *RequestBody, r *http.Request) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" && (r.ContentLength == 0 || r.Body == nil || r.Body == http.NoBody) {
+		if body.Required {
+			return invalid("missing request body")
+		}
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return &Error{Status: http.StatusUnsupportedMediaType, Message: fmt.Sprintf("invalid content type %q", contentType)}
+	}
+	mt, ok := body.Content[mediaType]
+	if !ok {
+		return &Error{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("unsupported content type %q, expected one of %s", mediaType, strings.Join(sortedKeys(body.Content), ", ")),
+		}
+	}
+	if mt.Schema == nil {
+		return nil
+	}
+
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return invalid("invalid form: %v", err)
+		}
+		return validateForm(mt.Schema, r)
+	case "application/json":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return invalid("invalid JSON body: %v", err)
+		}
+		if err := validateValue(mt.Schema, v, "body"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateParam validates the values of a parameter, which may only be
+// repeated if it is an array.
+func validateParam(kind, name string, s *Schema, values []string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Type != "array" && len(values) > 1 {
+		return invalid("%s %q must not be repeated", kind, name)
+	}
+	if s.Type == "array" {
+		s = s.Items
+	}
+	for _, v := range values {
+		if msg := checkString(s, v); msg != "" {
+			return invalid("invalid %s %q: %s", kind, name, msg)
+		}
+	}
+	return nil
+}
+
+// validateForm validates the fields of a multipart form against an object
+// schema. Fields of binary strings must be files, all others values.
+func validateForm(s *Schema, r *http.Request) error {
+	form := r.MultipartForm
+	fields := map[string]bool{}
+	for name := range form.Value {
+		fields[name] = true
+	}
+	for name := range form.File {
+		fields[name] = true
+	}
+	for _, name := range s.Required {
+		if !fields[name] {
+			return invalid("missing form field %q", name)
+		}
+	}
+
+	for _, name := range sortedKeys(fields) {
+		prop := s.Properties[name]
+		if prop == nil {
+			if s.AdditionalProperties != nil && !s.AdditionalProperties.Allowed {
+				return invalid("unknown form field %q", name)
+			}
+			if s.AdditionalProperties == nil || s.AdditionalProperties.Schema == nil {
+				continue
+			}
+			prop = s.AdditionalProperties.Schema
+		}
+		item := prop
+		count := len(form.Value[name]) + len(form.File[name])
+		if prop.Type == "array" {
+			item = prop.Items
+		} else if count > 1 {
+			return invalid("form field %q must not be repeated", name)
+		}
+		if item == nil {
+			continue
+		}
+		if item.Format == "binary" {
+			if len(form.Value[name]) > 0 {
+				return invalid("form field %q must be a file", name)
+			}
+			continue
+		}
+		for _, v := range form.Value[name] {
+			if msg := checkString(item, v); msg != "" {
+				return invalid("invalid form field %q: %s", name, msg)
+			}
+		}
+	}
+	return nil
+}
+
+// checkString checks a parameter or form value. It returns the problem or an
+// empty string.
+func checkString(s *Schema, v string) string {
+	if s == nil {
+		return ""
+	}
+	switch s.Type {
+	case "boolean":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Sprintf("%q is not a boolean", v)
+		}
+	case "integer", "number":
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || (s.Type == "integer" && strings.ContainsAny(v, ".eE")) {
+			return fmt.Sprintf("%q is not a valid %s", v, s.Type)
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Sprintf("%s is less than %v", v, *s.Minimum)
+		}
+	}
+	return checkEnum(s, v)
+}
+
+func checkEnum(s *Schema, v string) string {
+	if len(s.Enum) == 0 {
+		return ""
+	}
+	for _, e := range s.Enum {
+		if v == e {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%q is not one of %s", v, strings.Join(s.Enum, ", "))
+}
+
+// validateValue validates a decoded JSON value. path names the value in
+// errors, e.g. "body.images[0]".
+func validateValue(s *Schema, v interface{}, path string) error {
+	if s == nil {
+		return nil
+	}
+	mismatch := func() error {
+		return invalid("%s must be of type %s", path, s.Type)
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return invalid("%s.%s is required", path, name)
+			}
+		}
+		for _, name := range sortedKeys(obj) {
+			prop := s.Properties[name]
+			if prop == nil {
+				if s.AdditionalProperties != nil && !s.AdditionalProperties.Allowed {
+					return invalid("%s.%s is not allowed", path, name)
+				}
+				if s.AdditionalProperties == nil {
+					continue
+				}
+				prop = s.AdditionalProperties.Schema
+			}
+			if err := validateValue(prop, obj[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i, item := range arr {
+			if err := validateValue(s.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		if msg := checkEnum(s, str); msg != "" {
+			return invalid("%s: %s", path, msg)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		if msg := checkString(s, n.String()); msg != "" {
+			return invalid("%s: %s", path, msg)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string][]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]bool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*MediaType:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,189 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// authorize answers the WWW-Authenticate challenge of a registry by adding an
+// Authorization header to req.
+func (c *Client) authorize(ctx context.Context, req *http.Request, host, scope, challenge string, ac types.AuthConfig) error {
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if ac.Username == "" {
+			return &Error{StatusCode: http.StatusUnauthorized, Message: "registry requires credentials"}
+		}
+		req.SetBasicAuth(ac.Username, ac.Password)
+		return nil
+	case "bearer":
+		if ac.RegistryToken != "" {
+			req.Header.Set("Authorization", "Bearer "+ac.RegistryToken)
+			return nil
+		}
+		tokenScope := scope
+		if params["scope"] != "" {
+			tokenScope = params["scope"]
+		}
+		token, expiresIn, err := c.fetchToken(ctx, params["realm"], params["service"], tokenScope, ac)
+		if err != nil {
+			return err
+		}
+		c.cacheToken(tokenKey(host, scope, ac), token, expiresIn)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	return &Error{StatusCode: http.StatusUnauthorized, Message: fmt.Sprintf("unsupported authentication challenge %q", challenge)}
+}
+
+// defaultTokenExpiry is the validity of tokens without expires_in, as
+// specified by the token authentication specification.
+const defaultTokenExpiry = 60 * time.Second
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// tokenKey identifies a token by registry, scope and the credentials it was
+// obtained with, so a token is never reused for other credentials.
+func tokenKey(host, scope string, ac types.AuthConfig) string {
+	b, _ := json.Marshal(ac)
+	sum := sha256.Sum256(b)
+	return host + " " + scope + " " + hex.EncodeToString(sum[:])
+}
+
+// token returns the cached token for the key, if it has not expired.
+func (c *Client) token(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tokens[key]
+	if !ok || !time.Now().Before(t.expires) {
+		return ""
+	}
+	return t.token
+}
+
+// cacheToken stores the token and drops all expired tokens.
+func (c *Client) cacheToken(key, token string, expiresIn time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.tokens == nil {
+		c.tokens = map[string]cachedToken{}
+	}
+	for k, t := range c.tokens {
+		if !now.Before(t.expires) {
+			delete(c.tokens, k)
+		}
+	}
+	c.tokens[key] = cachedToken{token: token, expires: now.Add(expiresIn)}
+}
+
+// fetchToken returns a token for the scope and its validity.
+func (c *Client) fetchToken(ctx context.Context, realm, service, scope string, ac types.AuthConfig) (string, time.Duration, error) {
+	if realm == "" {
+		return "", 0, fmt.Errorf("authentication challenge without realm")
+	}
+
+	var req *http.Request
+	var err error
+	if ac.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {ac.IdentityToken},
+			"service":       {service},
+			"scope":         {scope},
+			"client_id":     {"saveomat"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		q := url.Values{"scope": {scope}}
+		if service != "" {
+			q.Set("service", service)
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+q.Encode(), nil)
+		if err == nil && ac.Username != "" {
+			req.SetBasicAuth(ac.Username, ac.Password)
+		}
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", 0, errorFromResponse(res)
+	}
+
+	var tr struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return "", 0, fmt.Errorf("decoding token response: %w", err)
+	}
+	expiresIn := defaultTokenExpiry
+	if tr.ExpiresIn > 0 {
+		expiresIn = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	if tr.AccessToken != "" {
+		return tr.AccessToken, expiresIn, nil
+	}
+	return tr.Token, expiresIn, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header like
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	scheme := strings.ToLower(parts[0])
+	if len(parts) == 1 {
+		return scheme, params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenCache(t *testing.T) {
+	subject := NewClient()
+	alice := tokenKey("registry.example.com", "repository:app:pull", types.AuthConfig{Username: "alice", Password: "secret"})
+	anonymous := tokenKey("registry.example.com", "repository:app:pull", types.AuthConfig{})
+	assert.NotEqual(t, alice, anonymous)
+
+	subject.cacheToken(alice, "alice-token", time.Minute)
+	assert.Equal(t, "alice-token", subject.token(alice))
+	assert.Empty(t, subject.token(anonymous))
+
+	// expired tokens are not returned and dropped when caching the next one
+	subject.cacheToken(alice, "expired", -time.Second)
+	assert.Empty(t, subject.token(alice))
+	subject.cacheToken(anonymous, "anonymous-token", time.Minute)
+	assert.Len(t, subject.tokens, 1)
+	assert.Equal(t, "anonymous-token", subject.token(anonymous))
+}
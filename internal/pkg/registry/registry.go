@@ -0,0 +1,256 @@
+// Package registry is a minimal client for the registry HTTP API.
+// It reads manifests and blobs without involving the docker daemon.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// manifestMediaTypes are the manifest types the client accepts.
+var manifestMediaTypes = []string{
+	ocispec.MediaTypeImageIndex,
+	ocispec.MediaTypeImageManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeDockerManifest,
+}
+
+// maxManifestSize limits the size of manifests read into memory.
+const maxManifestSize = 4 << 20
+
+type Client struct {
+	HTTPClient *http.Client
+	// PlainHTTP reports whether a registry is accessed without TLS.
+	PlainHTTP func(host string) bool
+
+	mu sync.Mutex
+	// tokens are bearer tokens by tokenKey.
+	tokens map[string]cachedToken
+}
+
+// NewClient returns a client accessing registries on localhost over plain HTTP
+// and all other registries over HTTPS.
+func NewClient() *Client {
+	return &Client{
+		HTTPClient: http.DefaultClient,
+		PlainHTTP:  IsLocalhost,
+	}
+}
+
+// IsLocalhost reports whether the host is a loopback address.
+func IsLocalhost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Manifest is a manifest as stored in the registry.
+type Manifest struct {
+	ocispec.Descriptor
+	Content []byte
+}
+
+// IsIndex reports whether the manifest is an image index or manifest list.
+func (m *Manifest) IsIndex() bool {
+	return m.MediaType == ocispec.MediaTypeImageIndex || m.MediaType == MediaTypeDockerManifestList
+}
+
+// Index parses the manifest as image index.
+func (m *Manifest) Index() (ocispec.Index, error) {
+	var idx ocispec.Index
+	err := json.Unmarshal(m.Content, &idx)
+	return idx, err
+}
+
+// Image parses the manifest as image manifest.
+func (m *Manifest) Image() (ocispec.Manifest, error) {
+	var mf ocispec.Manifest
+	err := json.Unmarshal(m.Content, &mf)
+	return mf, err
+}
+
+// Error is an error returned by a registry.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	status := strings.ToLower(http.StatusText(e.StatusCode))
+	if e.Message == "" {
+		return status
+	}
+	return status + ": " + e.Message
+}
+
+// Manifest fetches the manifest of the referenced image. References without
+// tag or digest are resolved to the "latest" tag.
+func (c *Client) Manifest(ctx context.Context, ref reference.Named, ac types.AuthConfig) (*Manifest, error) {
+	ref = reference.TagNameOnly(ref)
+
+	var id string
+	if digested, ok := ref.(reference.Digested); ok {
+		id = digested.Digest().String()
+	} else if tagged, ok := ref.(reference.Tagged); ok {
+		id = tagged.Tag()
+	}
+
+	res, err := c.get(ctx, ref, "/manifests/"+id, ac, manifestMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(res.Body, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{Content: content}
+	m.Size = int64(len(content))
+	m.Digest = digest.FromBytes(content)
+	if d, err := digest.Parse(res.Header.Get("Docker-Content-Digest")); err == nil {
+		m.Digest = d
+	}
+	m.MediaType = strings.TrimSpace(strings.Split(res.Header.Get("Content-Type"), ";")[0])
+	if m.MediaType == "" || m.MediaType == "application/json" {
+		var probe struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(content, &probe)
+		m.MediaType = probe.MediaType
+	}
+	return m, nil
+}
+
+// Blob opens a blob of the repository.
+func (c *Client) Blob(ctx context.Context, repo reference.Named, dgst digest.Digest, ac types.AuthConfig) (io.ReadCloser, error) {
+	res, err := c.get(ctx, repo, "/blobs/"+dgst.String(), ac, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Referrers lists the manifests referring to the digest using the OCI
+// referrers API. Registries without the API yield an empty list.
+func (c *Client) Referrers(ctx context.Context, repo reference.Named, dgst digest.Digest, ac types.AuthConfig) ([]ocispec.Descriptor, error) {
+	res, err := c.get(ctx, repo, "/referrers/"+dgst.String(), ac, []string{ocispec.MediaTypeImageIndex})
+	if err != nil {
+		var regErr *Error
+		if errors.As(err, &regErr) && regErr.StatusCode == http.StatusNotFound {
+			return []ocispec.Descriptor{}, nil
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var idx ocispec.Index
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxManifestSize)).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("decoding referrers: %w", err)
+	}
+	if idx.Manifests == nil {
+		idx.Manifests = []ocispec.Descriptor{}
+	}
+	return idx.Manifests, nil
+}
+
+// get requests a path below /v2/<repository>.
+func (c *Client) get(ctx context.Context, repo reference.Named, path string, ac types.AuthConfig, accept []string) (*http.Response, error) {
+	host := reference.Domain(repo)
+	apiHost := host
+	if host == "docker.io" {
+		apiHost = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if c.PlainHTTP != nil && c.PlainHTTP(host) {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://%s/v2/%s%s", scheme, apiHost, reference.Path(repo), path)
+	scope := "repository:" + reference.Path(repo) + ":pull"
+
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	if token := c.token(tokenKey(apiHost, scope, ac)); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		res.Body.Close()
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.authorize(ctx, req, apiHost, scope, challenge, ac); err != nil {
+			return nil, err
+		}
+		res, err = c.HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, errorFromResponse(res)
+	}
+	return res, nil
+}
+
+func errorFromResponse(res *http.Response) error {
+	regErr := &Error{StatusCode: res.StatusCode}
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&body) == nil && len(body.Errors) > 0 {
+		regErr.Code = body.Errors[0].Code
+		regErr.Message = body.Errors[0].Message
+	}
+	return regErr
+}
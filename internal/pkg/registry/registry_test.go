@@ -0,0 +1,125 @@
+package registry_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/registry"
+	"github.com/bastjan/saveomat/internal/pkg/registry/registrytest"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	amd64 := reg.PushImage("foo/bar", "", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("layer"))
+	arm64 := reg.PushImage("foo/bar", "", ocispec.Platform{OS: "linux", Architecture: "arm64"}, []byte("layer"))
+	idx := reg.PushIndex("foo/bar", "v1", amd64, arm64)
+
+	subject := registry.NewClient()
+
+	m, err := subject.Manifest(context.Background(), parse(t, reg.Host()+"/foo/bar:v1"), types.AuthConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, idx.Digest, m.Digest)
+	assert.Equal(t, ocispec.MediaTypeImageIndex, m.MediaType)
+	assert.True(t, m.IsIndex())
+
+	index, err := m.Index()
+	assert.NoError(t, err)
+	assert.Len(t, index.Manifests, 2)
+
+	m, err = subject.Manifest(context.Background(), parse(t, reg.Host()+"/foo/bar@"+arm64.Digest.String()), types.AuthConfig{})
+	assert.NoError(t, err)
+	assert.False(t, m.IsIndex())
+	image, err := m.Image()
+	assert.NoError(t, err)
+
+	rc, err := subject.Blob(context.Background(), parse(t, reg.Host()+"/foo/bar"), image.Layers[0].Digest, types.AuthConfig{})
+	assert.NoError(t, err)
+	defer rc.Close()
+	layer, err := ioutil.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("layer"), layer)
+}
+
+func TestReferrers(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	img := reg.PushImage("foo/bar", "v1", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("layer"))
+	sbom := reg.PushArtifact("foo/bar", "", "application/spdx+json", img, []byte("{}"))
+
+	subject := registry.NewClient()
+
+	referrers, err := subject.Referrers(context.Background(), parse(t, reg.Host()+"/foo/bar"), img.Digest, types.AuthConfig{})
+	assert.NoError(t, err)
+	if assert.Len(t, referrers, 1) {
+		assert.Equal(t, sbom.Digest, referrers[0].Digest)
+	}
+
+	referrers, err = subject.Referrers(context.Background(), parse(t, reg.Host()+"/foo/bar"), sbom.Digest, types.AuthConfig{})
+	assert.NoError(t, err)
+	assert.Empty(t, referrers)
+}
+
+func TestManifestNotFound(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	_, err := registry.NewClient().Manifest(context.Background(), parse(t, reg.Host()+"/foo/bar"), types.AuthConfig{})
+
+	var regErr *registry.Error
+	assert.True(t, errors.As(err, &regErr))
+	assert.Equal(t, http.StatusNotFound, regErr.StatusCode)
+	assert.Equal(t, "MANIFEST_UNKNOWN", regErr.Code)
+	assert.EqualError(t, err, "not found: manifest unknown")
+}
+
+func TestManifestTokenAuth(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+	reg.Username, reg.Password = "user", "secret"
+	reg.PushImage("private/app", "latest", ocispec.Platform{OS: "linux", Architecture: "amd64"})
+
+	subject := registry.NewClient()
+
+	_, err := subject.Manifest(context.Background(), parse(t, reg.Host()+"/private/app"), types.AuthConfig{Username: "user", Password: "wrong"})
+	assert.EqualError(t, err, "unauthorized: invalid credentials")
+
+	_, err = subject.Manifest(context.Background(), parse(t, reg.Host()+"/private/app"), types.AuthConfig{Username: "user", Password: "secret"})
+	assert.NoError(t, err)
+
+	// the token is cached for the same credentials only
+	_, err = subject.Manifest(context.Background(), parse(t, reg.Host()+"/private/app"), types.AuthConfig{Username: "user", Password: "secret"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reg.TokenRequests)
+	_, err = subject.Manifest(context.Background(), parse(t, reg.Host()+"/private/app"), types.AuthConfig{})
+	assert.EqualError(t, err, "unauthorized: invalid credentials")
+}
+
+func TestIsLocalhost(t *testing.T) {
+	for host, expected := range map[string]bool{
+		"localhost:5000": true,
+		"127.0.0.1:5000": true,
+		"[::1]:5000":     true,
+		"localhost":      true,
+		"docker.io":      false,
+		"10.0.0.1:5000":  false,
+	} {
+		assert.Equal(t, expected, registry.IsLocalhost(host), host)
+	}
+}
+
+func parse(t *testing.T, ref string) reference.Named {
+	t.Helper()
+	named, err := reference.ParseNormalizedNamed(ref)
+	assert.NoError(t, err)
+	return named
+}
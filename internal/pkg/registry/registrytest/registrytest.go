@@ -0,0 +1,203 @@
+// Package registrytest provides an in-memory registry for tests.
+package registrytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const token = "registrytest-token"
+
+// Registry serves manifests and blobs pushed to it.
+// If Username is set, clients must authenticate using token authentication.
+type Registry struct {
+	*httptest.Server
+	Username string
+	Password string
+	// TokenRequests counts the requested tokens.
+	TokenRequests int
+
+	mu        sync.Mutex
+	manifests map[string]map[string]manifest
+	blobs     map[digest.Digest][]byte
+	// referrers maps repositories and subject digests to manifests.
+	referrers map[string]map[digest.Digest][]ocispec.Descriptor
+}
+
+type manifest struct {
+	mediaType string
+	content   []byte
+}
+
+// New starts a registry. It must be closed after use.
+func New() *Registry {
+	r := &Registry{
+		manifests: map[string]map[string]manifest{},
+		blobs:     map[digest.Digest][]byte{},
+		referrers: map[string]map[digest.Digest][]ocispec.Descriptor{},
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// Host returns the host:port of the registry usable in image references.
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// PushBlob stores a blob.
+func (r *Registry) PushBlob(mediaType string, content []byte) ocispec.Descriptor {
+	d := digest.FromBytes(content)
+	r.mu.Lock()
+	r.blobs[d] = content
+	r.mu.Unlock()
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(content))}
+}
+
+// PushManifest stores a manifest under its digest and the given tag.
+func (r *Registry) PushManifest(repo, tag, mediaType string, content []byte) ocispec.Descriptor {
+	d := digest.FromBytes(content)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.manifests[repo] == nil {
+		r.manifests[repo] = map[string]manifest{}
+	}
+	r.manifests[repo][d.String()] = manifest{mediaType, content}
+	if tag != "" {
+		r.manifests[repo][tag] = manifest{mediaType, content}
+	}
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(content))}
+
+	var probe struct {
+		Subject *ocispec.Descriptor `json:"subject"`
+	}
+	if json.Unmarshal(content, &probe) == nil && probe.Subject != nil {
+		if r.referrers[repo] == nil {
+			r.referrers[repo] = map[digest.Digest][]ocispec.Descriptor{}
+		}
+		r.referrers[repo][probe.Subject.Digest] = append(r.referrers[repo][probe.Subject.Digest], desc)
+	}
+	return desc
+}
+
+// PushArtifact stores an artifact manifest with a single layer referring to
+// subject. The tag is optional.
+func (r *Registry) PushArtifact(repo, tag, artifactType string, subject ocispec.Descriptor, content []byte) ocispec.Descriptor {
+	mf := struct {
+		ocispec.Manifest
+		Subject *ocispec.Descriptor `json:"subject"`
+	}{
+		Manifest: ocispec.Manifest{
+			Config: r.PushBlob(artifactType, []byte("{}")),
+			Layers: []ocispec.Descriptor{r.PushBlob(artifactType, content)},
+		},
+		Subject: &ocispec.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+	}
+	mf.SchemaVersion = 2
+	b, _ := json.Marshal(mf)
+	return r.PushManifest(repo, tag, ocispec.MediaTypeImageManifest, b)
+}
+
+// PushImage stores an image manifest with a config for the platform and the
+// given layers.
+func (r *Registry) PushImage(repo, tag string, platform ocispec.Platform, layers ...[]byte) ocispec.Descriptor {
+	config, _ := json.Marshal(ocispec.Image{OS: platform.OS, Architecture: platform.Architecture})
+	mf := ocispec.Manifest{
+		Config: r.PushBlob(ocispec.MediaTypeImageConfig, config),
+		Layers: []ocispec.Descriptor{},
+	}
+	mf.SchemaVersion = 2
+	for _, l := range layers {
+		mf.Layers = append(mf.Layers, r.PushBlob(ocispec.MediaTypeImageLayerGzip, l))
+	}
+	content, _ := json.Marshal(mf)
+	d := r.PushManifest(repo, tag, ocispec.MediaTypeImageManifest, content)
+	d.Platform = &platform
+	return d
+}
+
+// PushIndex stores an image index referencing the given manifests.
+func (r *Registry) PushIndex(repo, tag string, manifests ...ocispec.Descriptor) ocispec.Descriptor {
+	idx := ocispec.Index{Manifests: manifests}
+	idx.SchemaVersion = 2
+	content, _ := json.Marshal(idx)
+	return r.PushManifest(repo, tag, ocispec.MediaTypeImageIndex, content)
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if r.Username != "" && req.Header.Get("Authorization") != "Bearer "+token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest"`, r.URL))
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case path == "":
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		r.mu.Lock()
+		m, ok := r.manifests[parts[0]][parts[1]]
+		r.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(m.content).String())
+		w.Write(m.content)
+	case strings.Contains(path, "/referrers/"):
+		parts := strings.SplitN(path, "/referrers/", 2)
+		r.mu.Lock()
+		idx := ocispec.Index{Manifests: r.referrers[parts[0]][digest.Digest(parts[1])]}
+		r.mu.Unlock()
+		idx.SchemaVersion = 2
+		if idx.Manifests == nil {
+			idx.Manifests = []ocispec.Descriptor{}
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+		json.NewEncoder(w).Encode(idx)
+	case strings.Contains(path, "/blobs/"):
+		parts := strings.SplitN(path, "/blobs/", 2)
+		r.mu.Lock()
+		b, ok := r.blobs[digest.Digest(parts[1])]
+		r.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown")
+			return
+		}
+		w.Write(b)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.TokenRequests++
+	r.mu.Unlock()
+	user, pass, _ := req.BasicAuth()
+	if user != r.Username || pass != r.Password {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"code":%q,"message":%q}]}`, code, message)
+}
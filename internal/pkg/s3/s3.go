@@ -0,0 +1,223 @@
+// Package s3 is a minimal client for multipart uploads to S3 compatible
+// object storage like MinIO. Objects are addressed path-style, i.e.
+// <endpoint>/<bucket>/<key>.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPartSize is the size of the uploaded parts if not configured. S3
+// requires parts but the last to be at least 5 MiB.
+const DefaultPartSize = 16 << 20
+
+type Client struct {
+	HTTPClient *http.Client
+	// Endpoint is the base URL of the object storage, e.g. https://minio:9000.
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PartSize defaults to DefaultPartSize.
+	PartSize int64
+}
+
+// Error is an error returned by the object storage.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("s3: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Object is an uploaded object.
+type Object struct {
+	Key  string
+	Size int64
+	// SHA256 is the hex encoded checksum of the object.
+	SHA256 string
+	ETag   string
+}
+
+// Upload uploads the content of r as the object key using a multipart upload.
+// Failed uploads are aborted.
+func (c *Client) Upload(ctx context.Context, key, contentType string, r io.Reader) (Object, error) {
+	obj := Object{Key: key}
+	uploadID, err := c.createUpload(ctx, key, contentType)
+	if err != nil {
+		return obj, err
+	}
+
+	obj, err = c.uploadParts(ctx, key, uploadID, r)
+	if err != nil {
+		// Use a fresh context, the request context may be canceled.
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		c.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, nil)
+		return obj, err
+	}
+	return obj, nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *Client) uploadParts(ctx context.Context, key, uploadID string, r io.Reader) (Object, error) {
+	obj := Object{Key: key}
+	partSize := c.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+
+	whole := sha256.New()
+	r = io.TeeReader(r, whole)
+	buf := new(bytes.Buffer)
+	var parts []completedPart
+	for n := 1; ; n++ {
+		buf.Reset()
+		size, err := io.Copy(buf, io.LimitReader(r, partSize))
+		if err != nil {
+			return obj, err
+		}
+		if size == 0 && n > 1 {
+			break
+		}
+		obj.Size += size
+
+		q := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+		resp, err := c.do(ctx, http.MethodPut, key, q, nil, buf.Bytes(), nil)
+		if err != nil {
+			return obj, fmt.Errorf("uploading part %d: %w", n, err)
+		}
+		parts = append(parts, completedPart{PartNumber: n, ETag: resp.Header.Get("ETag")})
+		if size < partSize {
+			break
+		}
+	}
+	obj.SHA256 = hex.EncodeToString(whole.Sum(nil))
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return obj, err
+	}
+	var result struct {
+		ETag string `xml:"ETag"`
+	}
+	if _, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body, &result); err != nil {
+		return obj, fmt.Errorf("completing upload: %w", err)
+	}
+	obj.ETag = result.ETag
+	return obj, nil
+}
+
+func (c *Client) createUpload(ctx context.Context, key, contentType string) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if _, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, &result); err != nil {
+		return "", fmt.Errorf("creating upload: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("creating upload: no upload ID returned")
+	}
+	return result.UploadID, nil
+}
+
+// ObjectURL returns the unsigned URL of the object.
+func (c *Client) ObjectURL(key string) *url.URL {
+	u := *c.Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.Bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = ""
+	return &u
+}
+
+// Presign returns a URL to download the object without credentials until it
+// expires. S3 allows at most seven days.
+func (c *Client) Presign(key string, expires time.Duration) *url.URL {
+	return c.signer().presign(c.ObjectURL(key), expires, time.Now())
+}
+
+// do sends a signed request. If result is set, the XML response is decoded
+// into it.
+func (c *Client) do(ctx context.Context, method, key string, q url.Values, header http.Header, body []byte, result interface{}) (*http.Response, error) {
+	u := c.ObjectURL(key)
+	u.RawQuery = canonicalQuery(q)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+	c.signer().sign(req, hexSHA256(body), time.Now())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	// CompleteMultipartUpload may fail after sending 200 OK.
+	if resp.StatusCode >= 300 || bytes.Contains(content, []byte("<Error>")) {
+		e := &Error{StatusCode: resp.StatusCode}
+		xml.Unmarshal(content, e)
+		return nil, e
+	}
+	if result != nil {
+		if err := xml.Unmarshal(content, result); err != nil {
+			return nil, fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) signer() signer {
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return signer{accessKey: c.AccessKey, secretKey: c.SecretKey, region: region}
+}
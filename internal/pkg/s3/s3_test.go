@@ -0,0 +1,87 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/s3"
+	"github.com/bastjan/saveomat/internal/pkg/s3/s3test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpload(t *testing.T) {
+	srv := s3test.New()
+	defer srv.Close()
+	subject := client(srv)
+
+	for _, content := range []string{"", "0123", "0123456789"} {
+		obj, err := subject.Upload(context.Background(), "bundles/images.tar", "application/x-tar", strings.NewReader(content))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(content)), obj.Size)
+		sum := sha256.Sum256([]byte(content))
+		assert.Equal(t, hex.EncodeToString(sum[:]), obj.SHA256)
+		assert.NotEmpty(t, obj.ETag)
+
+		stored, ok := srv.Object("saveomat", "bundles/images.tar")
+		assert.True(t, ok)
+		assert.Equal(t, content, string(stored))
+	}
+
+	resp, err := http.Get(subject.Presign("bundles/images.tar", time.Hour).String())
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0123456789", string(b))
+}
+
+func TestUploadAborts(t *testing.T) {
+	srv := s3test.New()
+	defer srv.Close()
+	srv.MinPartSize = 5
+	subject := client(srv)
+
+	_, err := subject.Upload(context.Background(), "images.tar", "", bytes.NewReader([]byte("0123456789")))
+	var s3Err *s3.Error
+	assert.True(t, errors.As(err, &s3Err))
+	assert.EqualError(t, err, "completing upload: s3: 400 Bad Request: EntityTooSmall: part 1 is too small")
+	assert.Equal(t, 1, srv.Aborted)
+	_, ok := srv.Object("saveomat", "images.tar")
+	assert.False(t, ok)
+
+	subject.AccessKey = "wrong"
+	_, err = subject.Upload(context.Background(), "images.tar", "", bytes.NewReader(nil))
+	assert.EqualError(t, err, "creating upload: s3: 403 Forbidden: InvalidAccessKeyId: unknown access key")
+}
+
+func TestObjectURL(t *testing.T) {
+	subject := &s3.Client{Endpoint: mustParse(t, "https://minio.example.com/s3/"), Bucket: "bundles"}
+	assert.Equal(t, "https://minio.example.com/s3/bundles/2024/images%2B1.tar", subject.ObjectURL("2024/images+1.tar").String())
+}
+
+func client(srv *s3test.Server) *s3.Client {
+	return &s3.Client{
+		Endpoint:  srv.Endpoint(),
+		Bucket:    "saveomat",
+		AccessKey: s3test.AccessKey,
+		SecretKey: s3test.SecretKey,
+		PartSize:  4,
+	}
+}
+
+func mustParse(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	assert.NoError(t, err)
+	return u
+}
@@ -0,0 +1,191 @@
+// Package s3test provides an in-memory S3 compatible object storage for
+// tests.
+package s3test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	AccessKey = "s3test-access-key"
+	SecretKey = "s3test-secret-key"
+)
+
+// Server stores objects uploaded using multipart uploads. It checks that
+// requests are signed by AccessKey and that payload hashes match, but does
+// not verify signatures.
+type Server struct {
+	*httptest.Server
+	// MinPartSize is the minimum size of all parts but the last.
+	MinPartSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]*upload
+	nextID  int
+	// Aborted counts aborted uploads.
+	Aborted int
+}
+
+type upload struct {
+	path  string
+	parts map[int][]byte
+}
+
+// New starts a server. It must be closed after use.
+func New() *Server {
+	s := &Server{
+		objects: map[string][]byte{},
+		uploads: map[string]*upload{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Endpoint returns the URL of the server.
+func (s *Server) Endpoint() *url.URL {
+	u, _ := url.Parse(s.URL)
+	return u
+}
+
+// Object returns the object stored at bucket/key.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.objects["/"+bucket+"/"+key]
+	return b, ok
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.Method == http.MethodGet && q.Get("X-Amz-Signature") != "" {
+		s.servePresigned(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+AccessKey+"/") {
+		writeError(w, http.StatusForbidden, "InvalidAccessKeyId", "unknown access key")
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "payload hash mismatch")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, create := q["uploads"]
+	switch {
+	case r.Method == http.MethodPost && create:
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &upload{path: r.URL.Path, parts: map[int][]byte{}}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: id})
+	case r.Method == http.MethodPut && q.Get("uploadId") != "":
+		u, ok := s.uploads[q.Get("uploadId")]
+		n, err := strconv.Atoi(q.Get("partNumber"))
+		if !ok || err != nil {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", "unknown upload")
+			return
+		}
+		u.parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(body)))
+	case r.Method == http.MethodPost && q.Get("uploadId") != "":
+		s.complete(w, q.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && q.Get("uploadId") != "":
+		delete(s.uploads, q.Get("uploadId"))
+		s.Aborted++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" is not supported")
+	}
+}
+
+func (s *Server) complete(w http.ResponseWriter, id string, body []byte) {
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "unknown upload")
+		return
+	}
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil || len(req.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "invalid part list")
+		return
+	}
+	sort.Slice(req.Parts, func(i, j int) bool { return req.Parts[i].PartNumber < req.Parts[j].PartNumber })
+
+	var content []byte
+	for i, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if !ok || p.ETag != fmt.Sprintf(`"%x"`, sha256.Sum256(part)) {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d not found", p.PartNumber))
+			return
+		}
+		if i < len(req.Parts)-1 && len(part) < s.MinPartSize {
+			writeError(w, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("part %d is too small", p.PartNumber))
+			return
+		}
+		content = append(content, part...)
+	}
+	s.objects[u.path] = content
+	delete(s.uploads, id)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: fmt.Sprintf(`"%x-%d"`, sha256.Sum256(content), len(req.Parts))})
+}
+
+func (s *Server) servePresigned(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Query().Get("X-Amz-Credential"), AccessKey+"/") {
+		writeError(w, http.StatusForbidden, "InvalidAccessKeyId", "unknown access key")
+		return
+	}
+	s.mu.Lock()
+	b, ok := s.objects[r.URL.Path]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "object not found")
+		return
+	}
+	w.Write(b)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
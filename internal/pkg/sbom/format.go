@@ -0,0 +1,234 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Supported SBOM formats.
+const (
+	FormatSPDX      = "spdx"
+	FormatCycloneDX = "cyclonedx"
+)
+
+// ValidateFormat checks that the format is supported.
+func ValidateFormat(format string) error {
+	if format != FormatSPDX && format != FormatCycloneDX {
+		return fmt.Errorf("unsupported SBOM format %q, expected %q or %q", format, FormatSPDX, FormatCycloneDX)
+	}
+	return nil
+}
+
+// FileName returns the archive path of the SBOM of the image, e.g.
+// "sboms/docker.io_library_busybox_latest.spdx.json".
+func FileName(img Image, format string) string {
+	ext := ".spdx.json"
+	if format == FormatCycloneDX {
+		ext = ".cdx.json"
+	}
+	return "sboms/" + BaseName(img) + ext
+}
+
+// BaseName returns the name of the image and its platform usable as a file
+// name, e.g. "docker.io_library_busybox_latest_linux_amd64".
+func BaseName(img Image) string {
+	name := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(img.Name)
+	if img.Platform != "" {
+		name += "_" + strings.Replace(img.Platform, "/", "_", -1)
+	}
+	return name
+}
+
+// Encode writes the SBOM of the image in the given format.
+func Encode(img Image, format string, created time.Time) ([]byte, error) {
+	switch format {
+	case FormatSPDX:
+		return json.MarshalIndent(spdxDocument(img, created), "", "  ")
+	case FormatCycloneDX:
+		return json.MarshalIndent(cycloneDXDocument(img, created), "", "  ")
+	}
+	return nil, ValidateFormat(format)
+}
+
+// PURL returns the package URL of the package.
+// See https://github.com/package-url/purl-spec
+func PURL(p Package, os OS) string {
+	namespace := ""
+	switch p.Type {
+	case TypeDeb:
+		namespace = "debian"
+	case TypeApk:
+		namespace = "alpine"
+	case TypeRpm:
+		namespace = os.ID
+	}
+	if namespace != "" && os.ID != "" {
+		namespace = os.ID
+	}
+
+	segments := []string{}
+	if namespace != "" {
+		segments = append(segments, namespace)
+	}
+	segments = append(segments, strings.Split(p.Name, "/")...)
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	purl := "pkg:" + p.Type + "/" + strings.Join(segments, "/")
+	// The epoch of RPM versions is a qualifier.
+	version, qualifiers := p.Version, url.Values{}
+	if i := strings.IndexByte(version, ':'); i >= 0 && p.Type == TypeRpm {
+		version = version[i+1:]
+		qualifiers.Set("epoch", p.Version[:i])
+	}
+	if version != "" {
+		purl += "@" + url.PathEscape(version)
+	}
+	if p.Arch != "" {
+		qualifiers.Set("arch", p.Arch)
+	}
+	if len(qualifiers) > 0 {
+		purl += "?" + qualifiers.Encode()
+	}
+	return purl
+}
+
+func spdxDocument(img Image, created time.Time) interface{} {
+	type externalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+	type pkg struct {
+		SPDXID           string        `json:"SPDXID"`
+		Name             string        `json:"name"`
+		Version          string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		SourceInfo       string        `json:"sourceInfo,omitempty"`
+		ExternalRefs     []externalRef `json:"externalRefs,omitempty"`
+		PrimaryPurpose   string        `json:"primaryPackagePurpose,omitempty"`
+	}
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+
+	pkgs := []pkg{{
+		SPDXID:           "SPDXRef-Image",
+		Name:             img.Name,
+		DownloadLocation: "NOASSERTION",
+		PrimaryPurpose:   "CONTAINER",
+	}}
+	rels := []relationship{{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: "SPDXRef-Image"}}
+	for i, p := range img.Packages {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		pkgs = append(pkgs, pkg{
+			SPDXID:           id,
+			Name:             p.Name,
+			Version:          p.Version,
+			DownloadLocation: "NOASSERTION",
+			SourceInfo:       "found in " + p.Location,
+			ExternalRefs:     []externalRef{{Category: "PACKAGE-MANAGER", Type: "purl", Locator: PURL(p, img.OS)}},
+		})
+		rels = append(rels, relationship{Element: "SPDXRef-Image", Type: "CONTAINS", Related: id})
+	}
+
+	return struct {
+		SPDXVersion       string         `json:"spdxVersion"`
+		DataLicense       string         `json:"dataLicense"`
+		SPDXID            string         `json:"SPDXID"`
+		Name              string         `json:"name"`
+		DocumentNamespace string         `json:"documentNamespace"`
+		CreationInfo      interface{}    `json:"creationInfo"`
+		Comment           string         `json:"comment,omitempty"`
+		Packages          []pkg          `json:"packages"`
+		Relationships     []relationship `json:"relationships"`
+	}{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              img.Name,
+		DocumentNamespace: "https://saveomat.invalid/spdx/" + url.PathEscape(img.Name) + "-" + randomUUID(),
+		CreationInfo: map[string]interface{}{
+			"created":  created.UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: saveomat"},
+		},
+		Comment:       strings.Join(img.Errors, "\n"),
+		Packages:      pkgs,
+		Relationships: rels,
+	}
+}
+
+func cycloneDXDocument(img Image, created time.Time) interface{} {
+	type property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type component struct {
+		Type       string     `json:"type"`
+		BOMRef     string     `json:"bom-ref,omitempty"`
+		Name       string     `json:"name"`
+		Version    string     `json:"version,omitempty"`
+		PURL       string     `json:"purl,omitempty"`
+		Properties []property `json:"properties,omitempty"`
+	}
+
+	components := []component{}
+	if img.OS.ID != "" {
+		components = append(components, component{Type: "operating-system", Name: img.OS.ID, Version: img.OS.VersionID})
+	}
+	for i, p := range img.Packages {
+		components = append(components, component{
+			Type:       "library",
+			BOMRef:     fmt.Sprintf("pkg-%d", i+1),
+			Name:       p.Name,
+			Version:    p.Version,
+			PURL:       PURL(p, img.OS),
+			Properties: []property{{Name: "saveomat:location", Value: p.Location}},
+		})
+	}
+
+	errs := []property{}
+	for _, e := range img.Errors {
+		errs = append(errs, property{Name: "saveomat:error", Value: e})
+	}
+	metadata := map[string]interface{}{
+		"timestamp": created.UTC().Format(time.RFC3339),
+		"tools":     []map[string]string{{"name": "saveomat"}},
+		"component": component{Type: "container", BOMRef: "image", Name: img.Name},
+	}
+	if len(errs) > 0 {
+		metadata["properties"] = errs
+	}
+
+	return struct {
+		BOMFormat    string                 `json:"bomFormat"`
+		SpecVersion  string                 `json:"specVersion"`
+		SerialNumber string                 `json:"serialNumber"`
+		Version      int                    `json:"version"`
+		Metadata     map[string]interface{} `json:"metadata"`
+		Components   []component            `json:"components"`
+	}{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + randomUUID(),
+		Version:      1,
+		Metadata:     metadata,
+		Components:   components,
+	}
+}
+
+func randomUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
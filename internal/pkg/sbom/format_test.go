@@ -0,0 +1,110 @@
+package sbom
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testImage = Image{
+	Name: "docker.io/library/debian:12",
+	OS:   OS{ID: "debian", VersionID: "12"},
+	Packages: []Package{
+		{Type: TypeDeb, Name: "bash", Version: "5.2.15-2+b2", Arch: "amd64", Location: "/var/lib/dpkg/status"},
+		{Type: TypeGolang, Name: "golang.org/x/sys", Version: "v0.1.0", Location: "/usr/bin/app"},
+	},
+	Errors: []string{"RPM database /var/lib/rpm/rpmdb.sqlite could not be read: invalid SQLite database"},
+}
+
+func TestPURL(t *testing.T) {
+	for _, tc := range []struct {
+		pkg      Package
+		os       OS
+		expected string
+	}{
+		{Package{Type: TypeDeb, Name: "bash", Version: "5.2.15-2+b2", Arch: "amd64"}, OS{ID: "debian"}, "pkg:deb/debian/bash@5.2.15-2+b2?arch=amd64"},
+		{Package{Type: TypeDeb, Name: "libc6", Version: "2.35-0ubuntu3"}, OS{ID: "ubuntu"}, "pkg:deb/ubuntu/libc6@2.35-0ubuntu3"},
+		{Package{Type: TypeDeb, Name: "vim", Version: "2:9.0.1378-2"}, OS{}, "pkg:deb/debian/vim@2:9.0.1378-2"},
+		{Package{Type: TypeApk, Name: "musl", Version: "1.2.4-r2", Arch: "x86_64"}, OS{ID: "alpine"}, "pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64"},
+		{Package{Type: TypeRpm, Name: "bash", Version: "5.1.8-6.el9", Arch: "x86_64"}, OS{ID: "rhel"}, "pkg:rpm/rhel/bash@5.1.8-6.el9?arch=x86_64"},
+		{Package{Type: TypeRpm, Name: "openssl-libs", Version: "1:3.0.7-24.el9", Arch: "x86_64"}, OS{ID: "rocky"}, "pkg:rpm/rocky/openssl-libs@3.0.7-24.el9?arch=x86_64&epoch=1"},
+		{Package{Type: TypeGolang, Name: "github.com/foo/bar", Version: "v1.0.0"}, OS{ID: "debian"}, "pkg:golang/github.com/foo/bar@v1.0.0"},
+	} {
+		assert.Equal(t, tc.expected, PURL(tc.pkg, tc.os))
+	}
+}
+
+func TestFileName(t *testing.T) {
+	assert.Equal(t, "sboms/docker.io_library_debian_12.spdx.json", FileName(testImage, FormatSPDX))
+	assert.Equal(t, "sboms/docker.io_library_debian_12_linux_arm64_v8.cdx.json", FileName(Image{Name: testImage.Name, Platform: "linux/arm64/v8"}, FormatCycloneDX))
+}
+
+func TestEncodeSPDX(t *testing.T) {
+	b, err := Encode(testImage, FormatSPDX, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	assert.NoError(t, err)
+
+	var doc struct {
+		SPDXVersion  string `json:"spdxVersion"`
+		Name         string `json:"name"`
+		Comment      string `json:"comment"`
+		CreationInfo struct {
+			Created string `json:"created"`
+		} `json:"creationInfo"`
+		Packages []struct {
+			SPDXID       string `json:"SPDXID"`
+			Name         string `json:"name"`
+			ExternalRefs []struct {
+				Locator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+		Relationships []struct {
+			Type string `json:"relationshipType"`
+		} `json:"relationships"`
+	}
+	assert.NoError(t, json.Unmarshal(b, &doc))
+	assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+	assert.Equal(t, testImage.Name, doc.Name)
+	assert.Equal(t, testImage.Errors[0], doc.Comment)
+	assert.Equal(t, "2024-01-02T03:04:05Z", doc.CreationInfo.Created)
+	if assert.Len(t, doc.Packages, 3) {
+		assert.Equal(t, "SPDXRef-Image", doc.Packages[0].SPDXID)
+		assert.Equal(t, "pkg:deb/debian/bash@5.2.15-2+b2?arch=amd64", doc.Packages[1].ExternalRefs[0].Locator)
+		assert.Equal(t, "pkg:golang/golang.org/x/sys@v0.1.0", doc.Packages[2].ExternalRefs[0].Locator)
+	}
+	assert.Len(t, doc.Relationships, 3)
+}
+
+func TestEncodeCycloneDX(t *testing.T) {
+	b, err := Encode(testImage, FormatCycloneDX, time.Now())
+	assert.NoError(t, err)
+
+	var doc struct {
+		BOMFormat    string `json:"bomFormat"`
+		SpecVersion  string `json:"specVersion"`
+		SerialNumber string `json:"serialNumber"`
+		Metadata     struct {
+			Component struct {
+				Name string `json:"name"`
+			} `json:"component"`
+		} `json:"metadata"`
+		Components []struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+			PURL string `json:"purl"`
+		} `json:"components"`
+	}
+	assert.NoError(t, json.Unmarshal(b, &doc))
+	assert.Equal(t, "CycloneDX", doc.BOMFormat)
+	assert.Equal(t, "1.5", doc.SpecVersion)
+	assert.Regexp(t, `^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, doc.SerialNumber)
+	assert.Equal(t, testImage.Name, doc.Metadata.Component.Name)
+	if assert.Len(t, doc.Components, 3) {
+		assert.Equal(t, "operating-system", doc.Components[0].Type)
+		assert.Equal(t, "pkg:deb/debian/bash@5.2.15-2+b2?arch=amd64", doc.Components[1].PURL)
+	}
+
+	_, err = Encode(testImage, "swid", time.Now())
+	assert.EqualError(t, err, `unsupported SBOM format "swid", expected "spdx" or "cyclonedx"`)
+}
@@ -0,0 +1,124 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// buildInfoMagic starts the build info blob the Go linker embeds in binaries.
+var buildInfoMagic = []byte("\xff Go buildinf:")
+
+const (
+	// buildInfoHeaderSize is the size of the blob header, followed by the
+	// inline version and module strings since Go 1.18.
+	buildInfoHeaderSize = 32
+	// flagsVersionInl marks blobs with inline strings.
+	flagsVersionInl = 0x2
+	// maxBuildInfoSize limits the amount of data read after the magic.
+	maxBuildInfoSize = 1 << 20
+)
+
+// executableMagics identify ELF, PE and Mach-O binaries.
+var executableMagics = [][]byte{
+	[]byte("\x7fELF"),
+	[]byte("MZ"),
+	[]byte("\xfe\xed\xfa\xce"), []byte("\xfe\xed\xfa\xcf"),
+	[]byte("\xce\xfa\xed\xfe"), []byte("\xcf\xfa\xed\xfe"),
+}
+
+func isExecutable(header []byte) bool {
+	for _, m := range executableMagics {
+		if bytes.HasPrefix(header, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanGoBinary reads the build info of a Go binary built with Go 1.18 or
+// later. It returns the modules the binary was built from or nil if r is
+// not such a binary.
+func scanGoBinary(r io.Reader, location string) ([]Package, error) {
+	var window []byte
+	chunk := make([]byte, 64<<10)
+	for {
+		n, err := r.Read(chunk)
+		window = append(window, chunk[:n]...)
+
+		for {
+			i := bytes.Index(window, buildInfoMagic)
+			if i < 0 {
+				break
+			}
+			rest, rerr := ioutil.ReadAll(io.LimitReader(r, maxBuildInfoSize))
+			if rerr != nil {
+				return nil, rerr
+			}
+			window = append(window[i:], rest...)
+			if pkgs, ok := parseBuildInfo(window, location); ok {
+				return pkgs, nil
+			}
+			// The magic also appears as data in binaries reading build
+			// info, continue after it.
+			window = window[len(buildInfoMagic):]
+		}
+
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if keep := len(buildInfoMagic) - 1; len(window) > keep {
+			window = append(window[:0], window[len(window)-keep:]...)
+		}
+	}
+}
+
+func parseBuildInfo(data []byte, location string) ([]Package, bool) {
+	if len(data) < buildInfoHeaderSize || data[15]&flagsVersionInl == 0 {
+		return nil, false
+	}
+	data = data[buildInfoHeaderSize:]
+	goVersion, data, ok := decodeString(data)
+	if !ok || !strings.HasPrefix(goVersion, "go") {
+		return nil, false
+	}
+	mod, _, ok := decodeString(data)
+	if !ok {
+		return nil, false
+	}
+	// The module info is framed by 16 byte sentinels.
+	if len(mod) >= 33 && mod[len(mod)-17] == '\n' {
+		mod = mod[16 : len(mod)-16]
+	}
+
+	pkgs := []Package{{Type: TypeGolang, Name: "stdlib", Version: goVersion, Location: location}}
+	for _, line := range strings.Split(mod, "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) < 3 {
+			continue
+		}
+		switch f[0] {
+		case "mod", "dep":
+			pkgs = append(pkgs, Package{Type: TypeGolang, Name: f[1], Version: f[2], Location: location})
+		case "=>":
+			// Replacements apply to the previous module.
+			if len(pkgs) > 1 && f[2] != "" {
+				pkgs[len(pkgs)-1].Version = f[2]
+			}
+		}
+	}
+	return pkgs, true
+}
+
+func decodeString(data []byte) (string, []byte, bool) {
+	l, n := binary.Uvarint(data)
+	if n <= 0 || l > uint64(len(data)-n) {
+		return "", nil, false
+	}
+	return string(data[n : n+int(l)]), data[n+int(l):], true
+}
@@ -0,0 +1,69 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanGoBinary(t *testing.T) {
+	modinfo := "path\texample.com/cmd/app\n" +
+		"mod\texample.com\tv1.2.3\th1:abc=\n" +
+		"dep\tgolang.org/x/sys\tv0.1.0\th1:def=\n" +
+		"dep\tgithub.com/foo/bar\tv1.0.0\n" +
+		"=>\tgithub.com/fork/bar\tv1.0.1\th1:ghi=\n" +
+		"build\t-compiler=gc\n"
+
+	// The first magic is data of a binary reading build info.
+	bin := append([]byte("\x7fELF padding"), buildInfoMagic...)
+	bin = append(bin, bytes.Repeat([]byte{0}, 100<<10)...)
+	bin = append(bin, goBuildInfo("go1.21.5", modinfo)...)
+	bin = append(bin, "trailing data"...)
+
+	pkgs, err := scanGoBinary(bytes.NewReader(bin), "/app")
+	assert.NoError(t, err)
+	assert.Equal(t, []Package{
+		{Type: TypeGolang, Name: "stdlib", Version: "go1.21.5", Location: "/app"},
+		{Type: TypeGolang, Name: "example.com", Version: "v1.2.3", Location: "/app"},
+		{Type: TypeGolang, Name: "golang.org/x/sys", Version: "v0.1.0", Location: "/app"},
+		{Type: TypeGolang, Name: "github.com/foo/bar", Version: "v1.0.1", Location: "/app"},
+	}, pkgs)
+
+	pkgs, err = scanGoBinary(bytes.NewReader([]byte("\x7fELF not go")), "/app")
+	assert.NoError(t, err)
+	assert.Nil(t, pkgs)
+}
+
+func TestScanGoBinaryTestExecutable(t *testing.T) {
+	f, err := os.Open(os.Args[0])
+	assert.NoError(t, err)
+	defer f.Close()
+
+	pkgs, err := scanGoBinary(f, "/test")
+	assert.NoError(t, err)
+
+	names := []string{}
+	for _, p := range pkgs {
+		names = append(names, p.Name)
+	}
+	assert.Contains(t, names, "stdlib")
+	assert.Contains(t, names, "github.com/stretchr/testify")
+}
+
+// goBuildInfo returns a build info blob as written by Go 1.18 and later.
+func goBuildInfo(version, modinfo string) []byte {
+	const sentinel = "0123456789abcdef"
+	b := append([]byte{}, buildInfoMagic...)
+	b = append(b, 8, flagsVersionInl)
+	b = append(b, make([]byte, buildInfoHeaderSize-len(b))...)
+	for _, s := range []string{version, sentinel + modinfo + sentinel} {
+		var l [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(l[:], uint64(len(s)))
+		b = append(b, l[:n]...)
+		b = append(b, s...)
+	}
+	return b
+}
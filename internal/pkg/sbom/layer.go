@@ -0,0 +1,100 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// maxBinarySize limits the size of binaries scanned for Go build info.
+const maxBinarySize = 512 << 20
+
+// layer are the results of scanning a layer.
+type layer struct {
+	files     map[string]*file
+	whiteouts []string
+	opaque    []string
+	// err is set if the layer could not be scanned.
+	err string
+}
+
+// file is a file of interest in a layer.
+type file struct {
+	packages []Package
+	os       *OS
+	// err is set if the file is a package database that could not be read.
+	err string
+}
+
+func scanLayer(r io.Reader) (*layer, error) {
+	l := &layer{files: map[string]*file{}}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return l, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		if base == ".wh..wh..opq" {
+			l.opaque = append(l.opaque, dir)
+			continue
+		}
+		if strings.HasPrefix(base, ".wh.") {
+			l.whiteouts = append(l.whiteouts, path.Join(dir, strings.TrimPrefix(base, ".wh.")))
+			continue
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		f, err := scanFile(hdr, name, tr)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			l.files[name] = f
+		}
+	}
+}
+
+// scanFile returns the results for files of interest and nil for all others.
+func scanFile(hdr *tar.Header, name string, r io.Reader) (*file, error) {
+	location := "/" + name
+	switch {
+	case name == "var/lib/dpkg/status" || strings.HasPrefix(name, "var/lib/dpkg/status.d/"):
+		pkgs, err := parseDpkgStatus(r, location)
+		return &file{packages: pkgs}, err
+	case name == "lib/apk/db/installed":
+		pkgs, err := parseApkInstalled(r, location)
+		return &file{packages: pkgs}, err
+	case name == "etc/os-release" || name == "usr/lib/os-release":
+		os, err := parseOSRelease(r)
+		return &file{os: &os}, err
+	case rpmDatabases[name]:
+		pkgs, err := parseRPMDatabase(r, location)
+		if err != nil {
+			return &file{err: fmt.Sprintf("RPM database %s could not be read: %v", location, err)}, nil
+		}
+		return &file{packages: pkgs}, nil
+	case hdr.Mode&0111 != 0 && hdr.Size <= maxBinarySize:
+		br := bufio.NewReader(r)
+		head, _ := br.Peek(4)
+		if !isExecutable(head) {
+			return nil, nil
+		}
+		pkgs, err := scanGoBinary(br, location)
+		// Binaries without build info override Go binaries of lower
+		// layers.
+		return &file{packages: pkgs}, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,131 @@
+package sbom
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Package types, used as purl types.
+const (
+	TypeDeb    = "deb"
+	TypeApk    = "apk"
+	TypeGolang = "golang"
+	TypeRpm    = "rpm"
+)
+
+// Package is a software package found in an image.
+type Package struct {
+	Type    string
+	Name    string
+	Version string
+	Arch    string
+	// Source is the name of the source package, if it differs from Name.
+	Source string
+	// Location is the path of the file the package was found in.
+	Location string
+}
+
+// OS describes the distribution of an image as found in os-release.
+type OS struct {
+	ID         string
+	VersionID  string
+	PrettyName string
+}
+
+// parseDpkgStatus parses the dpkg status database. Only installed packages
+// are returned.
+func parseDpkgStatus(r io.Reader, location string) ([]Package, error) {
+	pkgs := []Package{}
+	err := parseStanzas(r, ':', func(fields map[string]string) {
+		if fields["Package"] == "" {
+			return
+		}
+		if status, ok := fields["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			return
+		}
+		pkgs = append(pkgs, Package{
+			Type:     TypeDeb,
+			Name:     fields["Package"],
+			Version:  fields["Version"],
+			Arch:     fields["Architecture"],
+			Source:   sourceName(fields["Source"], fields["Package"]),
+			Location: location,
+		})
+	})
+	return pkgs, err
+}
+
+// parseApkInstalled parses the apk database lib/apk/db/installed.
+func parseApkInstalled(r io.Reader, location string) ([]Package, error) {
+	pkgs := []Package{}
+	err := parseStanzas(r, ':', func(fields map[string]string) {
+		if fields["P"] == "" {
+			return
+		}
+		pkgs = append(pkgs, Package{
+			Type:     TypeApk,
+			Name:     fields["P"],
+			Version:  fields["V"],
+			Arch:     fields["A"],
+			Source:   sourceName(fields["o"], fields["P"]),
+			Location: location,
+		})
+	})
+	return pkgs, err
+}
+
+// sourceName strips the version from a dpkg "Source" field like
+// "glibc (2.36-9)". It returns "" if the source equals the package name.
+func sourceName(source, name string) string {
+	source = strings.TrimSpace(strings.SplitN(source, " ", 2)[0])
+	if source == name {
+		return ""
+	}
+	return source
+}
+
+// parseStanzas calls fn for every block of "key<sep>value" lines. Blocks are
+// separated by empty lines, lines starting with whitespace continue the
+// previous value and are ignored.
+func parseStanzas(r io.Reader, sep byte, fn func(map[string]string)) error {
+	fields := map[string]string{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(fields) > 0 {
+				fn(fields)
+				fields = map[string]string{}
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if i := strings.IndexByte(line, sep); i > 0 {
+			fields[line[:i]] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if len(fields) > 0 {
+		fn(fields)
+	}
+	return sc.Err()
+}
+
+// parseOSRelease parses an os-release file.
+func parseOSRelease(r io.Reader) (OS, error) {
+	fields := map[string]string{}
+	err := parseStanzas(r, '=', func(f map[string]string) {
+		for k, v := range f {
+			fields[k] = v
+		}
+	})
+	unquote := func(s string) string { return strings.Trim(s, `"'`) }
+	return OS{
+		ID:         unquote(fields["ID"]),
+		VersionID:  unquote(fields["VERSION_ID"]),
+		PrettyName: unquote(fields["PRETTY_NAME"]),
+	}, err
+}
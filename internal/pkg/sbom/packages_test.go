@@ -0,0 +1,83 @@
+package sbom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const dpkgStatus = `Package: bash
+Status: install ok installed
+Priority: required
+Architecture: amd64
+Version: 5.2.15-2+b2
+Description: GNU Bourne Again SHell
+ Bash is an sh-compatible command language interpreter.
+ .
+ Package: not-a-package
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: libc6
+Status: install ok installed
+Architecture: amd64
+Source: glibc (2.36-9+deb12u4)
+Version: 2.36-9+deb12u4
+
+Package: tzdata
+Status: install ok installed
+Architecture: all
+Version: 2024a-0+deb12u1
+`
+
+const apkInstalled = `C:Q1abc=
+P:musl
+V:1.2.4-r2
+A:x86_64
+o:musl
+
+C:Q1def=
+P:ssl_client
+V:3.1.4-r5
+A:x86_64
+o:openssl
+
+C:Q1ghi=
+P:busybox
+V:1.36.1-r5
+A:x86_64
+`
+
+func TestParseDpkgStatus(t *testing.T) {
+	pkgs, err := parseDpkgStatus(strings.NewReader(dpkgStatus), "/var/lib/dpkg/status")
+	assert.NoError(t, err)
+	assert.Equal(t, []Package{
+		{Type: TypeDeb, Name: "bash", Version: "5.2.15-2+b2", Arch: "amd64", Location: "/var/lib/dpkg/status"},
+		{Type: TypeDeb, Name: "libc6", Version: "2.36-9+deb12u4", Arch: "amd64", Source: "glibc", Location: "/var/lib/dpkg/status"},
+		{Type: TypeDeb, Name: "tzdata", Version: "2024a-0+deb12u1", Arch: "all", Location: "/var/lib/dpkg/status"},
+	}, pkgs)
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	pkgs, err := parseApkInstalled(strings.NewReader(apkInstalled), "/lib/apk/db/installed")
+	assert.NoError(t, err)
+	assert.Equal(t, []Package{
+		{Type: TypeApk, Name: "musl", Version: "1.2.4-r2", Arch: "x86_64", Location: "/lib/apk/db/installed"},
+		{Type: TypeApk, Name: "ssl_client", Version: "3.1.4-r5", Arch: "x86_64", Source: "openssl", Location: "/lib/apk/db/installed"},
+		{Type: TypeApk, Name: "busybox", Version: "1.36.1-r5", Arch: "x86_64", Location: "/lib/apk/db/installed"},
+	}, pkgs)
+}
+
+func TestParseOSRelease(t *testing.T) {
+	os, err := parseOSRelease(strings.NewReader(`PRETTY_NAME="Debian GNU/Linux 12 (bookworm)"
+NAME="Debian GNU/Linux"
+VERSION_ID="12"
+ID=debian
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, OS{ID: "debian", VersionID: "12", PrettyName: "Debian GNU/Linux 12 (bookworm)"}, os)
+}
@@ -0,0 +1,298 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// maxRPMDatabaseSize limits the size of RPM databases, which are read into
+// memory.
+const maxRPMDatabaseSize = 512 << 20
+
+// rpmDatabases are the known locations of RPM databases.
+var rpmDatabases = map[string]bool{
+	"var/lib/rpm/Packages":              true,
+	"var/lib/rpm/Packages.db":           true,
+	"var/lib/rpm/rpmdb.sqlite":          true,
+	"usr/lib/sysimage/rpm/Packages.db":  true,
+	"usr/lib/sysimage/rpm/rpmdb.sqlite": true,
+}
+
+// Tags and types of RPM headers, see rpmtag.h.
+const (
+	rpmTagName      = 1000
+	rpmTagVersion   = 1001
+	rpmTagRelease   = 1002
+	rpmTagEpoch     = 1003
+	rpmTagArch      = 1022
+	rpmTagSourceRPM = 1044
+
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeI18NString  = 9
+	rpmHeaderIndexSize = 16
+)
+
+var errInvalidRPMHeader = errors.New("invalid package header")
+
+// parseRPMDatabase reads the package headers of an RPM database. The SQLite
+// format of RPM 4.16 and later, the NDB format used by SUSE and the Berkeley
+// DB hash format of older releases are supported.
+func parseRPMDatabase(r io.Reader, location string) ([]Package, error) {
+	db, err := ioutil.ReadAll(io.LimitReader(r, maxRPMDatabaseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(db) > maxRPMDatabaseSize {
+		return nil, fmt.Errorf("database exceeds %d bytes", maxRPMDatabaseSize)
+	}
+
+	var headers [][]byte
+	switch {
+	case bytes.HasPrefix(db, []byte(sqliteMagic)):
+		headers, err = sqliteColumn(db, "Packages", 1)
+	case bytes.HasPrefix(db, []byte(ndbMagic)):
+		headers, err = ndbBlobs(db)
+	default:
+		headers, err = bdbHashValues(db)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := []Package{}
+	for _, h := range headers {
+		p, err := parseRPMHeader(h)
+		if err != nil {
+			return nil, err
+		}
+		// Imported signing keys are stored as packages.
+		if p.Name == "gpg-pubkey" {
+			continue
+		}
+		p.Location = location
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// parseRPMHeader reads the package from a header blob: the number of index
+// entries and the size of the data, the index entries and the data. All
+// integers are big-endian.
+func parseRPMHeader(blob []byte) (Package, error) {
+	if len(blob) < 8 {
+		return Package{}, errInvalidRPMHeader
+	}
+	il, dl := uint64(binary.BigEndian.Uint32(blob)), uint64(binary.BigEndian.Uint32(blob[4:]))
+	start := 8 + il*rpmHeaderIndexSize
+	if start+dl > uint64(len(blob)) {
+		return Package{}, errInvalidRPMHeader
+	}
+	data := blob[start : start+dl]
+
+	values := map[uint32]string{}
+	for i := uint64(0); i < il; i++ {
+		entry := blob[8+i*rpmHeaderIndexSize:]
+		tag, typ := binary.BigEndian.Uint32(entry), binary.BigEndian.Uint32(entry[4:])
+		offset := uint64(binary.BigEndian.Uint32(entry[8:]))
+		if offset >= dl {
+			continue
+		}
+		switch {
+		case typ == rpmTypeString || typ == rpmTypeI18NString:
+			v := data[offset:]
+			if end := bytes.IndexByte(v, 0); end >= 0 {
+				v = v[:end]
+			}
+			values[tag] = string(v)
+		case typ == rpmTypeInt32 && offset+4 <= dl:
+			values[tag] = strconv.FormatUint(uint64(binary.BigEndian.Uint32(data[offset:])), 10)
+		}
+	}
+
+	name := values[rpmTagName]
+	if name == "" {
+		return Package{}, errInvalidRPMHeader
+	}
+	version := values[rpmTagVersion]
+	if release := values[rpmTagRelease]; release != "" {
+		version += "-" + release
+	}
+	if epoch := values[rpmTagEpoch]; epoch != "" {
+		version = epoch + ":" + version
+	}
+	return Package{
+		Type:    TypeRpm,
+		Name:    name,
+		Version: version,
+		Arch:    values[rpmTagArch],
+		Source:  rpmSourceName(values[rpmTagSourceRPM], name),
+	}, nil
+}
+
+// rpmSourceName returns the name of a source RPM like
+// "bash-5.1.8-6.el9.src.rpm".
+func rpmSourceName(srpm, name string) string {
+	for i := 0; i < 2; i++ {
+		end := strings.LastIndexByte(srpm, '-')
+		if end <= 0 {
+			return ""
+		}
+		srpm = srpm[:end]
+	}
+	return sourceName(srpm, name)
+}
+
+// NDB databases start with a header followed by the slot pages. Each slot
+// points to a blob, all integers are little-endian.
+const (
+	ndbMagic       = "RpmP"
+	ndbSlotMagic   = "Slot"
+	ndbBlobMagic   = "BlbS"
+	ndbHeaderSize  = 32
+	ndbSlotSize    = 16
+	ndbPageSize    = 4096
+	ndbBlockSize   = 16
+	ndbBlobHdrSize = 16
+)
+
+// ndbBlobs returns the blobs of all used slots of an NDB database.
+func ndbBlobs(db []byte) ([][]byte, error) {
+	le := binary.LittleEndian
+	if len(db) < ndbHeaderSize || le.Uint32(db[4:]) != 0 {
+		return nil, errors.New("unsupported NDB database")
+	}
+	slotPages := uint64(le.Uint32(db[12:]))
+	if slotPages == 0 || slotPages*ndbPageSize > uint64(len(db)) {
+		return nil, errors.New("truncated NDB database")
+	}
+
+	// The header takes the place of the first two slots.
+	slots := slotPages*ndbPageSize/ndbSlotSize - 2
+	blobs := [][]byte{}
+	for i := uint64(0); i < slots; i++ {
+		slot := db[ndbHeaderSize+i*ndbSlotSize:]
+		if string(slot[:4]) != ndbSlotMagic {
+			return nil, errors.New("invalid NDB slot")
+		}
+		pkgIndex := le.Uint32(slot[4:])
+		if pkgIndex == 0 {
+			continue
+		}
+		offset := uint64(le.Uint32(slot[8:])) * ndbBlockSize
+		if offset+ndbBlobHdrSize > uint64(len(db)) {
+			return nil, errors.New("truncated NDB database")
+		}
+		hdr := db[offset:]
+		if string(hdr[:4]) != ndbBlobMagic || le.Uint32(hdr[4:]) != pkgIndex {
+			return nil, errors.New("invalid NDB blob")
+		}
+		size := uint64(le.Uint32(hdr[12:]))
+		start := offset + ndbBlobHdrSize
+		if start+size > uint64(len(db)) {
+			return nil, errors.New("truncated NDB database")
+		}
+		blobs = append(blobs, db[start:start+size])
+	}
+	return blobs, nil
+}
+
+// Berkeley DB hash databases consist of pages of the size given in the
+// metadata page. Pages and entries are stored in the byte order of the
+// machine that created the database.
+const (
+	bdbHashMagic         = 0x061561
+	bdbPageHeaderSize    = 26
+	bdbPageOverflow      = 7
+	bdbPageHash          = 13
+	bdbPageHashUnsorted  = 2
+	bdbEntryOffPage      = 3
+	bdbOffPageEntrySize  = 12
+	bdbMetadataMinLength = 72
+)
+
+// bdbHashValues returns the values of a Berkeley DB hash database that are
+// stored on overflow pages. Package headers are too large to be stored on
+// hash pages, smaller values are internal records of RPM.
+func bdbHashValues(db []byte) ([][]byte, error) {
+	if len(db) < bdbMetadataMinLength {
+		return nil, errors.New("unknown database format")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(db[12:]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(db[12:]) != bdbHashMagic {
+			return nil, errors.New("unknown database format")
+		}
+	}
+	if db[24] != 0 {
+		return nil, errors.New("encrypted Berkeley DB databases are not supported")
+	}
+	pageSize := uint64(order.Uint32(db[20:]))
+	lastPage := uint64(order.Uint32(db[32:]))
+	if pageSize < 512 || pageSize > 64<<10 {
+		return nil, errors.New("invalid Berkeley DB page size")
+	}
+	if (lastPage+1)*pageSize > uint64(len(db)) {
+		return nil, errors.New("truncated Berkeley DB database")
+	}
+
+	values := [][]byte{}
+	for pgno := uint64(1); pgno <= lastPage; pgno++ {
+		page := db[pgno*pageSize : (pgno+1)*pageSize]
+		if page[25] != bdbPageHash && page[25] != bdbPageHashUnsorted {
+			continue
+		}
+		entries := uint64(order.Uint16(page[20:]))
+		if bdbPageHeaderSize+entries*2 > pageSize {
+			return nil, errors.New("invalid Berkeley DB page")
+		}
+		// Entries alternate between keys and values.
+		for i := uint64(1); i < entries; i += 2 {
+			offset := uint64(order.Uint16(page[bdbPageHeaderSize+i*2:]))
+			if offset+bdbOffPageEntrySize > pageSize {
+				return nil, errors.New("invalid Berkeley DB page")
+			}
+			if page[offset] != bdbEntryOffPage {
+				continue
+			}
+			value, err := bdbOverflow(db, pageSize, lastPage, order, uint64(order.Uint32(page[offset+4:])), uint64(order.Uint32(page[offset+8:])))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// bdbOverflow concatenates the chain of overflow pages holding a value of the
+// given length.
+func bdbOverflow(db []byte, pageSize, lastPage uint64, order binary.ByteOrder, pgno, length uint64) ([]byte, error) {
+	if length > uint64(len(db)) {
+		return nil, errors.New("invalid Berkeley DB value")
+	}
+	value := make([]byte, 0, length)
+	for n := uint64(0); pgno != 0; n++ {
+		if pgno > lastPage || n > lastPage {
+			return nil, errors.New("invalid Berkeley DB overflow page")
+		}
+		page := db[pgno*pageSize : (pgno+1)*pageSize]
+		used := uint64(order.Uint16(page[22:]))
+		if page[25] != bdbPageOverflow || bdbPageHeaderSize+used > pageSize {
+			return nil, errors.New("invalid Berkeley DB overflow page")
+		}
+		value = append(value, page[bdbPageHeaderSize:bdbPageHeaderSize+used]...)
+		pgno = uint64(order.Uint32(page[16:]))
+	}
+	if uint64(len(value)) != length {
+		return nil, errors.New("truncated Berkeley DB value")
+	}
+	return value, nil
+}
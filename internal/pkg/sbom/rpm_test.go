@@ -0,0 +1,179 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testdata/rpmdb.sqlite was created by sqlite3 with the schema of RPM. It
+// contains bash, openssl-libs with epoch 1 and a description spanning
+// several overflow pages, a gpg-pubkey and the packages filler-00 to
+// filler-59.
+func TestParseRPMDatabaseSQLite(t *testing.T) {
+	db, err := ioutil.ReadFile("testdata/rpmdb.sqlite")
+	assert.NoError(t, err)
+
+	pkgs, err := parseRPMDatabase(bytes.NewReader(db), "/var/lib/rpm/rpmdb.sqlite")
+	assert.NoError(t, err)
+	if assert.Len(t, pkgs, 62) {
+		assert.Equal(t, Package{Type: TypeRpm, Name: "bash", Version: "5.1.8-6.el9", Arch: "x86_64", Location: "/var/lib/rpm/rpmdb.sqlite"}, pkgs[0])
+		assert.Equal(t, Package{Type: TypeRpm, Name: "openssl-libs", Version: "1:3.0.7-24.el9", Arch: "x86_64", Source: "openssl", Location: "/var/lib/rpm/rpmdb.sqlite"}, pkgs[1])
+		assert.Equal(t, "filler-59", pkgs[61].Name)
+	}
+
+	_, err = parseRPMDatabase(bytes.NewReader(db[:len(db)/2]), "/var/lib/rpm/rpmdb.sqlite")
+	assert.EqualError(t, err, "invalid SQLite database")
+}
+
+func TestParseRPMDatabaseNDB(t *testing.T) {
+	bash := rpmHeader(map[uint32]string{rpmTagName: "bash", rpmTagVersion: "4.4", rpmTagRelease: "150400.25.22", rpmTagArch: "x86_64"})
+	zypper := rpmHeader(map[uint32]string{rpmTagName: "zypper", rpmTagVersion: "1.14.68", rpmTagRelease: "150400.3.41.1", rpmTagSourceRPM: "zypper-1.14.68-150400.3.41.1.src.rpm"})
+
+	pkgs, err := parseRPMDatabase(bytes.NewReader(ndbDatabase(bash, zypper)), "/usr/lib/sysimage/rpm/Packages.db")
+	assert.NoError(t, err)
+	assert.Equal(t, []Package{
+		{Type: TypeRpm, Name: "bash", Version: "4.4-150400.25.22", Arch: "x86_64", Location: "/usr/lib/sysimage/rpm/Packages.db"},
+		{Type: TypeRpm, Name: "zypper", Version: "1.14.68-150400.3.41.1", Location: "/usr/lib/sysimage/rpm/Packages.db"},
+	}, pkgs)
+
+	db := ndbDatabase(bash)
+	copy(db[ndbHeaderSize+ndbSlotSize:], "Slop")
+	_, err = parseRPMDatabase(bytes.NewReader(db), "/usr/lib/sysimage/rpm/Packages.db")
+	assert.EqualError(t, err, "invalid NDB slot")
+}
+
+func TestParseRPMDatabaseBerkeleyDB(t *testing.T) {
+	bash := rpmHeader(map[uint32]string{rpmTagName: "bash", rpmTagVersion: "4.2.46", rpmTagRelease: "35.el7_9", rpmTagArch: "x86_64"})
+	// The description spans several overflow pages.
+	glibc := rpmHeader(map[uint32]string{rpmTagName: "glibc", rpmTagVersion: "2.17", rpmTagRelease: "326.el7_9", 1005: strings.Repeat("x", 2000)})
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		pkgs, err := parseRPMDatabase(bytes.NewReader(bdbDatabase(order, bash, glibc)), "/var/lib/rpm/Packages")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"bash", "glibc"}, packageNames(Image{Packages: pkgs}))
+	}
+
+	db := bdbDatabase(binary.LittleEndian, bash, glibc)
+	_, err := parseRPMDatabase(bytes.NewReader(db[:len(db)-512]), "/var/lib/rpm/Packages")
+	assert.EqualError(t, err, "truncated Berkeley DB database")
+}
+
+func TestParseRPMDatabaseInvalid(t *testing.T) {
+	_, err := parseRPMDatabase(strings.NewReader("not a database"), "/var/lib/rpm/Packages")
+	assert.EqualError(t, err, "unknown database format")
+
+	_, err = parseRPMDatabase(bytes.NewReader(ndbDatabase([]byte{0, 0, 0, 9, 0, 0, 0, 0})), "/usr/lib/sysimage/rpm/Packages.db")
+	assert.EqualError(t, err, "invalid package header")
+}
+
+func TestScanLayerRPM(t *testing.T) {
+	db, err := ioutil.ReadFile("testdata/rpmdb.sqlite")
+	assert.NoError(t, err)
+	l, err := scanLayer(bytes.NewReader(tarBytes(t,
+		tarFile{name: "usr/lib/sysimage/rpm/rpmdb.sqlite", content: string(db)},
+		tarFile{name: "var/lib/rpm/Packages", content: "broken"},
+	)))
+	assert.NoError(t, err)
+	assert.Len(t, l.files["usr/lib/sysimage/rpm/rpmdb.sqlite"].packages, 62)
+	assert.Equal(t, "RPM database /var/lib/rpm/Packages could not be read: unknown database format", l.files["var/lib/rpm/Packages"].err)
+}
+
+// rpmHeader returns a header blob with string values.
+func rpmHeader(values map[uint32]string) []byte {
+	tags := []uint32{}
+	for tag := range values {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	index, data := new(bytes.Buffer), new(bytes.Buffer)
+	for _, tag := range tags {
+		_ = binary.Write(index, binary.BigEndian, []uint32{tag, rpmTypeString, uint32(data.Len()), 1})
+		data.WriteString(values[tag] + "\x00")
+	}
+	blob := new(bytes.Buffer)
+	_ = binary.Write(blob, binary.BigEndian, []uint32{uint32(len(tags)), uint32(data.Len())})
+	blob.Write(index.Bytes())
+	blob.Write(data.Bytes())
+	return blob.Bytes()
+}
+
+// ndbDatabase returns an NDB database with one slot page. The first slot is
+// left empty.
+func ndbDatabase(blobs ...[]byte) []byte {
+	le := binary.LittleEndian
+	db := make([]byte, ndbPageSize)
+	copy(db, ndbMagic)
+	le.PutUint32(db[12:], 1)
+	for i := ndbHeaderSize; i < ndbPageSize; i += ndbSlotSize {
+		copy(db[i:], ndbSlotMagic)
+	}
+	for i, b := range blobs {
+		slot := db[ndbHeaderSize+(i+1)*ndbSlotSize:]
+		le.PutUint32(slot[4:], uint32(i+1))
+		le.PutUint32(slot[8:], uint32(len(db)/ndbBlockSize))
+		le.PutUint32(slot[12:], uint32((ndbBlobHdrSize+len(b)+ndbBlockSize-1)/ndbBlockSize))
+
+		hdr := make([]byte, ndbBlobHdrSize)
+		copy(hdr, ndbBlobMagic)
+		le.PutUint32(hdr[4:], uint32(i+1))
+		le.PutUint32(hdr[12:], uint32(len(b)))
+		db = append(append(db, hdr...), b...)
+		for len(db)%ndbBlockSize != 0 {
+			db = append(db, 0)
+		}
+	}
+	return db
+}
+
+// bdbDatabase returns a Berkeley DB hash database with a single hash page.
+// The values are stored on overflow pages, followed by a small value stored
+// on the hash page like the ones RPM uses for internal records.
+func bdbDatabase(order binary.ByteOrder, values ...[]byte) []byte {
+	const pageSize = 512
+	pages := [][]byte{make([]byte, pageSize), make([]byte, pageSize)}
+	meta, hash := pages[0], pages[1]
+	order.PutUint32(meta[12:], bdbHashMagic)
+	order.PutUint32(meta[20:], pageSize)
+	hash[25] = bdbPageHash
+
+	offset := pageSize
+	entry := func(b []byte) {
+		offset -= len(b)
+		copy(hash[offset:], b)
+		n := order.Uint16(hash[20:])
+		order.PutUint16(hash[bdbPageHeaderSize+n*2:], uint16(offset))
+		order.PutUint16(hash[20:], n+1)
+	}
+	for i, v := range values {
+		entry([]byte{1, byte(i + 1)})
+		first := len(pages)
+		for rest := v; len(rest) > 0; {
+			page := make([]byte, pageSize)
+			page[25] = bdbPageOverflow
+			n := copy(page[bdbPageHeaderSize:], rest)
+			order.PutUint16(page[22:], uint16(n))
+			rest = rest[n:]
+			if len(rest) > 0 {
+				order.PutUint32(page[16:], uint32(len(pages)+1))
+			}
+			pages = append(pages, page)
+		}
+		offPage := make([]byte, bdbOffPageEntrySize)
+		offPage[0] = bdbEntryOffPage
+		order.PutUint32(offPage[4:], uint32(first))
+		order.PutUint32(offPage[8:], uint32(len(v)))
+		entry(offPage)
+	}
+	entry([]byte{1, 0})
+	entry([]byte{1, 0, 0, 0, 1})
+
+	order.PutUint32(meta[32:], uint32(len(pages)-1))
+	return bytes.Join(pages, nil)
+}
@@ -0,0 +1,240 @@
+// Package sbom lists the software packages of images in archives created by
+// `docker save` or OCI image layouts.
+//
+// Packages are read from the dpkg and apk databases and the build info of Go
+// binaries as well as the RPM databases in the SQLite, NDB and Berkeley DB
+// formats.
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxMetadataSize limits the size of files kept to map images to layers.
+const maxMetadataSize = 4 << 20
+
+// Image is the result of scanning an image.
+type Image struct {
+	Name     string
+	Platform string
+	OS       OS
+	Packages []Package
+	// Errors describe layers and package databases that could not be
+	// scanned, their packages are missing.
+	Errors []string
+}
+
+// Scanner collects the packages of all layers of an archive. Use Observe to
+// feed it the archive's files and Images to get the results.
+type Scanner struct {
+	layers   map[string]*layer
+	metadata map[string][]byte
+}
+
+// NewScanner returns an empty Scanner.
+func NewScanner() *Scanner {
+	return &Scanner{
+		layers:   map[string]*layer{},
+		metadata: map[string][]byte{},
+	}
+}
+
+// Observe scans a file of the archive. Layers are scanned for packages,
+// small files are kept to read the image manifests later.
+func (s *Scanner) Observe(hdr *tar.Header, r io.Reader) error {
+	br := bufio.NewReaderSize(r, 1024)
+	head, _ := br.Peek(512)
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil
+		}
+		defer zr.Close()
+		s.addLayer(hdr.Name, zr)
+	case len(head) == 512 && string(head[257:262]) == "ustar":
+		s.addLayer(hdr.Name, br)
+	case hdr.Size <= maxMetadataSize:
+		content, err := ioutil.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		s.metadata[hdr.Name] = content
+	}
+	return nil
+}
+
+// addLayer scans the layer. Layers that can't be scanned are reported in the
+// results of the images using them.
+func (s *Scanner) addLayer(name string, r io.Reader) {
+	l, err := scanLayer(r)
+	if err != nil {
+		l = &layer{err: fmt.Sprintf("layer %s could not be scanned: %v", name, err)}
+	}
+	s.layers[name] = l
+}
+
+// dockerManifest is an entry of manifest.json written by `docker save`.
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// Images returns the scanned images of the archive.
+func (s *Scanner) Images() ([]Image, error) {
+	if content, ok := s.metadata["manifest.json"]; ok {
+		var manifests []dockerManifest
+		if err := json.Unmarshal(content, &manifests); err != nil {
+			return nil, fmt.Errorf("parsing manifest.json: %w", err)
+		}
+		images := make([]Image, 0, len(manifests))
+		for _, m := range manifests {
+			name := strings.TrimSuffix(path.Base(m.Config), ".json")
+			if len(m.RepoTags) > 0 {
+				name = m.RepoTags[0]
+			}
+			img := s.image(m.Layers)
+			img.Name = name
+			images = append(images, img)
+		}
+		return images, nil
+	}
+
+	if content, ok := s.metadata["index.json"]; ok {
+		var idx ocispec.Index
+		if err := json.Unmarshal(content, &idx); err != nil {
+			return nil, fmt.Errorf("parsing index.json: %w", err)
+		}
+		images := []Image{}
+		for _, d := range idx.Manifests {
+			name := d.Annotations[ocispec.AnnotationRefName]
+			imgs, err := s.ociImages(name, d)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, imgs...)
+		}
+		return images, nil
+	}
+
+	return nil, fmt.Errorf("archive contains neither manifest.json nor index.json")
+}
+
+func (s *Scanner) ociImages(name string, d ocispec.Descriptor) ([]Image, error) {
+	content, ok := s.metadata[blobPath(d)]
+	if !ok {
+		return nil, fmt.Errorf("manifest %s of %s not found", d.Digest, name)
+	}
+
+	switch d.MediaType {
+	case ocispec.MediaTypeImageIndex, "application/vnd.docker.distribution.manifest.list.v2+json":
+		var idx ocispec.Index
+		if err := json.Unmarshal(content, &idx); err != nil {
+			return nil, fmt.Errorf("parsing index %s: %w", d.Digest, err)
+		}
+		images := []Image{}
+		for _, child := range idx.Manifests {
+			if _, ok := s.metadata[blobPath(child)]; !ok {
+				continue
+			}
+			imgs, err := s.ociImages(name, child)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, imgs...)
+		}
+		return images, nil
+	}
+
+	var mf ocispec.Manifest
+	if err := json.Unmarshal(content, &mf); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", d.Digest, err)
+	}
+	if mf.Config.MediaType != ocispec.MediaTypeImageConfig && mf.Config.MediaType != "application/vnd.docker.container.image.v1+json" {
+		// Artifacts like signatures and SBOMs
+		return nil, nil
+	}
+
+	layers := make([]string, 0, len(mf.Layers))
+	for _, l := range mf.Layers {
+		layers = append(layers, blobPath(l))
+	}
+	img := s.image(layers)
+	img.Name = name
+	if d.Platform != nil {
+		img.Platform = d.Platform.OS + "/" + d.Platform.Architecture
+		if d.Platform.Variant != "" {
+			img.Platform += "/" + d.Platform.Variant
+		}
+	}
+	return []Image{img}, nil
+}
+
+func blobPath(d ocispec.Descriptor) string {
+	return "blobs/" + d.Digest.Algorithm().String() + "/" + d.Digest.Encoded()
+}
+
+// image merges the layers into the file system of an image.
+func (s *Scanner) image(layers []string) Image {
+	img := Image{Packages: []Package{}}
+	fs := map[string]*file{}
+	for _, name := range layers {
+		l, ok := s.layers[name]
+		if !ok {
+			continue
+		}
+		if l.err != "" {
+			img.Errors = append(img.Errors, l.err)
+		}
+		for _, w := range l.whiteouts {
+			for p := range fs {
+				if p == w || strings.HasPrefix(p, w+"/") {
+					delete(fs, p)
+				}
+			}
+		}
+		for _, dir := range l.opaque {
+			for p := range fs {
+				if strings.HasPrefix(p, dir+"/") {
+					delete(fs, p)
+				}
+			}
+		}
+		for p, f := range l.files {
+			fs[p] = f
+		}
+	}
+
+	paths := make([]string, 0, len(fs))
+	for p := range fs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		f := fs[p]
+		img.Packages = append(img.Packages, f.packages...)
+		if f.err != "" {
+			img.Errors = append(img.Errors, f.err)
+		}
+		// etc/os-release takes precedence over usr/lib/os-release.
+		if f.os != nil && (img.OS.ID == "" || p == "etc/os-release") {
+			img.OS = *f.os
+		}
+	}
+	return img
+}
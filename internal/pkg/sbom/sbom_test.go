@@ -0,0 +1,141 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+type tarFile struct {
+	name    string
+	content string
+	mode    int64
+}
+
+func TestScannerDockerArchive(t *testing.T) {
+	goBinary := "\x7fELF" + string(goBuildInfo("go1.21.5", "path\texample.com/app\nmod\texample.com/app\tv1.0.0\t\n"))
+	base := tarBytes(t,
+		tarFile{name: "etc/os-release", content: "ID=debian\nVERSION_ID=\"12\"\n"},
+		tarFile{name: "var/lib/dpkg/status", content: dpkgStatus},
+		tarFile{name: "usr/bin/app", content: goBinary, mode: 0755},
+		tarFile{name: "usr/bin/tool", content: goBinary, mode: 0755},
+		tarFile{name: "opt/data/tool", content: goBinary, mode: 0755},
+		tarFile{name: "var/lib/rpm/rpmdb.sqlite", content: "sqlite"},
+	)
+	top := tarBytes(t,
+		tarFile{name: "usr/bin/.wh.tool"},
+		tarFile{name: "opt/data/.wh..wh..opq"},
+		tarFile{name: "usr/bin/app", content: "\x7fELF replaced by a C binary", mode: 0755},
+	)
+	manifest, _ := json.Marshal([]dockerManifest{
+		{Config: "abc.json", RepoTags: []string{"docker.io/library/app:latest"}, Layers: []string{"base/layer.tar", "top/layer.tar"}},
+		{Config: "def.json", Layers: []string{"base/layer.tar"}},
+	})
+
+	subject := NewScanner()
+	observe(t, subject, tarFile{name: "base/layer.tar", content: string(base)})
+	observe(t, subject, tarFile{name: "top/layer.tar", content: string(top)})
+	observe(t, subject, tarFile{name: "manifest.json", content: string(manifest)})
+
+	images, err := subject.Images()
+	assert.NoError(t, err)
+	if !assert.Len(t, images, 2) {
+		return
+	}
+
+	assert.Equal(t, "docker.io/library/app:latest", images[0].Name)
+	assert.Equal(t, OS{ID: "debian", VersionID: "12"}, images[0].OS)
+	assert.Equal(t, []string{"bash", "libc6", "tzdata"}, packageNames(images[0]))
+	assert.Equal(t, []string{"RPM database /var/lib/rpm/rpmdb.sqlite could not be read: unknown database format"}, images[0].Errors)
+
+	assert.Equal(t, "def", images[1].Name)
+	assert.Equal(t, []string{"stdlib", "example.com/app", "stdlib", "example.com/app", "stdlib", "example.com/app", "bash", "libc6", "tzdata"}, packageNames(images[1]))
+}
+
+func TestScannerOCILayout(t *testing.T) {
+	layer := gzipBytes(t, tarBytes(t, tarFile{name: "lib/apk/db/installed", content: apkInstalled}))
+	layerDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+	config := []byte(`{"os":"linux","architecture":"arm64"}`)
+	mf := ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers: []ocispec.Descriptor{layerDesc},
+	}
+	mfContent, _ := json.Marshal(mf)
+	mfDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(mfContent), Size: int64(len(mfContent)), Platform: &ocispec.Platform{OS: "linux", Architecture: "arm64"}}
+	idx, _ := json.Marshal(ocispec.Index{Manifests: []ocispec.Descriptor{mfDesc}})
+	idxDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromBytes(idx), Size: int64(len(idx))}
+	idxDesc.Annotations = map[string]string{ocispec.AnnotationRefName: "docker.io/library/alpine:3.19"}
+	layout, _ := json.Marshal(ocispec.Index{Manifests: []ocispec.Descriptor{idxDesc}})
+
+	subject := NewScanner()
+	for _, f := range []tarFile{
+		{name: blobPath(layerDesc), content: string(layer)},
+		{name: blobPath(mf.Config), content: string(config)},
+		{name: blobPath(mfDesc), content: string(mfContent)},
+		{name: blobPath(idxDesc), content: string(idx)},
+		{name: "index.json", content: string(layout)},
+	} {
+		observe(t, subject, f)
+	}
+
+	images, err := subject.Images()
+	assert.NoError(t, err)
+	if assert.Len(t, images, 1) {
+		assert.Equal(t, "docker.io/library/alpine:3.19", images[0].Name)
+		assert.Equal(t, "linux/arm64", images[0].Platform)
+		assert.Equal(t, []string{"musl", "ssl_client", "busybox"}, packageNames(images[0]))
+	}
+}
+
+func TestScannerWithoutManifest(t *testing.T) {
+	_, err := NewScanner().Images()
+	assert.EqualError(t, err, "archive contains neither manifest.json nor index.json")
+}
+
+func observe(t *testing.T, s *Scanner, f tarFile) {
+	t.Helper()
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: f.name, Size: int64(len(f.content))}
+	assert.NoError(t, s.Observe(hdr, bytes.NewBufferString(f.content)))
+}
+
+func packageNames(img Image) []string {
+	names := []string{}
+	for _, p := range img.Packages {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func tarBytes(t *testing.T, files ...tarFile) []byte {
+	t.Helper()
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	for _, f := range files {
+		mode := f.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: f.name, Size: int64(len(f.content)), Mode: mode}))
+		_, err := io.WriteString(tw, f.content)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	return b.Bytes()
+}
+
+func gzipBytes(t *testing.T, content []byte) []byte {
+	t.Helper()
+	b := new(bytes.Buffer)
+	zw := gzip.NewWriter(b)
+	_, err := zw.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return b.Bytes()
+}
@@ -0,0 +1,271 @@
+package sbom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// sqliteMagic starts every SQLite database file.
+const sqliteMagic = "SQLite format 3\x00"
+
+// Page types of table b-trees, see https://www.sqlite.org/fileformat.html
+const (
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+	sqliteMaxDepth      = 64
+)
+
+var errInvalidSQLite = errors.New("invalid SQLite database")
+
+// sqliteDB reads tables from the contents of an SQLite database. Journals
+// are not read.
+type sqliteDB struct {
+	data     []byte
+	pageSize uint64
+	usable   uint64
+	visited  map[uint64]bool
+}
+
+// sqliteColumn returns the text and blob values of a column of all rows of
+// a table, in rowid order.
+func sqliteColumn(data []byte, table string, column int) ([][]byte, error) {
+	if len(data) < 100 {
+		return nil, errInvalidSQLite
+	}
+	pageSize := uint64(binary.BigEndian.Uint16(data[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	reserved := uint64(data[20])
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 || pageSize-reserved < 480 {
+		return nil, errInvalidSQLite
+	}
+	db := &sqliteDB{data: data, pageSize: pageSize, usable: pageSize - reserved, visited: map[uint64]bool{}}
+
+	// The schema is stored in the table starting at the first page, its
+	// columns are type, name, tbl_name, rootpage and sql.
+	root := int64(0)
+	err := db.walk(1, 0, func(record []interface{}) error {
+		if len(record) < 4 {
+			return errInvalidSQLite
+		}
+		typ, _ := record[0].([]byte)
+		name, _ := record[1].([]byte)
+		if string(typ) == "table" && string(name) == table {
+			root, _ = record[3].(int64)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root <= 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+
+	values := [][]byte{}
+	err = db.walk(uint64(root), 0, func(record []interface{}) error {
+		if column < len(record) {
+			if v, ok := record[column].([]byte); ok {
+				values = append(values, v)
+			}
+		}
+		return nil
+	})
+	return values, err
+}
+
+// page returns the page with the given number, counting from 1, and the
+// offset of its b-tree header.
+func (db *sqliteDB) page(pgno uint64) ([]byte, uint64, error) {
+	if pgno == 0 || pgno*db.pageSize > uint64(len(db.data)) {
+		return nil, 0, errInvalidSQLite
+	}
+	page := db.data[(pgno-1)*db.pageSize : pgno*db.pageSize][:db.usable]
+	if pgno == 1 {
+		return page, 100, nil
+	}
+	return page, 0, nil
+}
+
+// walk calls fn for the records of the table b-tree starting at pgno.
+func (db *sqliteDB) walk(pgno uint64, depth int, fn func([]interface{}) error) error {
+	if depth > sqliteMaxDepth || db.visited[pgno] {
+		return errInvalidSQLite
+	}
+	db.visited[pgno] = true
+	page, hdr, err := db.page(pgno)
+	if err != nil {
+		return err
+	}
+	if hdr+12 > uint64(len(page)) {
+		return errInvalidSQLite
+	}
+	typ := page[hdr]
+	cells := uint64(binary.BigEndian.Uint16(page[hdr+3:]))
+	pointers := hdr + 8
+	if typ == sqliteInteriorTable {
+		pointers = hdr + 12
+	}
+	if pointers+cells*2 > uint64(len(page)) {
+		return errInvalidSQLite
+	}
+
+	for i := uint64(0); i < cells; i++ {
+		offset := uint64(binary.BigEndian.Uint16(page[pointers+i*2:]))
+		if offset >= uint64(len(page)) {
+			return errInvalidSQLite
+		}
+		cell := page[offset:]
+		switch typ {
+		case sqliteInteriorTable:
+			if len(cell) < 4 {
+				return errInvalidSQLite
+			}
+			if err := db.walk(uint64(binary.BigEndian.Uint32(cell)), depth+1, fn); err != nil {
+				return err
+			}
+		case sqliteLeafTable:
+			payload, err := db.payload(cell)
+			if err != nil {
+				return err
+			}
+			record, err := sqliteRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		default:
+			return errInvalidSQLite
+		}
+	}
+	if typ == sqliteInteriorTable {
+		return db.walk(uint64(binary.BigEndian.Uint32(page[hdr+8:])), depth+1, fn)
+	}
+	return nil
+}
+
+// payload returns the payload of a table leaf cell, following its overflow
+// pages.
+func (db *sqliteDB) payload(cell []byte) ([]byte, error) {
+	size, n := sqliteVarint(cell)
+	if n == 0 {
+		return nil, errInvalidSQLite
+	}
+	_, m := sqliteVarint(cell[n:])
+	if m == 0 || size > uint64(len(db.data)) {
+		return nil, errInvalidSQLite
+	}
+	cell = cell[n+m:]
+
+	local := size
+	if max := db.usable - 35; size > max {
+		min := (db.usable-12)*32/255 - 23
+		local = min + (size-min)%(db.usable-4)
+		if local > max {
+			local = min
+		}
+	}
+	if local > uint64(len(cell)) || (local < size && local+4 > uint64(len(cell))) {
+		return nil, errInvalidSQLite
+	}
+	payload := append(make([]byte, 0, size), cell[:local]...)
+
+	next := uint64(0)
+	if local < size {
+		next = uint64(binary.BigEndian.Uint32(cell[local:]))
+	}
+	for uint64(len(payload)) < size {
+		if db.visited[next] {
+			return nil, errInvalidSQLite
+		}
+		db.visited[next] = true
+		page, _, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		content := page[4:]
+		if rest := size - uint64(len(payload)); rest < uint64(len(content)) {
+			content = content[:rest]
+		}
+		payload = append(payload, content...)
+		next = uint64(binary.BigEndian.Uint32(page))
+	}
+	return payload, nil
+}
+
+// sqliteRecord decodes a record into nil, int64 or []byte values. Floats are
+// not needed and decoded as nil.
+func sqliteRecord(payload []byte) ([]interface{}, error) {
+	hdrSize, n := sqliteVarint(payload)
+	if n == 0 || hdrSize < uint64(n) || hdrSize > uint64(len(payload)) {
+		return nil, errInvalidSQLite
+	}
+	hdr, body := payload[n:hdrSize], payload[hdrSize:]
+
+	record := []interface{}{}
+	for len(hdr) > 0 {
+		serial, n := sqliteVarint(hdr)
+		if n == 0 {
+			return nil, errInvalidSQLite
+		}
+		hdr = hdr[n:]
+
+		var size uint64
+		switch {
+		case serial <= 4:
+			size = serial
+		case serial == 5:
+			size = 6
+		case serial == 6 || serial == 7:
+			size = 8
+		case serial == 8 || serial == 9:
+			size = 0
+		case serial >= 12:
+			size = (serial - 12) / 2
+		default:
+			return nil, errInvalidSQLite
+		}
+		if size > uint64(len(body)) {
+			return nil, errInvalidSQLite
+		}
+		value := body[:size]
+		body = body[size:]
+
+		switch {
+		case serial >= 1 && serial <= 6:
+			// Big-endian two's complement, sign extended.
+			i := int64(int8(value[0]))
+			for _, b := range value[1:] {
+				i = i<<8 | int64(b)
+			}
+			record = append(record, i)
+		case serial == 8 || serial == 9:
+			record = append(record, int64(serial-8))
+		case serial >= 12:
+			record = append(record, value)
+		default:
+			record = append(record, nil)
+		}
+	}
+	return record, nil
+}
+
+// sqliteVarint decodes a big-endian varint of up to nine bytes. It returns
+// the number of bytes read, 0 if the input is truncated.
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	_ "embed"
+	"errors"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/openapi"
+	"github.com/labstack/echo/v4"
+)
+
+// APIPrefix is the path of the versioned API below the base URL. Its routes
+// are described by openapi.json. The unversioned routes are kept for
+// compatibility and are not validated.
+const APIPrefix = "/api/v1"
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+var apiDoc = mustLoadAPIDoc()
+
+func mustLoadAPIDoc() *openapi.Document {
+	doc, err := openapi.Load(openAPIDocument)
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+// validateRequest rejects requests not matching the operation of their route.
+// prefix is the path of the API.
+func validateRequest(prefix string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			op := apiDoc.Operation(c.Request().Method, pathTemplate(strings.TrimPrefix(c.Path(), prefix)))
+			if op == nil {
+				return next(c)
+			}
+			params := map[string]string{}
+			for _, name := range c.ParamNames() {
+				params[name] = c.Param(name)
+			}
+			err := openapi.ValidateRequest(op, c.Request(), params)
+			var invalid *openapi.Error
+			if errors.As(err, &invalid) {
+				return echo.NewHTTPError(invalid.Status, invalid.Message)
+			}
+			if err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// pathTemplate converts an echo route path like "/lists/:name" to the
+// OpenAPI path template "/lists/{name}".
+func pathTemplate(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAPIMatchesRoutes keeps openapi.json and the routes below APIPrefix in
+// sync.
+func TestAPIMatchesRoutes(t *testing.T) {
+	subject := NewServer(ServerOpts{BaseURL: "/saveomat", ListsDir: t.TempDir()})
+
+	var routes []string
+	for _, r := range subject.Routes() {
+		prefix := "/saveomat" + APIPrefix
+		if !strings.HasPrefix(r.Path, prefix+"/") {
+			continue
+		}
+		routes = append(routes, r.Method+" "+pathTemplate(strings.TrimPrefix(r.Path, prefix)))
+	}
+	sort.Strings(routes)
+	assert.Equal(t, apiDoc.Operations(), routes)
+
+	for path, item := range apiDoc.Paths {
+		for method, op := range *item {
+			assert.NotEmpty(t, op.OperationID, "%s %s", method, path)
+			assert.NotEmpty(t, op.Responses, "%s %s", method, path)
+			for _, p := range op.Parameters {
+				if p.In == "path" {
+					assert.Contains(t, path, "{"+p.Name+"}", "%s %s", method, path)
+				}
+			}
+		}
+	}
+}
+
+// TestAPIDocumentsLegacyRoutes ensures the unversioned routes are available in
+// the versioned API as well.
+func TestAPIDocumentsLegacyRoutes(t *testing.T) {
+	subject := NewServer(ServerOpts{ListsDir: t.TempDir()})
+	for _, r := range subject.Routes() {
+		if strings.HasPrefix(r.Path, APIPrefix) || r.Path == "/*" {
+			continue
+		}
+		assert.NotNil(t, apiDoc.Operation(r.Method, pathTemplate(r.Path)), "%s %s", r.Method, r.Path)
+	}
+}
+
+func TestGetOpenAPI(t *testing.T) {
+	subject := NewServer(ServerOpts{BaseURL: "/saveomat"})
+	req := httptest.NewRequest(http.MethodGet, "/saveomat/api/v1/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc["openapi"])
+}
+
+func TestAPIGetTar(t *testing.T) {
+	images := []string{"busybox", "open.io/busybox"}
+	subject := NewServer(ServerOpts{DockerClient: dockerMockFor(t, images, nil)})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/tar?"+url.Values{"image": images, "checksums": {"false"}}.Encode(), nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestAPIPostTar(t *testing.T) {
+	subject := NewServer(ServerOpts{DockerClient: dockerMockFor(t, []string{"busybox"}, nil)})
+
+	body := new(bytes.Buffer)
+	mpw := multipart.NewWriter(body)
+	fw, err := mpw.CreateFormFile("images.txt", "images.txt")
+	assert.NoError(t, err)
+	fw.Write([]byte("include base.txt\n"))
+	fw, err = mpw.CreateFormFile("base.txt", "base.txt")
+	assert.NoError(t, err)
+	fw.Write([]byte("busybox\n"))
+	assert.NoError(t, mpw.WriteField("format", "docker"))
+	assert.NoError(t, mpw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tar", body)
+	req.Header.Set("Content-Type", mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestAPIPostTarJSON(t *testing.T) {
+	subject := NewServer(ServerOpts{DockerClient: dockerMockFor(t, []string{"busybox"}, nil)})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tar", strings.NewReader(`{"images": ["busybox"], "format": "docker", "checksums": false}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestAPIValidation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	subject := NewServer(ServerOpts{DockerClient: NewMockImageAPIClient(ctrl), ListsDir: t.TempDir()})
+
+	for _, tc := range []struct {
+		method, path, contentType, body string
+		code                            int
+		message                         string
+	}{
+		{http.MethodGet, "/api/v1/tar", "", "", http.StatusBadRequest, `missing query parameter "image"`},
+		{http.MethodGet, "/api/v1/tar?image=busybox&format=zip", "", "", http.StatusBadRequest, `invalid query parameter "format": "zip" is not one of docker, oci`},
+		{http.MethodGet, "/api/v1/tar?image=busybox&checksums=maybe", "", "", http.StatusBadRequest, `invalid query parameter "checksums": "maybe" is not a boolean`},
+		{http.MethodGet, "/api/v1/tar?image=busybox&platform=a&platform=b", "", "", http.StatusBadRequest, `query parameter "platform" must not be repeated`},
+		{http.MethodGet, "/api/v1/plan?image=busybox&format=oci", "", "", http.StatusBadRequest, `unknown query parameter "format"`},
+		{http.MethodPost, "/api/v1/tar", "text/plain", "busybox", http.StatusUnsupportedMediaType, `unsupported content type "text/plain", expected one of application/json, multipart/form-data`},
+		{http.MethodPost, "/api/v1/tar", "application/json", `{"images": ["busybox"], "format": "zip"}`, http.StatusBadRequest, `body.format: "zip" is not one of docker, oci`},
+		{http.MethodPost, "/api/v1/tar", "application/json", `{"images": ["busybox"], "tag": "latest"}`, http.StatusBadRequest, "body.tag is not allowed"},
+		{http.MethodPost, "/api/v1/tar", "application/json", `{"images": ["busybox"], "auths": {"test.io": "secret"}}`, http.StatusBadRequest, "body.auths.test.io must be of type object"},
+		{http.MethodPost, "/api/v1/push", "", "", http.StatusBadRequest, "missing request body"},
+		{http.MethodPut, "/api/v1/lists/base", "application/json", `{"images": "busybox", "keep": -1}`, http.StatusBadRequest, "body.keep: -1 is less than 0"},
+		{http.MethodPut, "/api/v1/lists/base", "application/json", `{"images": "busybox", "cron": "@daily"}`, http.StatusBadRequest, "body.cron is not allowed"},
+		{http.MethodPut, "/api/v1/lists/base", "application/json", `{"schedule": "@daily"}`, http.StatusBadRequest, "body.images is required"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		subject.ServeHTTP(rec, req)
+
+		assert.Equal(t, tc.code, rec.Code, tc.path)
+		var res struct{ Message string }
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, tc.message, res.Message, tc.path)
+	}
+
+	// The unversioned routes are not validated.
+	req := httptest.NewRequest(http.MethodGet, "/tar?image=busybox&format=zip&unknown=1", nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `invalid format \"zip\", expected \"docker\" or \"oci\"`)
+}
+
+func TestPathTemplate(t *testing.T) {
+	assert.Equal(t, "/lists/{name}/archives/{id}", pathTemplate("/lists/:name/archives/:id"))
+	assert.Equal(t, "/tar", pathTemplate("/tar"))
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/delta"
+	"github.com/labstack/echo/v4"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// haveFrom collects the layer digests the receiver already has from "have"
+// parameters and uploaded "have" files.
+func haveFrom(c echo.Context) (map[digest.Digest]bool, error) {
+	have := map[digest.Digest]bool{}
+	add := func(name string, r io.Reader) error {
+		digests, err := delta.ParseDigests(r)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid layer list %s: %v", name, err))
+		}
+		for _, d := range digests {
+			have[d] = true
+		}
+		return nil
+	}
+
+	// FormValue parses the query and the form.
+	c.FormValue("have")
+	req := c.Request()
+	for _, v := range req.Form["have"] {
+		if err := add("have", strings.NewReader(v)); err != nil {
+			return nil, err
+		}
+	}
+	if req.MultipartForm != nil {
+		for _, fh := range req.MultipartForm.File["have"] {
+			err := withFormFile(fh, func(r io.Reader) error {
+				return add(fh.Filename, r)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return have, nil
+}
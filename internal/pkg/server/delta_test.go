@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/delta"
+	"github.com/golang/mock/gomock"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTarDelta(t *testing.T) {
+	base := tarOf(t, map[string]string{"lib/apk/db/installed": "P:musl\nV:1.2.4-r2\nA:x86_64\n"})
+	app := tarOf(t, map[string]string{"app": "binary"})
+	saved := tarOf(t, map[string]string{
+		"base/layer.tar": string(base),
+		"app/layer.tar":  string(app),
+		"config.json":    fmt.Sprintf(`{"rootfs":{"type":"layers","diff_ids":[%q,%q]}}`, digest.FromBytes(base), digest.FromBytes(app)),
+		"manifest.json":  `[{"Config":"config.json","RepoTags":["docker.io/library/app:latest"],"Layers":["base/layer.tar","app/layer.tar"]}]`,
+	})
+
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/app:latest", gomock.Any()).Return(mockProgessReader(), nil).Times(2)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"docker.io/library/app:latest"}).DoAndReturn(func(context.Context, []string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(saved)), nil
+	}).Times(2)
+
+	subject := NewServer(ServerOpts{DockerClient: mc})
+	params := url.Values{"image": {"app"}, "have": {digest.FromBytes(base).String()}, "sbom": {"spdx"}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	files := tarFiles(t, rec.Body.Bytes())
+	assert.NotContains(t, files, "base/layer.tar")
+	assert.Equal(t, app, files["app/layer.tar"])
+	var manifest delta.Manifest
+	assert.NoError(t, json.Unmarshal(files[delta.ManifestFile], &manifest))
+	assert.Equal(t, []delta.Layer{{Name: "base/layer.tar", Digest: digest.FromBytes(base), Size: int64(len(base))}}, manifest.Omitted)
+	// omitted layers are still scanned
+	assert.Contains(t, string(files["sboms/docker.io_library_app_latest.spdx.json"]), "musl")
+
+	// the SHA256SUMS of a previous archive can be uploaded
+	sums := fmt.Sprintf("%s  base/layer.tar\n%s  app/layer.tar\n", digest.FromBytes(base).Encoded(), digest.FromBytes(app).Encoded())
+	rec = postFiles(t, subject, "/tar?checksums=true", formFile{"images.txt", "app\n"}, formFile{"have", sums})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	files = tarFiles(t, rec.Body.Bytes())
+	assert.NotContains(t, files, "base/layer.tar")
+	assert.NotContains(t, files, "app/layer.tar")
+	assert.Contains(t, string(files[archive.SumsFile]), delta.ManifestFile)
+
+	expectResponseCode(t, subject, "/tar?image=app&have=sha256:abc", http.StatusBadRequest)
+}
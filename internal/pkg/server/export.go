@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/s3"
+	"github.com/labstack/echo/v4"
+)
+
+// defaultPresignExpiry is the validity of download URLs of uploaded archives.
+const defaultPresignExpiry = 24 * time.Hour
+
+// exportResult describes an archive uploaded to the object storage.
+type exportResult struct {
+	// URL is a presigned download URL.
+	URL       string    `json:"url"`
+	ObjectURL string    `json:"objectURL"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Expires   time.Time `json:"expires"`
+	Images    []string  `json:"images"`
+}
+
+// export uploads the archive to the object storage and responds with its
+// location.
+func (s *Server) export(c echo.Context, r io.Reader, filename, contentType string, res *bundleResult) error {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	key := s.ObjectPrefix + now.Format("20060102T150405Z") + "-" + hex.EncodeToString(id) + "/" + filename
+
+	obj, err := s.ObjectStorage.Upload(c.Request().Context(), key, contentType, r)
+	var s3Err *s3.Error
+	var urlErr *url.Error
+	if errors.As(err, &s3Err) || errors.As(err, &urlErr) {
+		return echo.NewHTTPError(http.StatusBadGateway, "uploading archive: "+err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	expiry := s.PresignExpiry
+	if expiry <= 0 {
+		expiry = defaultPresignExpiry
+	}
+	result := exportResult{
+		URL:       s.ObjectStorage.Presign(key, expiry).String(),
+		ObjectURL: s.ObjectStorage.ObjectURL(key).String(),
+		Key:       key,
+		Size:      obj.Size,
+		SHA256:    obj.SHA256,
+		Expires:   now.Add(expiry),
+		Images:    res.images,
+	}
+	res.size, res.sha256, res.location = obj.Size, obj.SHA256, result.URL
+	return c.JSON(http.StatusCreated, result)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/s3"
+	"github.com/bastjan/saveomat/internal/pkg/s3/s3test"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTarExport(t *testing.T) {
+	srv := s3test.New()
+	defer srv.Close()
+	hook, deliveries := webhookServer(t)
+	defer hook.Close()
+
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/busybox:latest", gomock.Any()).Return(mockProgessReader(), nil)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"docker.io/library/busybox:latest"}).Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{
+		DockerClient: mc,
+		ObjectStorage: &s3.Client{
+			Endpoint:  srv.Endpoint(),
+			Bucket:    "bundles",
+			AccessKey: s3test.AccessKey,
+			SecretKey: s3test.SecretKey,
+		},
+		ObjectPrefix:  "saveomat/",
+		PresignExpiry: time.Hour,
+		Webhooks:      []string{hook.URL},
+	})
+	req := httptest.NewRequest(http.MethodGet, "/tar?image=busybox&output=s3", nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var res exportResult
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.True(t, strings.HasPrefix(res.Key, "saveomat/"), res.Key)
+	assert.True(t, strings.HasSuffix(res.Key, "/images.tar"), res.Key)
+	assert.Equal(t, srv.URL+"/bundles/"+res.Key, res.ObjectURL)
+	assert.Equal(t, int64(len(mockTarBytes(t))), res.Size)
+	assert.Equal(t, sha256Hex(mockTarBytes(t)), res.SHA256)
+	assert.Equal(t, []string{"docker.io/library/busybox:latest"}, res.Images)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), res.Expires, time.Minute)
+
+	_, ev := receiveEvent(t, deliveries)
+	assert.Equal(t, res.URL, ev.Location)
+	assert.Equal(t, res.SHA256, ev.SHA256)
+
+	resp, err := http.Get(res.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, mockTarBytes(t), b)
+}
+
+func TestGetTarExportErrors(t *testing.T) {
+	srv := s3test.New()
+	defer srv.Close()
+
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/busybox:latest", gomock.Any()).Return(mockProgessReader(), nil)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"docker.io/library/busybox:latest"}).Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{DockerClient: mc})
+	expectResponseCode(t, subject, "/tar?image=busybox&output=s3", http.StatusBadRequest)
+	expectResponseCode(t, subject, "/tar?image=busybox&output=ftp", http.StatusBadRequest)
+
+	subject.ObjectStorage = &s3.Client{Endpoint: srv.Endpoint(), Bucket: "bundles", AccessKey: "wrong"}
+	expectResponseCode(t, subject, "/tar?image=busybox&output=s3", http.StatusBadGateway)
+}
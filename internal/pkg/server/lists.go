@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/bundle"
+	"github.com/bastjan/saveomat/internal/pkg/cron"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/lists"
+	"github.com/bastjan/saveomat/internal/pkg/vuln"
+	"github.com/labstack/echo/v4"
+)
+
+// listOptions are the options lists may set. Options changing the response,
+// like output or split, are not supported.
+var listOptions = map[string]bool{
+	"format":       true,
+	"checksums":    true,
+	"referrers":    true,
+	"sbom":         true,
+	"scan":         true,
+	"fail-on":      true,
+	"retag-prefix": true,
+	"platform":     true,
+}
+
+// mapParams are parameters stored in a list.
+type mapParams map[string]string
+
+func (p mapParams) FormValue(name string) string {
+	return p[name]
+}
+
+// listResponse describes a list and its archives.
+type listResponse struct {
+	lists.List
+	Archives []lists.Archive `json:"archives"`
+}
+
+func (s *Server) getLists(c echo.Context) error {
+	all, err := s.Lists.All()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, all)
+}
+
+func (s *Server) getList(c echo.Context) error {
+	l, err := s.list(c.Param("name"))
+	if err != nil {
+		return err
+	}
+	archives, err := s.Lists.Archives(l.Name)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, listResponse{List: l, Archives: archives})
+}
+
+func (s *Server) putList(c echo.Context) error {
+	var l lists.List
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&l); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid list: "+err.Error())
+	}
+	if l.Name != "" && l.Name != c.Param("name") {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("list name %q does not match the URL", l.Name))
+	}
+	l.Name = c.Param("name")
+	if err := l.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if _, _, err := s.listBundle(l); err != nil {
+		return err
+	}
+
+	created, err := s.Lists.Put(l)
+	if err != nil {
+		return err
+	}
+	if created {
+		return c.JSON(http.StatusCreated, l)
+	}
+	return c.JSON(http.StatusOK, l)
+}
+
+func (s *Server) deleteList(c echo.Context) error {
+	name := c.Param("name")
+	if err := s.Lists.Delete(name); err != nil {
+		return listNotFound(name, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) getListArchives(c echo.Context) error {
+	name := c.Param("name")
+	archives, err := s.Lists.Archives(name)
+	if err != nil {
+		return listNotFound(name, err)
+	}
+	return c.JSON(http.StatusOK, archives)
+}
+
+func (s *Server) postListArchive(c echo.Context) error {
+	a, err := s.buildList(c.Request().Context(), c.Param("name"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, a)
+}
+
+// getListArchive sends the archive with the ID given in the URL or the newest
+// archive if there is no ID.
+func (s *Server) getListArchive(c echo.Context) error {
+	name, id := c.Param("name"), c.Param("id")
+	if id == "" {
+		id = "latest"
+	}
+	f, a, err := s.Lists.Open(name, id)
+	if errors.Is(err, lists.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("archive %s of list %s not found", id, name))
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-%s.tar"`, name, a.ID))
+	h.Set(HeaderImages, strings.Join(a.Images, ","))
+	h.Set(echo.HeaderContentType, "application/x-tar")
+	h.Set(HeaderSHA256, a.SHA256)
+	// Stored archives do not change, so clients can resume them with the
+	// ETag in If-Range.
+	h.Set("ETag", `"`+a.SHA256+`"`)
+	http.ServeContent(c.Response(), c.Request(), "", a.Created, f)
+	return nil
+}
+
+// requireListsToken rejects requests without the ListsToken as bearer token,
+// if one is configured.
+func (s *Server) requireListsToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.ListsToken == "" {
+			return next(c)
+		}
+		h := c.Request().Header.Get(echo.HeaderAuthorization)
+		token := strings.TrimPrefix(h, "Bearer ")
+		if token == h || subtle.ConstantTimeCompare([]byte(token), []byte(s.ListsToken)) != 1 {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="saveomat"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, "missing or invalid lists token")
+		}
+		return next(c)
+	}
+}
+
+func (s *Server) list(name string) (lists.List, error) {
+	l, err := s.Lists.Get(name)
+	return l, listNotFound(name, err)
+}
+
+func listNotFound(name string, err error) error {
+	if errors.Is(err, lists.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("list %s not found", name))
+	}
+	return err
+}
+
+// listBundle parses the images and options of the list.
+func (s *Server) listBundle(l lists.List) ([]imagelist.Entry, bundleOptions, error) {
+	var unsupported []string
+	for name := range l.Options {
+		if !listOptions[name] {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, bundleOptions{}, echo.NewHTTPError(http.StatusBadRequest, "unsupported list options: "+strings.Join(unsupported, ", "))
+	}
+	p := mapParams(l.Options)
+	opts, err := parseBundleOptions(p)
+	if err != nil {
+		return nil, opts, err
+	}
+	if err := s.checkOptions(opts); err != nil {
+		return nil, opts, err
+	}
+
+	entries, err := imagelist.Parse(l.Name, strings.NewReader(l.Images), nil)
+	if err != nil {
+		return nil, opts, badImageList(err)
+	}
+	images, err := bundle.Normalize(entries, p.FormValue("retag-prefix"), p.FormValue("platform"))
+	if err != nil {
+		return nil, opts, badImageList(err)
+	}
+	if len(images) == 0 {
+		return nil, opts, echo.NewHTTPError(http.StatusBadRequest, "list contains no images")
+	}
+	return images, opts, nil
+}
+
+// buildList builds an archive of the list, stores it and notifies the
+// configured webhooks. Lists are built one at a time.
+func (s *Server) buildList(ctx context.Context, name string) (lists.Archive, error) {
+	if !s.lockList(name) {
+		return lists.Archive{}, echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("list %s is already being built", name))
+	}
+	defer s.unlockList(name)
+
+	l, err := s.list(name)
+	if err != nil {
+		return lists.Archive{}, err
+	}
+	images, opts, err := s.listBundle(l)
+	if err != nil {
+		return lists.Archive{}, err
+	}
+
+	start := time.Now()
+	var res bundleResult
+	a, err := s.storeList(ctx, l, images, opts, &res)
+	if len(s.Webhooks) > 0 {
+		s.notify(s.WebhookSender, s.Webhooks, res.event(images, time.Since(start), err))
+	}
+	return a, err
+}
+
+func (s *Server) storeList(ctx context.Context, l lists.List, images []imagelist.Entry, opts bundleOptions, res *bundleResult) (lists.Archive, error) {
+	b, err := s.bundler().Build(ctx, s.ListsAuth, images, opts.Options)
+	if err != nil {
+		return lists.Archive{}, err
+	}
+	defer b.Close()
+	res.images, res.digests = b.Images, b.Digests
+
+	threshold := s.bundler().Threshold(opts.FailOn)
+	a, err := s.Lists.Add(l.Name, b, b.Images, func() error {
+		if threshold == vuln.SeverityUnknown {
+			return nil
+		}
+		if failed := bundle.FailedReports(b.Reports, threshold); len(failed) > 0 {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, scanFailure{
+				Message: fmt.Sprintf("%d image(s) contain vulnerabilities of severity %s or higher or could not be scanned", len(failed), threshold),
+				Images:  failed,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return a, listNotFound(l.Name, err)
+	}
+	res.size, res.sha256 = a.Size, a.SHA256
+	return a, nil
+}
+
+func (s *Server) lockList(name string) bool {
+	s.buildingMu.Lock()
+	defer s.buildingMu.Unlock()
+	if s.building == nil {
+		s.building = map[string]bool{}
+	}
+	if s.building[name] {
+		return false
+	}
+	s.building[name] = true
+	return true
+}
+
+func (s *Server) unlockList(name string) {
+	s.buildingMu.Lock()
+	defer s.buildingMu.Unlock()
+	delete(s.building, name)
+}
+
+// RunScheduler builds the archives of lists with a schedule until ctx is
+// done. Schedules are evaluated in the local time zone.
+func (s *Server) RunScheduler(ctx context.Context) {
+	if s.Lists == nil {
+		return
+	}
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+		s.runScheduled(ctx, next)
+	}
+}
+
+// runScheduled starts building all lists scheduled at t in the background.
+func (s *Server) runScheduled(ctx context.Context, t time.Time) {
+	all, err := s.Lists.All()
+	if err != nil {
+		s.Logger.Errorf("scheduling lists: %v", err)
+		return
+	}
+	for _, l := range all {
+		if !isDue(l, t) {
+			continue
+		}
+		name := l.Name
+		go func() {
+			a, err := s.buildList(ctx, name)
+			if err != nil {
+				s.Logger.Errorf("building list %s: %v", name, err)
+				return
+			}
+			s.Logger.Infof("built list %s: archive %s, %d bytes", name, a.ID, a.Size)
+		}()
+	}
+}
+
+// isDue reports whether the list is scheduled at the minute t.
+func isDue(l lists.List, t time.Time) bool {
+	if l.Schedule == "" {
+		return false
+	}
+	sched, err := cron.Parse(l.Schedule)
+	if err != nil {
+		return false
+	}
+	return sched.Next(t.Add(-time.Minute)).Equal(t)
+}
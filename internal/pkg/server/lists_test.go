@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/lists"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func doRequest(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLists(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	subject := NewServer(ServerOpts{DockerClient: mc, ListsDir: t.TempDir()})
+
+	rec := doRequest(subject, http.MethodPut, "/lists/base", `{"images": "busybox\nalpine:3.19\n", "schedule": "0 2 * * *", "keep": 7}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = doRequest(subject, http.MethodPut, "/lists/base", `{"name": "base", "images": "busybox\n", "options": {"checksums": "true"}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doRequest(subject, http.MethodPut, "/lists/apps", `{"images": "nginx\n"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = doRequest(subject, http.MethodGet, "/lists/base", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var l listResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &l))
+	assert.Equal(t, lists.List{Name: "base", Images: "busybox\n", Options: map[string]string{"checksums": "true"}}, l.List)
+	assert.Empty(t, l.Archives)
+
+	rec = doRequest(subject, http.MethodGet, "/lists", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var all []lists.List
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &all))
+	assert.Len(t, all, 2)
+
+	assert.Equal(t, http.StatusNoContent, doRequest(subject, http.MethodDelete, "/lists/apps", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(subject, http.MethodDelete, "/lists/apps", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(subject, http.MethodGet, "/lists/apps", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(subject, http.MethodGet, "/lists/apps/latest.tar", "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(subject, http.MethodGet, "/lists/base/latest.tar", "").Code)
+}
+
+func TestListsToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	subject := NewServer(ServerOpts{DockerClient: mc, ListsDir: t.TempDir(), ListsToken: "secret"})
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/lists"},
+		{http.MethodPut, "/lists/base"},
+		{http.MethodDelete, "/lists/base"},
+		{http.MethodPost, "/lists/base/archives"},
+		{http.MethodGet, "/lists/base/latest.tar"},
+		{http.MethodGet, "/api/v1/lists/base/archives/1"},
+	} {
+		for _, authorization := range []string{"", "secret", "Bearer other", "Basic c2VjcmV0"} {
+			req := httptest.NewRequest(route.method, route.path, strings.NewReader(`{"images": "busybox"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", authorization)
+			rec := httptest.NewRecorder()
+			subject.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, route.path+" "+authorization)
+			assert.Equal(t, `Bearer realm="saveomat"`, rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/lists/base", strings.NewReader(`{"images": "busybox"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
+
+func TestListsInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	subject := NewServer(ServerOpts{DockerClient: mc, ListsDir: t.TempDir()})
+
+	for body, msg := range map[string]string{
+		`{"images": "busybox", "schedule": "daily"}`:          `invalid cron expression "daily": expected 5 fields, got 1`,
+		`{"images": "busybox", "options": {"output": "s3"}}`:  "unsupported list options: output",
+		`{"images": "busybox", "options": {"format": "zip"}}`: `invalid format "zip", expected "docker" or "oci"`,
+		`{"images": "busybox", "options": {"scan": "true"}}`:  "vulnerability scanning is not configured",
+		`{"images": "# nothing\n"}`:                           "list contains no images",
+		`{"images": "busybox", "tags": ["latest"]}`:           `invalid list: json: unknown field "tags"`,
+		`{"name": "other", "images": "busybox"}`:              `list name "other" does not match the URL`,
+		`{"images": "busybox:UPPER CASE"}`:                    "invalid image list",
+	} {
+		rec := doRequest(subject, http.MethodPut, "/lists/base", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		var res struct{ Message string }
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, msg, res.Message, body)
+	}
+	rec := doRequest(subject, http.MethodPut, "/lists/Base", `{"images": "busybox"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	subject = NewServer(ServerOpts{DockerClient: mc})
+	expectResponseCode(t, subject, "/lists", http.StatusNotFound)
+}
+
+func TestListArchives(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/busybox:latest", gomock.Any()).Return(mockProgessReader(), nil).Times(2)
+	mc.EXPECT().ImageTag(gomock.Any(), "docker.io/library/busybox:latest", "registry.airgap.local/busybox:latest").Return(nil).Times(2)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"registry.airgap.local/busybox:latest"}).DoAndReturn(func(context.Context, []string) (io.ReadCloser, error) {
+		return mockTarReader(t), nil
+	}).Times(2)
+
+	subject := NewServer(ServerOpts{DockerClient: mc, ListsDir: t.TempDir()})
+	rec := doRequest(subject, http.MethodPut, "/lists/base", `{"images": "busybox", "keep": 1, "options": {"retag-prefix": "registry.airgap.local"}}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		rec = doRequest(subject, http.MethodPost, "/lists/base/archives", "")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var a lists.Archive
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &a))
+		assert.Equal(t, []string{"registry.airgap.local/busybox:latest"}, a.Images)
+		assert.Equal(t, sha256Hex(mockTarBytes(t)), a.SHA256)
+		ids = append(ids, a.ID)
+	}
+
+	rec = doRequest(subject, http.MethodGet, "/lists/base/archives", "")
+	var archives []lists.Archive
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &archives))
+	if assert.Len(t, archives, 1) {
+		assert.Equal(t, ids[1], archives[0].ID)
+	}
+
+	rec = doRequest(subject, http.MethodGet, "/lists/base/latest.tar", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+	assert.Equal(t, "application/x-tar", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="base-`+ids[1]+`.tar"`, rec.Header().Get("Content-Disposition"))
+	assert.Equal(t, "registry.airgap.local/busybox:latest", rec.Header().Get(HeaderImages))
+	assert.Equal(t, sha256Hex(mockTarBytes(t)), rec.Header().Get(HeaderSHA256))
+	etag := `"` + sha256Hex(mockTarBytes(t)) + `"`
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+
+	for ifRange, code := range map[string]int{etag: http.StatusPartialContent, `"other"`: http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/lists/base/latest.tar", nil)
+		req.Header.Set("Range", "bytes=10-")
+		req.Header.Set("If-Range", ifRange)
+		rec = httptest.NewRecorder()
+		subject.ServeHTTP(rec, req)
+		assert.Equal(t, code, rec.Code, ifRange)
+	}
+
+	assert.Equal(t, http.StatusOK, doRequest(subject, http.MethodGet, "/lists/base/archives/"+ids[1], "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(subject, http.MethodGet, "/lists/base/archives/"+ids[0], "").Code)
+
+	assert.True(t, subject.lockList("base"))
+	assert.Equal(t, http.StatusConflict, doRequest(subject, http.MethodPost, "/lists/base/archives", "").Code)
+	subject.unlockList("base")
+}
+
+func TestRunScheduled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/busybox:latest", gomock.Any()).Return(mockProgessReader(), nil)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"docker.io/library/busybox:latest"}).Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{DockerClient: mc, ListsDir: t.TempDir()})
+	_, err := subject.Lists.Put(lists.List{Name: "nightly", Images: "busybox", Schedule: "0 2 * * *"})
+	assert.NoError(t, err)
+	_, err = subject.Lists.Put(lists.List{Name: "manual", Images: "alpine"})
+	assert.NoError(t, err)
+
+	subject.runScheduled(context.Background(), time.Date(2024, 1, 1, 2, 0, 0, 0, time.Local))
+	var archives []lists.Archive
+	for deadline := time.Now().Add(5 * time.Second); len(archives) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		archives, err = subject.Lists.Archives("nightly")
+		assert.NoError(t, err)
+	}
+	assert.Len(t, archives, 1)
+	archives, err = subject.Lists.Archives("manual")
+	assert.NoError(t, err)
+	assert.Empty(t, archives)
+}
+
+func TestIsDue(t *testing.T) {
+	l := lists.List{Schedule: "30 2 * * *"}
+	assert.True(t, isDue(l, time.Date(2024, 1, 1, 2, 30, 0, 0, time.UTC)))
+	assert.False(t, isDue(l, time.Date(2024, 1, 1, 2, 31, 0, 0, time.UTC)))
+	assert.False(t, isDue(lists.List{}, time.Date(2024, 1, 1, 2, 30, 0, 0, time.UTC)))
+}
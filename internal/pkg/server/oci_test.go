@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/cosign"
+	"github.com/bastjan/saveomat/internal/pkg/cosign/cosigntest"
+	"github.com/bastjan/saveomat/internal/pkg/registry/registrytest"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTarOCILayout(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	key, _ := cosigntest.GenerateKey()
+	base := []byte("base layer")
+	amd64 := reg.PushImage("app", "", ocispec.Platform{OS: "linux", Architecture: "amd64"}, base, []byte("app amd64"))
+	arm64 := reg.PushImage("app", "", ocispec.Platform{OS: "linux", Architecture: "arm64"}, base, []byte("app arm64"))
+	idx := reg.PushIndex("app", "v1", amd64, arm64)
+	sig := cosigntest.PushSignatures(reg, "app", idx.Digest, key)
+	sbom := reg.PushArtifact("app", "", "application/spdx+json", amd64, []byte(`{"spdxVersion":"SPDX-2.3"}`))
+
+	subject := NewServer(ServerOpts{})
+	params := url.Values{
+		"image":     {reg.Host() + "/app:v1 => registry.airgap.local/app:v1"},
+		"format":    {"oci"},
+		"referrers": {"true"},
+	}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "registry.airgap.local/app:v1", rec.Header().Get(HeaderImages))
+
+	files := tarFiles(t, rec.Body.Bytes())
+	var index ocispec.Index
+	assert.NoError(t, json.Unmarshal(files["index.json"], &index))
+	assert.Equal(t, []digest.Digest{idx.Digest, sbom.Digest, sig.Digest}, manifestDigests(index))
+	assert.Equal(t, "registry.airgap.local/app:v1", index.Manifests[0].Annotations[ocispec.AnnotationRefName])
+	assert.Empty(t, index.Manifests[1].Annotations, "referrers are found by their subject")
+	assert.Equal(t, "registry.airgap.local/app:"+cosign.SignatureTag(idx.Digest), index.Manifests[2].Annotations[ocispec.AnnotationRefName])
+
+	for _, d := range []digest.Digest{idx.Digest, amd64.Digest, arm64.Digest, sig.Digest, sbom.Digest, digest.FromBytes(base)} {
+		assert.Contains(t, files, "blobs/sha256/"+d.Encoded())
+	}
+	for name, content := range files {
+		if strings.HasPrefix(name, "blobs/sha256/") {
+			assert.Equal(t, "sha256:"+strings.TrimPrefix(name, "blobs/sha256/"), digest.FromBytes(content).String())
+		}
+	}
+}
+
+func TestGetTarOCILayoutWithPlatform(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	amd64 := reg.PushImage("app", "", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("app amd64"))
+	arm64 := reg.PushImage("app", "", ocispec.Platform{OS: "linux", Architecture: "arm64"}, []byte("app arm64"))
+	idx := reg.PushIndex("app", "v1", amd64, arm64)
+	reg.PushArtifact("app", "", "application/spdx+json", amd64, []byte(`{}`))
+
+	subject := NewServer(ServerOpts{})
+	params := url.Values{
+		"image":  {reg.Host() + "/app:v1 platform=linux/arm64", reg.Host() + "/missing:v1 optional"},
+		"format": {"oci"},
+	}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	files := tarFiles(t, rec.Body.Bytes())
+	var index ocispec.Index
+	assert.NoError(t, json.Unmarshal(files["index.json"], &index))
+	assert.Equal(t, []digest.Digest{arm64.Digest}, manifestDigests(index))
+	assert.Equal(t, "arm64", index.Manifests[0].Platform.Architecture)
+	assert.NotContains(t, files, "blobs/sha256/"+amd64.Digest.Encoded())
+	assert.NotContains(t, files, "blobs/sha256/"+idx.Digest.Encoded())
+}
+
+func TestGetTarOCILayoutWithPlatformReferrers(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	key, _ := cosigntest.GenerateKey()
+	amd64 := reg.PushImage("app", "", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("app amd64"))
+	arm64 := reg.PushImage("app", "", ocispec.Platform{OS: "linux", Architecture: "arm64"}, []byte("app arm64"))
+	idx := reg.PushIndex("app", "v1", amd64, arm64)
+	sig := cosigntest.PushSignatures(reg, "app", idx.Digest, key)
+	sbom := reg.PushArtifact("app", "", "application/spdx+json", arm64, []byte(`{}`))
+	reg.PushArtifact("app", "", "application/spdx+json", amd64, []byte(`{"other": true}`))
+
+	subject := NewServer(ServerOpts{})
+	params := url.Values{
+		"image":     {reg.Host() + "/app:v1 platform=linux/arm64"},
+		"format":    {"oci"},
+		"referrers": {"true"},
+	}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	files := tarFiles(t, rec.Body.Bytes())
+	var index ocispec.Index
+	assert.NoError(t, json.Unmarshal(files["index.json"], &index))
+	// The signature of the index is kept, although the index is not.
+	assert.Equal(t, []digest.Digest{arm64.Digest, sbom.Digest, sig.Digest}, manifestDigests(index))
+	assert.NotContains(t, files, "blobs/sha256/"+idx.Digest.Encoded())
+}
+
+func TestGetTarInvalidFormat(t *testing.T) {
+	subject := NewServer(ServerOpts{})
+
+	for _, params := range []url.Values{
+		{"image": {"busybox"}, "format": {"zip"}},
+		{"image": {"busybox"}, "referrers": {"true"}},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/tar?"+params.Encode(), nil)
+		rec := httptest.NewRecorder()
+		subject.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	}
+}
+
+func manifestDigests(idx ocispec.Index) []digest.Digest {
+	digests := []digest.Digest{}
+	for _, m := range idx.Manifests {
+		digests = append(digests, m.Digest)
+	}
+	return digests
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/bundle"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/registry"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/labstack/echo/v4"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
+)
+
+// planResult describes how an image would be bundled.
+type planResult struct {
+	Image    string `json:"image"`
+	Target   string `json:"target"`
+	Platform string `json:"platform,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+	// Mirror is the mirrored reference the image is resolved from, if any.
+	Mirror    string   `json:"mirror,omitempty"`
+	Digest    string   `json:"digest,omitempty"`
+	Platforms []string `json:"platforms,omitempty"`
+	// CompressedSize is the size of the config and layers in the registry.
+	CompressedSize int64 `json:"compressedSize,omitempty"`
+	// Problem categorizes errors, e.g. "unauthorized" or "not found".
+	Problem string `json:"problem,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	blobs []ocispec.Descriptor
+}
+
+type planResponse struct {
+	Images []planResult `json:"images"`
+	// CompressedSize is the size of all distinct blobs of all images.
+	CompressedSize int64 `json:"compressedSize"`
+}
+
+func (s *Server) getPlan(c echo.Context) error {
+	images, err := imagesFromQuery(c)
+	if err != nil {
+		return err
+	}
+
+	return s.plan(c, auth.EmptyAuthenticator, images)
+}
+
+func (s *Server) postPlan(c echo.Context) error {
+	images, authn, err := imagesFromPost(c)
+	if err != nil {
+		return err
+	}
+
+	return s.plan(c, authn, images)
+}
+
+// plan resolves all images using the registry API without pulling them.
+func (s *Server) plan(c echo.Context, authn auth.Authenticator, images []imagelist.Entry) error {
+	if len(images) == 0 {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	results := make([]planResult, len(images))
+	g, errCtx := errgroup.WithContext(c.Request().Context())
+	for i, img := range images {
+		i, img := i, img
+		g.Go(func() error {
+			results[i] = s.planImage(errCtx, authn, img)
+			return nil
+		})
+	}
+	g.Wait()
+
+	res := planResponse{Images: results}
+	seen := map[string]bool{}
+	for _, r := range results {
+		for _, b := range r.blobs {
+			if !seen[b.Digest.String()] {
+				seen[b.Digest.String()] = true
+				res.CompressedSize += b.Size
+			}
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) planImage(ctx context.Context, authn auth.Authenticator, img imagelist.Entry) planResult {
+	result := planResult{Image: img.Source, Target: img.Target, Platform: img.Platform, Optional: img.Optional}
+
+	candidates := []string{img.Source}
+	if mirrored, ok, err := s.Mirrors.Rewrite(img.Source); err == nil && ok {
+		candidates = []string{mirrored, img.Source}
+	}
+
+	var err error
+	for _, candidate := range candidates {
+		err = s.resolve(ctx, authn, candidate, &result)
+		if err == nil {
+			if candidate != img.Source {
+				result.Mirror = candidate
+			}
+			return result
+		}
+	}
+
+	result.Error = err.Error()
+	var regErr *registry.Error
+	if errors.As(err, &regErr) {
+		result.Problem = strings.ToLower(http.StatusText(regErr.StatusCode))
+	}
+	return result
+}
+
+// resolve fills in digest, platforms and size of the image.
+func (s *Server) resolve(ctx context.Context, authn auth.Authenticator, img string, result *planResult) error {
+	ref, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return err
+	}
+	ac, err := auth.AuthConfigFor(authn, img)
+	if err != nil {
+		return err
+	}
+
+	m, err := s.RegistryClient.Manifest(ctx, ref, ac)
+	if err != nil {
+		return err
+	}
+	result.Digest = m.Digest.String()
+
+	want := result.Platform
+	if m.IsIndex() {
+		idx, err := m.Index()
+		if err != nil {
+			return err
+		}
+
+		result.Platforms = []string{}
+		var selected *ocispec.Descriptor
+		for i, d := range idx.Manifests {
+			if d.Platform == nil {
+				continue
+			}
+			p := bundle.PlatformString(*d.Platform)
+			result.Platforms = append(result.Platforms, p)
+			if selected == nil && bundle.MatchesPlatform(p, want) {
+				selected = &idx.Manifests[i]
+			}
+		}
+		if selected == nil {
+			return fmt.Errorf("platform %s is not available", bundle.DefaultPlatform(want))
+		}
+
+		digested, err := reference.WithDigest(reference.TrimNamed(ref), selected.Digest)
+		if err != nil {
+			return err
+		}
+		if m, err = s.RegistryClient.Manifest(ctx, digested, ac); err != nil {
+			return err
+		}
+	}
+
+	mf, err := m.Image()
+	if err != nil {
+		return err
+	}
+	if mf.Config.Digest == "" {
+		return fmt.Errorf("unsupported manifest type %q", m.MediaType)
+	}
+
+	if result.Platforms == nil {
+		p, err := s.configPlatform(ctx, ref, mf.Config, ac)
+		if err != nil {
+			return err
+		}
+		result.Platforms = []string{p}
+		if !bundle.MatchesPlatform(p, want) {
+			return fmt.Errorf("platform %s is not available", bundle.DefaultPlatform(want))
+		}
+	}
+
+	result.blobs = append([]ocispec.Descriptor{mf.Config}, mf.Layers...)
+	result.CompressedSize = 0
+	for _, b := range result.blobs {
+		result.CompressedSize += b.Size
+	}
+	return nil
+}
+
+func (s *Server) configPlatform(ctx context.Context, ref reference.Named, config ocispec.Descriptor, ac types.AuthConfig) (string, error) {
+	rc, err := s.RegistryClient.Blob(ctx, ref, config.Digest, ac)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var img ocispec.Image
+	if err := json.NewDecoder(io.LimitReader(rc, 4<<20)).Decode(&img); err != nil {
+		return "", fmt.Errorf("decoding image config: %w", err)
+	}
+	return bundle.PlatformString(ocispec.Platform{OS: img.OS, Architecture: img.Architecture}), nil
+}
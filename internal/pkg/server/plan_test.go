@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/registry/registrytest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPlan(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	base := []byte("base layer")
+	amd64 := reg.PushImage("app", "", ocispec.Platform{OS: "linux", Architecture: "amd64"}, base, []byte("app amd64"))
+	arm64 := reg.PushImage("app", "", ocispec.Platform{OS: "linux", Architecture: "arm64"}, base, []byte("app arm64"))
+	idx := reg.PushIndex("app", "v1", amd64, arm64)
+	tool := reg.PushImage("tool", "v2", ocispec.Platform{OS: "linux", Architecture: "arm64"}, base)
+
+	subject := NewServer(ServerOpts{})
+
+	images := []string{
+		reg.Host() + "/app:v1 platform=linux/arm64",
+		reg.Host() + "/tool:v2 platform=linux/arm64 => registry.airgap.local/tool:v2",
+		reg.Host() + "/missing optional platform=linux/arm64",
+		reg.Host() + "/tool:v2 platform=linux/amd64",
+	}
+	params := url.Values{"image": images}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/plan?"+params, nil)
+	rec := httptest.NewRecorder()
+
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var res planResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+
+	assert.Equal(t, []planResult{
+		{
+			Image:          reg.Host() + "/app:v1",
+			Target:         reg.Host() + "/app:v1",
+			Platform:       "linux/arm64",
+			Digest:         idx.Digest.String(),
+			Platforms:      []string{"linux/amd64", "linux/arm64"},
+			CompressedSize: res.Images[0].CompressedSize,
+		},
+		{
+			Image:          reg.Host() + "/tool:v2",
+			Target:         "registry.airgap.local/tool:v2",
+			Platform:       "linux/arm64",
+			Digest:         tool.Digest.String(),
+			Platforms:      []string{"linux/arm64"},
+			CompressedSize: res.Images[1].CompressedSize,
+		},
+		{
+			Image:    reg.Host() + "/missing:latest",
+			Target:   reg.Host() + "/missing:latest",
+			Platform: "linux/arm64",
+			Optional: true,
+			Problem:  "not found",
+			Error:    "not found: manifest unknown",
+		},
+		{
+			Image:     reg.Host() + "/tool:v2",
+			Target:    reg.Host() + "/tool:v2",
+			Platform:  "linux/amd64",
+			Digest:    tool.Digest.String(),
+			Platforms: []string{"linux/arm64"},
+			Error:     "platform linux/amd64 is not available",
+		},
+	}, res.Images)
+
+	// tool shares its config and base layer with app and is not counted again
+	assert.Less(t, res.Images[1].CompressedSize, res.Images[0].CompressedSize)
+	assert.Equal(t, res.Images[0].CompressedSize, res.CompressedSize)
+}
+
+func TestPostPlanWithAuth(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+	reg.Username, reg.Password = "test", "test"
+	reg.PushImage("private", "latest", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("layer"))
+
+	subject := NewServer(ServerOpts{})
+
+	rec := postFiles(t, subject, "/plan",
+		formFile{"images.txt", reg.Host() + "/private platform=linux/amd64"},
+	)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var res planResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, "unauthorized", res.Images[0].Problem)
+
+	rec = postFiles(t, subject, "/plan",
+		formFile{"images.txt", reg.Host() + "/private platform=linux/amd64"},
+		formFile{"config.json", `{"auths": {"` + reg.Host() + `": {"auth": "` + auth64 + `"}}}`},
+	)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var authRes planResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &authRes))
+	assert.Empty(t, authRes.Images[0].Error)
+	assert.NotEmpty(t, authRes.Images[0].Digest)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/bundle"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/sync/errgroup"
+)
+
+// pushResult is the outcome of pushing a single image.
+type pushResult struct {
+	Source string `json:"source"`
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type pushResponse struct {
+	Images []pushResult `json:"images"`
+}
+
+// postPush loads an uploaded archive and pushes all contained images.
+func (s *Server) postPush(c echo.Context) error {
+	file, err := c.FormFile("images.tar")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing images.tar")
+	}
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	authn, err := authFromFormFile(c, "config.json")
+	if err != nil {
+		return err
+	}
+	retagPrefix := c.FormValue("retag-prefix")
+
+	ctx := c.Request().Context()
+	loaded, err := s.loadImages(ctx, src)
+	if err != nil {
+		return err
+	}
+	if len(loaded) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "archive does not contain any tagged images")
+	}
+
+	results := make([]pushResult, len(loaded))
+	g, errCtx := errgroup.WithContext(ctx)
+	for i, img := range loaded {
+		i, img := i, img
+		g.Go(func() error {
+			results[i] = s.pushImage(errCtx, authn, img, retagPrefix)
+			return nil
+		})
+	}
+	g.Wait()
+
+	status := http.StatusOK
+	for _, r := range results {
+		if r.Error != "" {
+			status = http.StatusBadGateway
+		}
+	}
+	return c.JSON(status, pushResponse{Images: results})
+}
+
+// loadImages loads the archive into the docker daemon and returns the names
+// of the loaded images.
+func (s *Server) loadImages(ctx context.Context, archive io.Reader) ([]string, error) {
+	res, err := s.DockerClient.ImageLoad(ctx, archive, true)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	loaded := []string{}
+	dec := json.NewDecoder(res.Body)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if msg.Error != nil {
+			return nil, msg.Error
+		}
+		for _, line := range strings.Split(msg.Stream, "\n") {
+			if strings.HasPrefix(line, "Loaded image: ") {
+				loaded = append(loaded, strings.TrimPrefix(line, "Loaded image: "))
+			}
+		}
+	}
+	return loaded, nil
+}
+
+func (s *Server) pushImage(ctx context.Context, authn auth.Authenticator, img, retagPrefix string) pushResult {
+	result := pushResult{Source: img, Image: img}
+	fail := func(err error) pushResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	if retagPrefix != "" {
+		target, err := bundle.Retag(img, retagPrefix)
+		if err != nil {
+			return fail(err)
+		}
+		if err := s.DockerClient.ImageTag(ctx, img, target); err != nil {
+			return fail(err)
+		}
+		result.Image = target
+	}
+
+	encodedAuth, err := auth.RegistryAuthFor(authn, result.Image)
+	if err != nil {
+		return fail(err)
+	}
+	rc, err := s.DockerClient.ImagePush(ctx, result.Image, types.ImagePushOptions{
+		RegistryAuth: encodedAuth,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	defer rc.Close()
+
+	err = jsonmessage.DisplayJSONMessagesStream(rc, io.Discard, 0, false, func(msg jsonmessage.JSONMessage) {
+		var pr types.PushResult
+		if msg.Aux != nil && json.Unmarshal(*msg.Aux, &pr) == nil {
+			result.Digest = pr.Digest
+		}
+	})
+	if err != nil {
+		return fail(fmt.Errorf("pushing %s: %w", result.Image, err))
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostPush(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+
+	authn, err := auth.FromReader(strings.NewReader(testAuthConf))
+	assert.NoError(t, err)
+	testAuth, err := auth.RegistryAuthFor(authn, "test.io/busybox")
+	assert.NoError(t, err)
+
+	mc.EXPECT().
+		ImageLoad(gomock.Any(), gomock.Any(), true).
+		Return(types.ImageLoadResponse{
+			Body: mockLoadReader("busybox:latest", "quay.io/foo/bar:v1"),
+			JSON: true,
+		}, nil)
+	mc.EXPECT().ImageTag(gomock.Any(), "busybox:latest", "test.io/busybox:latest").Return(nil)
+	mc.EXPECT().ImageTag(gomock.Any(), "quay.io/foo/bar:v1", "test.io/foo/bar:v1").Return(nil)
+	mc.EXPECT().
+		ImagePush(gomock.Any(), "test.io/busybox:latest", types.ImagePushOptions{RegistryAuth: testAuth}).
+		Return(ioutil.NopCloser(strings.NewReader(`{"status":"Pushed"}{"aux":{"Tag":"latest","Digest":"sha256:abc","Size":42}}`)), nil)
+	mc.EXPECT().
+		ImagePush(gomock.Any(), "test.io/foo/bar:v1", types.ImagePushOptions{RegistryAuth: testAuth}).
+		Return(ioutil.NopCloser(strings.NewReader(`{"errorDetail":{"message":"denied"},"error":"denied"}`)), nil)
+
+	subject := NewServer(ServerOpts{DockerClient: mc})
+
+	upload := new(bytes.Buffer)
+	mpw := multipart.NewWriter(upload)
+	fw, err := mpw.CreateFormFile("images.tar", "images.tar")
+	assert.NoError(t, err)
+	fw.Write(mockTarBytes(t))
+	fw, err = mpw.CreateFormFile("config.json", "config.json")
+	assert.NoError(t, err)
+	fw.Write([]byte(testAuthConf))
+	assert.NoError(t, mpw.WriteField("retag-prefix", "test.io"))
+	mpw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/push", upload)
+	req.Header.Set(echo.HeaderContentType, mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+
+	var res pushResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, []pushResult{
+		{Source: "busybox:latest", Image: "test.io/busybox:latest", Digest: "sha256:abc"},
+		{Source: "quay.io/foo/bar:v1", Image: "test.io/foo/bar:v1", Error: "pushing test.io/foo/bar:v1: denied"},
+	}, res.Images)
+}
+
+func TestPostPushWithoutArchive(t *testing.T) {
+	subject := NewServer(ServerOpts{})
+
+	upload := new(bytes.Buffer)
+	mpw := multipart.NewWriter(upload)
+	mpw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/push", upload)
+	req.Header.Set(echo.HeaderContentType, mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPostPushLimit(t *testing.T) {
+	// The mock fails the test on any load
+	subject := NewServer(ServerOpts{DockerClient: NewMockImageAPIClient(gomock.NewController(t)), PushLimit: 1024})
+
+	upload := new(bytes.Buffer)
+	mpw := multipart.NewWriter(upload)
+	fw, err := mpw.CreateFormFile("images.tar", "images.tar")
+	assert.NoError(t, err)
+	fw.Write(bytes.Repeat([]byte{0}, 2048))
+	mpw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/push", upload)
+	req.Header.Set(echo.HeaderContentType, mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func mockLoadReader(images ...string) io.ReadCloser {
+	b := new(bytes.Buffer)
+	enc := json.NewEncoder(b)
+	for _, img := range images {
+		enc.Encode(map[string]string{"stream": "Loaded image: " + img + "\n"})
+	}
+	return ioutil.NopCloser(b)
+}
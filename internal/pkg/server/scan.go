@@ -0,0 +1,10 @@
+package server
+
+import "github.com/bastjan/saveomat/internal/pkg/vuln"
+
+// scanFailure is returned if images contain vulnerabilities at or above the
+// severity threshold or could not be scanned.
+type scanFailure struct {
+	Message string        `json:"message"`
+	Images  []vuln.Report `json:"images"`
+}
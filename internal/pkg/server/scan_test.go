@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/vuln"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const muslAdvisory = `{
+  "id": "ALPINE-CVE-2025-0001",
+  "summary": "musl overflow",
+  "database_specific": {"severity": "HIGH"},
+  "affected": [{
+    "package": {"ecosystem": "Alpine:v3.19", "name": "musl"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.2.4-r3"}]}]
+  }]
+}`
+
+func scanMock(t *testing.T, times int) *MockImageAPIClient {
+	layer := tarOf(t, map[string]string{"lib/apk/db/installed": "P:musl\nV:1.2.4-r2\nA:x86_64\n"})
+	saved := tarOf(t, map[string]string{
+		"abc/layer.tar": string(layer),
+		"manifest.json": `[{"Config":"def.json","RepoTags":["docker.io/library/alpine:latest"],"Layers":["abc/layer.tar"]}]`,
+	})
+
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/alpine:latest", gomock.Any()).Return(mockProgessReader(), nil).Times(times)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"docker.io/library/alpine:latest"}).DoAndReturn(func(context.Context, []string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(saved)), nil
+	}).Times(times)
+	return mc
+}
+
+func testVulnDB(t *testing.T) *vuln.DB {
+	db := vuln.NewDB()
+	assert.NoError(t, db.Load(strings.NewReader(muslAdvisory)))
+	return db
+}
+
+func TestGetTarWithScan(t *testing.T) {
+	subject := NewServer(ServerOpts{DockerClient: scanMock(t, 1), VulnDB: testVulnDB(t)})
+	params := url.Values{"image": {"alpine"}, "scan": {"true"}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	files := tarFiles(t, rec.Body.Bytes())
+	var report vuln.Report
+	assert.NoError(t, json.Unmarshal(files["scans/docker.io_library_alpine_latest.json"], &report))
+	if assert.Len(t, report.Vulnerabilities, 1) {
+		assert.Equal(t, "ALPINE-CVE-2025-0001", report.Vulnerabilities[0].ID)
+		assert.Equal(t, "1.2.4-r3", report.Vulnerabilities[0].FixedVersion)
+		assert.Equal(t, vuln.SeverityHigh, report.Vulnerabilities[0].Severity)
+	}
+}
+
+func TestGetTarScanGate(t *testing.T) {
+	subject := NewServer(ServerOpts{DockerClient: scanMock(t, 2), VulnDB: testVulnDB(t), FailOn: vuln.SeverityCritical})
+
+	// the server threshold is not reached
+	req := httptest.NewRequest(http.MethodGet, "/tar?image=alpine", nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "docker.io/library/alpine:latest", rec.Header().Get(HeaderImages))
+	assert.NotEmpty(t, rec.Header().Get(echo.HeaderContentLength))
+	assert.Contains(t, tarFiles(t, rec.Body.Bytes()), "scans/docker.io_library_alpine_latest.json")
+
+	// the request lowers the threshold
+	req = httptest.NewRequest(http.MethodGet, "/tar?image=alpine&fail-on=high", nil)
+	rec = httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Empty(t, rec.Header().Get(HeaderImages))
+	var failure scanFailure
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &failure))
+	assert.Equal(t, "1 image(s) contain vulnerabilities of severity high or higher or could not be scanned", failure.Message)
+	if assert.Len(t, failure.Images, 1) {
+		assert.Equal(t, "docker.io/library/alpine:latest", failure.Images[0].Image)
+	}
+
+	expectResponseCode(t, subject, "/tar?image=alpine&fail-on=severe", http.StatusBadRequest)
+}
+
+func TestGetTarScanNotConfigured(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	subject := NewServer(ServerOpts{DockerClient: NewMockImageAPIClient(ctrl)})
+	expectResponseCode(t, subject, "/tar?image=alpine&scan=true", http.StatusBadRequest)
+}
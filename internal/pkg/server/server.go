@@ -1,20 +1,35 @@
 package server
 
 import (
-	"bufio"
-	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bastjan/saveomat/internal/pkg/auth"
-	"github.com/docker/docker/api/types"
+	"github.com/bastjan/saveomat/internal/pkg/bundle"
+	"github.com/bastjan/saveomat/internal/pkg/cosign"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/lists"
+	"github.com/bastjan/saveomat/internal/pkg/mirror"
+	"github.com/bastjan/saveomat/internal/pkg/registry"
+	"github.com/bastjan/saveomat/internal/pkg/s3"
+	"github.com/bastjan/saveomat/internal/pkg/split"
+	"github.com/bastjan/saveomat/internal/pkg/vuln"
+	"github.com/bastjan/saveomat/internal/pkg/webhook"
 	"github.com/docker/docker/client"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"golang.org/x/sync/errgroup"
 )
 
 //go:embed public/*
@@ -23,22 +38,140 @@ var publicContent embed.FS
 type ServerOpts struct {
 	BaseURL      string
 	DockerClient client.ImageAPIClient
+	// Mirrors are tried before pulling from the original registry.
+	Mirrors mirror.Rules
+	// RegistryClient resolves images without pulling them.
+	// Defaults to registry.NewClient().
+	RegistryClient *registry.Client
+	// SpoolDir enables spooling archives to disk before sending them.
+	// Spooled archives are sent with a Content-Length and support range
+	// requests.
+	SpoolDir string
+	// SigningKey signs the checksums added to archives. Archives always
+	// contain checksums if a key is set.
+	SigningKey ed25519.PrivateKey
+	// CosignKeys enables signature verification. Only images signed by one
+	// of the keys are bundled.
+	CosignKeys []crypto.PublicKey
+	// VulnDB enables vulnerability scans of the bundled images.
+	VulnDB *vuln.DB
+	// FailOn rejects archives containing vulnerabilities of at least this
+	// severity. Requires VulnDB.
+	FailOn vuln.Severity
+	// ObjectStorage enables uploading archives instead of sending them.
+	ObjectStorage *s3.Client
+	// ObjectPrefix is prepended to the keys of uploaded archives.
+	ObjectPrefix string
+	// PresignExpiry is the validity of the download URLs of uploaded
+	// archives. Defaults to 24 hours.
+	PresignExpiry time.Duration
+	// Webhooks are notified of every archive in addition to the webhooks
+	// given per request.
+	Webhooks []string
+	// WebhookSecret signs the events sent to Webhooks. Events sent to the
+	// webhooks given per request are not signed.
+	WebhookSecret []byte
+	// ListsDir enables storing image lists and their archives on the
+	// server.
+	ListsDir string
+	// ListsAuth authenticates pulls for archives of stored lists.
+	ListsAuth auth.Authenticator
+	// ListsToken is required as bearer token for the lists routes if set.
+	ListsToken string
+	// PushLimit is the maximum size in bytes of uploads to /push. Defaults
+	// to DefaultPushLimit.
+	PushLimit int64
 }
 
+// DefaultPushLimit is the default maximum size of uploads to /push.
+const DefaultPushLimit = 4 << 30
+
+const (
+	// HeaderImages lists the images contained in an archive.
+	HeaderImages = "X-Saveomat-Images"
+	// HeaderSHA256 holds the hex encoded SHA-256 of the whole archive. It is
+	// sent as a trailer if the archive is streamed.
+	HeaderSHA256 = "X-Saveomat-Sha256"
+)
+
 type Server struct {
 	*echo.Echo
-	DockerClient client.ImageAPIClient
+	DockerClient   client.ImageAPIClient
+	Mirrors        mirror.Rules
+	RegistryClient *registry.Client
+	SpoolDir       string
+	SigningKey     ed25519.PrivateKey
+	// Verifier verifies image signatures if set.
+	Verifier *cosign.Verifier
+	VulnDB   *vuln.DB
+	FailOn   vuln.Severity
+	// ObjectStorage receives archives requested with output=s3.
+	ObjectStorage *s3.Client
+	ObjectPrefix  string
+	PresignExpiry time.Duration
+	Webhooks      []string
+	WebhookSender *webhook.Sender
+	// RequestWebhookSender notifies the webhooks given in requests. It does
+	// not sign the events and only connects to public addresses.
+	RequestWebhookSender *webhook.Sender
+	// Lists stores image lists if set.
+	Lists      *lists.Store
+	ListsAuth  auth.Authenticator
+	ListsToken string
+	PushLimit  int64
+
+	buildingMu sync.Mutex
+	// building are the names of the lists currently being built.
+	building map[string]bool
 }
 
 func NewServer(opt ServerOpts) *Server {
 	e := echo.New()
-	s := &Server{e, opt.DockerClient}
+	s := &Server{
+		Echo:                 e,
+		DockerClient:         opt.DockerClient,
+		Mirrors:              opt.Mirrors,
+		RegistryClient:       opt.RegistryClient,
+		SpoolDir:             opt.SpoolDir,
+		SigningKey:           opt.SigningKey,
+		VulnDB:               opt.VulnDB,
+		FailOn:               opt.FailOn,
+		ObjectStorage:        opt.ObjectStorage,
+		ObjectPrefix:         opt.ObjectPrefix,
+		PresignExpiry:        opt.PresignExpiry,
+		Webhooks:             opt.Webhooks,
+		WebhookSender:        &webhook.Sender{Secret: opt.WebhookSecret},
+		RequestWebhookSender: &webhook.Sender{PublicOnly: true},
+		ListsAuth:            opt.ListsAuth,
+		ListsToken:           opt.ListsToken,
+		PushLimit:            opt.PushLimit,
+	}
+	if s.RegistryClient == nil {
+		s.RegistryClient = registry.NewClient()
+	}
+	if opt.ListsDir != "" {
+		s.Lists = &lists.Store{Dir: opt.ListsDir}
+	}
+	if s.ListsAuth == nil {
+		s.ListsAuth = auth.EmptyAuthenticator
+	}
+	if s.PushLimit <= 0 {
+		s.PushLimit = DefaultPushLimit
+	}
+	if len(opt.CosignKeys) > 0 {
+		s.Verifier = &cosign.Verifier{Client: s.RegistryClient, Keys: opt.CosignKeys}
+	}
+
+	baseurl := strings.TrimSuffix(opt.BaseURL, "/")
 
+	// Uploads to /push are limited to PushLimit instead.
+	pushRoutes := map[string]bool{baseurl + "/push": true, baseurl + APIPrefix + "/push": true}
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
-	e.Use(middleware.BodyLimit("512K"))
-
-	baseurl := strings.TrimSuffix(opt.BaseURL, "/")
+	e.Use(middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+		Skipper: func(c echo.Context) bool { return pushRoutes[c.Path()] },
+		Limit:   "512K",
+	}))
 
 	// Redirect /base -> /base/
 	if baseurl != "" {
@@ -48,119 +181,327 @@ func NewServer(opt ServerOpts) *Server {
 	}
 
 	g := e.Group(baseurl)
+	s.routes(g)
+	v1 := g.Group(APIPrefix)
+	v1.GET("/openapi.json", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, openAPIDocument)
+	})
+	s.routes(v1, validateRequest(baseurl+APIPrefix))
+	// Static files for web gui
+	g.GET("/*", echo.WrapHandler(http.FileServer(http.FS(publicContent))), middleware.Rewrite(map[string]string{baseurl + "/*": "/public/$1"}))
+
+	return s
+}
+
+// routes registers the API on the group. mw are applied to every route.
+func (s *Server) routes(g *echo.Group, mw ...echo.MiddlewareFunc) {
 	g.POST("/tar", func(c echo.Context) error {
 		err := s.postTar(c)
 		if err != nil {
 			return dockerToEchoErrorMapping(err)
 		}
 		return nil
-	})
+	}, mw...)
 	g.GET("/tar", func(c echo.Context) error {
 		err := s.getTar(c)
 		if err != nil {
 			return dockerToEchoErrorMapping(err)
 		}
 		return nil
-	})
-	// Static files for web gui
-	g.GET("/*", echo.WrapHandler(http.FileServer(http.FS(publicContent))), middleware.Rewrite(map[string]string{baseurl + "/*": "/public/$1"}))
-
-	return s
+	}, mw...)
+	g.POST("/plan", func(c echo.Context) error {
+		err := s.postPlan(c)
+		if err != nil {
+			return dockerToEchoErrorMapping(err)
+		}
+		return nil
+	}, mw...)
+	g.GET("/plan", func(c echo.Context) error {
+		err := s.getPlan(c)
+		if err != nil {
+			return dockerToEchoErrorMapping(err)
+		}
+		return nil
+	}, mw...)
+	g.POST("/push", func(c echo.Context) error {
+		err := s.postPush(c)
+		if err != nil {
+			return dockerToEchoErrorMapping(err)
+		}
+		return nil
+	}, append([]echo.MiddlewareFunc{middleware.BodyLimit(strconv.FormatInt(s.PushLimit, 10))}, mw...)...)
+	if s.Lists != nil {
+		// The archives of lists may contain images pulled with ListsAuth.
+		mw := append([]echo.MiddlewareFunc{s.requireListsToken}, mw...)
+		g.GET("/lists", func(c echo.Context) error {
+			return s.getLists(c)
+		}, mw...)
+		g.GET("/lists/:name", func(c echo.Context) error {
+			return s.getList(c)
+		}, mw...)
+		g.PUT("/lists/:name", func(c echo.Context) error {
+			return s.putList(c)
+		}, mw...)
+		g.DELETE("/lists/:name", func(c echo.Context) error {
+			return s.deleteList(c)
+		}, mw...)
+		g.GET("/lists/:name/archives", func(c echo.Context) error {
+			return s.getListArchives(c)
+		}, mw...)
+		g.POST("/lists/:name/archives", func(c echo.Context) error {
+			err := s.postListArchive(c)
+			if err != nil {
+				return dockerToEchoErrorMapping(err)
+			}
+			return nil
+		}, mw...)
+		g.GET("/lists/:name/archives/:id", func(c echo.Context) error {
+			return s.getListArchive(c)
+		}, mw...)
+		g.GET("/lists/:name/latest.tar", func(c echo.Context) error {
+			return s.getListArchive(c)
+		}, mw...)
+	}
 }
 
 func (s *Server) getTar(c echo.Context) error {
-	p := c.QueryParams()
-	images, ok := p["image"]
-	if !ok {
-		return c.NoContent(http.StatusBadRequest)
+	images, err := imagesFromQuery(c)
+	if err != nil {
+		return err
 	}
 
-	return s.streamImages(c, auth.EmptyAuthenticator, normalizeImages(images))
+	return s.streamImages(c, auth.EmptyAuthenticator, images)
 }
 
 func (s *Server) postTar(c echo.Context) error {
-	file, err := c.FormFile("images.txt")
+	var images []imagelist.Entry
+	var authn auth.Authenticator
+	var err error
+	switch mt := mediaType(c); mt {
+	case echo.MIMEApplicationJSON:
+		images, authn, err = imagesFromJSON(c)
+	case echo.MIMEMultipartForm:
+		images, authn, err = imagesFromPost(c)
+	default:
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported content type %q, expected %s or %s", mt, echo.MIMEApplicationJSON, echo.MIMEMultipartForm))
+	}
 	if err != nil {
 		return err
 	}
-	src, err := file.Open()
+
+	return s.streamImages(c, authn, images)
+}
+
+// streamImages sends or uploads the archive of the images and notifies the
+// webhooks of the outcome.
+func (s *Server) streamImages(c echo.Context, pullAuth auth.Authenticator, images []imagelist.Entry) error {
+	hooks, err := s.requestWebhooks(c)
 	if err != nil {
 		return err
 	}
-	defer src.Close()
+	start := time.Now()
+	var res bundleResult
+	err = s.writeBundle(c, pullAuth, images, &res)
+	if len(s.Webhooks) > 0 || len(hooks) > 0 {
+		ev := res.event(images, time.Since(start), err)
+		s.notify(s.WebhookSender, s.Webhooks, ev)
+		s.notify(s.RequestWebhookSender, hooks, ev)
+	}
+	return err
+}
 
-	authn, err := authFromFormFile(c, "config.json")
+// writeBundle sends or uploads the archive of the images and records the
+// outcome in res.
+func (s *Server) writeBundle(c echo.Context, pullAuth auth.Authenticator, images []imagelist.Entry, res *bundleResult) error {
+	if len(images) == 0 {
+		res.failure = "no images requested"
+		return c.NoContent(http.StatusBadRequest)
+	}
+	opts, err := bundleOptionsFrom(c)
 	if err != nil {
 		return err
 	}
 
-	images := make([]string, 0, 5)
-	sc := bufio.NewScanner(src)
-	for sc.Scan() {
-		images = append(images, sc.Text())
-	}
-	if sc.Err() != nil {
-		return sc.Err()
+	if err := s.checkOptions(opts); err != nil {
+		return err
 	}
+	threshold := s.bundler().Threshold(opts.FailOn)
 
-	return s.streamImages(c, authn, normalizeImages(images))
-}
+	b, err := s.bundler().Build(c.Request().Context(), pullAuth, images, opts.Options)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	res.images, res.digests = b.Images, b.Digests
 
-func normalizeImages(images []string) []string {
-	normalized := make([]string, 0, len(images))
-	for _, img := range images {
-		img = strings.Trim(img, " ")
-		if img == "" || strings.HasPrefix(img, "#") {
-			continue
+	h := c.Response().Header()
+	setHeaders := func() {
+		h.Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, b.Filename))
+		h.Set(HeaderImages, strings.Join(b.Images, ","))
+		h.Set(echo.HeaderContentType, b.ContentType)
+	}
+	digest := sha256.New()
+	spoolDir := s.SpoolDir
+	if threshold != vuln.SeverityUnknown && spoolDir == "" {
+		// The archive must be complete before deciding whether to send it.
+		spoolDir = os.TempDir()
+	}
+	if spoolDir == "" {
+		if opts.output == outputS3 {
+			return s.export(c, b, b.Filename, b.ContentType, res)
+		}
+		setHeaders()
+		h.Set("Trailer", HeaderSHA256)
+		c.Response().WriteHeader(http.StatusOK)
+		n, err := io.Copy(c.Response(), io.TeeReader(b, digest))
+		if err != nil {
+			return err
 		}
-		normalized = append(normalized, img)
+		res.size, res.sha256 = n, hex.EncodeToString(digest.Sum(nil))
+		h.Set(HeaderSHA256, res.sha256)
+		return nil
 	}
-	return normalized
-}
 
-func (s *Server) streamImages(c echo.Context, pullAuth auth.Authenticator, images []string) error {
-	if len(images) == 0 {
-		return c.NoContent(http.StatusBadRequest)
+	f, err := bundle.Spool(spoolDir, io.TeeReader(b, digest))
+	if err != nil {
+		return err
 	}
-
-	tar, err := s.pullAndSaveImages(c.Request().Context(), pullAuth, images)
+	defer bundle.CleanupSpool(f)
+	if threshold != vuln.SeverityUnknown {
+		if failed := bundle.FailedReports(b.Reports, threshold); len(failed) > 0 {
+			res.failure = fmt.Sprintf("%d image(s) contain vulnerabilities of severity %s or higher or could not be scanned", len(failed), threshold)
+			return c.JSON(http.StatusUnprocessableEntity, scanFailure{
+				Message: res.failure,
+				Images:  failed,
+			})
+		}
+	}
+	if opts.output == outputS3 {
+		return s.export(c, f, b.Filename, b.ContentType, res)
+	}
+	fi, err := f.Stat()
 	if err != nil {
 		return err
 	}
-	defer tar.Close()
+	res.size, res.sha256 = fi.Size(), hex.EncodeToString(digest.Sum(nil))
+	setHeaders()
+	h.Set(HeaderSHA256, res.sha256)
+	// Ranges are not supported: a repeated request rebuilds the archive,
+	// which is not guaranteed to be identical.
+	h.Set(echo.HeaderContentLength, strconv.FormatInt(res.size, 10))
+	c.Response().WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Response(), f)
+	return err
+}
+
+const (
+	// outputDownload sends the archive in the response.
+	outputDownload = "download"
+	// outputS3 uploads the archive to the object storage.
+	outputS3 = "s3"
+)
 
-	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="images.tar"`)
-	return c.Stream(http.StatusOK, "application/x-tar", tar)
+// bundleOptions are the per-request options for creating an archive.
+type bundleOptions struct {
+	bundle.Options
+	// output is either outputDownload or outputS3.
+	output string
 }
 
-func (s *Server) pullAndSaveImages(ctx context.Context, authn auth.Authenticator, images []string) (io.ReadCloser, error) {
+// params are request parameters. echo.Context implements it.
+type params interface {
+	FormValue(name string) string
+}
 
-	g, errCtx := errgroup.WithContext(ctx)
+// bundleOptionsFrom parses the options of the request.
+func bundleOptionsFrom(c echo.Context) (bundleOptions, error) {
+	opts, err := parseBundleOptions(c)
+	if err != nil {
+		return opts, err
+	}
+	opts.Have, err = haveFrom(c)
+	return opts, err
+}
 
-	for _, img := range images {
-		img := img
-		g.Go(func() error {
-			encodedAuth, err := auth.RegistryAuthFor(authn, img)
-			if err != nil {
-				return err
-			}
-			rc, err := s.DockerClient.ImagePull(errCtx, img, types.ImagePullOptions{
-				RegistryAuth: encodedAuth,
-			})
-			if err != nil {
-				return err
-			}
-			defer rc.Close()
-			io.Copy(os.Stdout, rc)
-			return nil
-		})
+func parseBundleOptions(c params) (bundleOptions, error) {
+	opts := bundleOptions{output: outputDownload}
+	opts.Format = c.FormValue("format")
+	if opts.Format == "" {
+		opts.Format = bundle.FormatDocker
+	}
+	opts.Compression = c.FormValue("compression")
+	opts.SBOM = c.FormValue("sbom")
 
+	var err error
+	if opts.Checksums, err = boolParam(c, "checksums"); err != nil {
+		return opts, err
 	}
+	if opts.Referrers, err = boolParam(c, "referrers"); err != nil {
+		return opts, err
+	}
+	if opts.Scan, err = boolParam(c, "scan"); err != nil {
+		return opts, err
+	}
+	if err := opts.Validate(); err != nil {
+		return opts, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if v := c.FormValue("fail-on"); v != "" {
+		if opts.FailOn, err = vuln.ParseSeverity(v); err != nil {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if v := c.FormValue("output"); v != "" {
+		if v != outputDownload && v != outputS3 {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid output %q, expected %q or %q", v, outputDownload, outputS3))
+		}
+		opts.output = v
+	}
+	if v := c.FormValue("split"); v != "" {
+		if opts.Split, err = split.ParseSize(v); err != nil {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	return opts, nil
+}
 
-	if err := g.Wait(); err != nil {
-		return nil, err
+// checkOptions rejects options requiring features the server is not
+// configured for.
+func (s *Server) checkOptions(opts bundleOptions) error {
+	if err := s.bundler().Check(opts.Options); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if opts.output == outputS3 && s.ObjectStorage == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "object storage is not configured")
+	}
+	return nil
+}
+
+// bundler builds archives with the server's configuration.
+func (s *Server) bundler() *bundle.Bundler {
+	return &bundle.Bundler{
+		DockerClient:   s.DockerClient,
+		Mirrors:        s.Mirrors,
+		RegistryClient: s.RegistryClient,
+		Verifier:       s.Verifier,
+		SigningKey:     s.SigningKey,
+		VulnDB:         s.VulnDB,
+		FailOn:         s.FailOn,
+		SpoolDir:       s.SpoolDir,
+		Progress:       os.Stdout,
+		Logger:         s.Logger,
 	}
-	return s.DockerClient.ImageSave(ctx, images)
+}
+
+func boolParam(c params, name string) (bool, error) {
+	v := c.FormValue(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s parameter %q", name, v))
+	}
+	return b, nil
 }
 
 func authFromFormFile(c echo.Context, filename string) (auth.Authenticator, error) {
@@ -178,9 +519,35 @@ func authFromFormFile(c echo.Context, filename string) (auth.Authenticator, erro
 	return auth.FromReader(authSrc)
 }
 
+// imageListErrorResponse points at the invalid lines of an image list.
+type imageListErrorResponse struct {
+	Message string           `json:"message"`
+	Errors  imagelist.Errors `json:"errors"`
+}
+
+func badImageList(err error) error {
+	var errs imagelist.Errors
+	if errors.As(err, &errs) {
+		return echo.NewHTTPError(http.StatusBadRequest, imageListErrorResponse{
+			Message: "invalid image list",
+			Errors:  errs,
+		})
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
+
 func dockerToEchoErrorMapping(err error) *echo.HTTPError {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he
+	}
+
 	var status int
 	switch {
+	case errors.Is(err, bundle.ErrNoImages):
+		status = http.StatusNotFound
+	case errors.Is(err, cosign.ErrNoValidSignature):
+		status = http.StatusForbidden
 	case strings.Contains(err.Error(), "forbidden"):
 		status = http.StatusForbidden
 	case strings.Contains(err.Error(), "not found"):
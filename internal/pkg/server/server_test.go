@@ -4,18 +4,31 @@ package server
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/bastjan/saveomat/internal/pkg/archive"
 	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/mirror"
+	"github.com/bastjan/saveomat/internal/pkg/split"
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/golang/mock/gomock"
 	"github.com/labstack/echo/v4"
@@ -47,6 +60,29 @@ func TestBaseURL(t *testing.T) {
 	expectResponseCode(t, subject, "/sub/", http.StatusOK)
 }
 
+func TestBodyLimit(t *testing.T) {
+	// The mock fails the test on any pull or load
+	subject := NewServer(ServerOpts{DockerClient: NewMockImageAPIClient(gomock.NewController(t)), ListsDir: t.TempDir(), BaseURL: "/sub"})
+	body := strings.Repeat("x", 600<<10)
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/sub/tar"},
+		{http.MethodGet, "/sub/tar"},
+		{http.MethodPost, "/sub/api/v1/plan"},
+		{http.MethodPost, "/sub/lists/base/archives"},
+		{http.MethodDelete, "/sub/lists/base"},
+		{http.MethodGet, "/sub/api/v1/openapi.json"},
+		{http.MethodGet, "/sub/index.html"},
+	} {
+		rec := doRequest(subject, route.method, route.path, body)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "%s %s", route.method, route.path)
+	}
+	for _, path := range []string{"/sub/push", "/sub/api/v1/push"} {
+		rec := doRequest(subject, http.MethodPost, path, body)
+		assert.NotEqual(t, http.StatusRequestEntityTooLarge, rec.Code, path)
+	}
+}
+
 func expectResponseCode(t *testing.T, handler http.Handler, path string, code int) {
 	t.Helper()
 
@@ -137,6 +173,590 @@ func TestGetTar(t *testing.T) {
 	assert.Equal(t, expected, responseTar)
 }
 
+func TestGetTarHeaders(t *testing.T) {
+	images := []string{"busybox", "open.io/busybox"}
+	params := url.Values{"image": images}.Encode()
+
+	subject := NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+	})
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "docker.io/library/busybox:latest,open.io/busybox:latest", rec.Header().Get(HeaderImages))
+	assert.Empty(t, rec.Header().Get(echo.HeaderContentLength))
+
+	spoolDir := t.TempDir()
+	subject = NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+		SpoolDir:     spoolDir,
+	})
+	req = httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec = httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "docker.io/library/busybox:latest,open.io/busybox:latest", rec.Header().Get(HeaderImages))
+	assert.Equal(t, strconv.Itoa(len(mockTarBytes(t))), rec.Header().Get(echo.HeaderContentLength))
+	assert.Equal(t, "application/x-tar", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+	assert.Equal(t, sha256Hex(mockTarBytes(t)), rec.Header().Get(HeaderSHA256))
+
+	// Rebuilt archives may differ, so ranges are not served.
+	subject = NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+		SpoolDir:     spoolDir,
+	})
+	req = httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	req.Header.Set("Range", "bytes=10-")
+	rec = httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Accept-Ranges"))
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+
+	spooled, err := ioutil.ReadDir(spoolDir)
+	assert.NoError(t, err)
+	assert.Empty(t, spooled, "spooled archives are removed")
+}
+
+func TestGetTarChecksums(t *testing.T) {
+	images := []string{"busybox"}
+	pub, key, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	subject := NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+	})
+	params := url.Values{"image": images}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes(), "archives are unchanged without checksums")
+	assert.Equal(t, sha256Hex(mockTarBytes(t)), rec.Result().Trailer.Get(HeaderSHA256))
+
+	subject = NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+	})
+	params = url.Values{"image": images, "checksums": {"true"}}.Encode()
+	req = httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec = httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res, err := archive.Verify(bytes.NewReader(rec.Body.Bytes()), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Files)
+	assert.False(t, res.Signed)
+	assert.Equal(t, sha256Hex(rec.Body.Bytes()), rec.Result().Trailer.Get(HeaderSHA256))
+
+	subject = NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+		SigningKey:   key,
+	})
+	params = url.Values{"image": images}.Encode()
+	req = httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec = httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res, err = archive.Verify(bytes.NewReader(rec.Body.Bytes()), pub)
+	assert.NoError(t, err)
+	assert.True(t, res.Signed)
+
+	subject = NewServer(ServerOpts{})
+	params = url.Values{"image": images, "checksums": {"maybe"}}.Encode()
+	expectResponseCode(t, subject, "/tar?"+params, http.StatusBadRequest)
+}
+
+func TestGetTarCompressed(t *testing.T) {
+	images := []string{"busybox"}
+	subject := NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+	})
+	params := url.Values{"image": images, "compression": {"gzip"}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/gzip", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, `attachment; filename="images.tar.gz"`, rec.Header().Get(echo.HeaderContentDisposition))
+	assert.Equal(t, sha256Hex(rec.Body.Bytes()), rec.Result().Trailer.Get(HeaderSHA256))
+	zr, err := gzip.NewReader(rec.Body)
+	if assert.NoError(t, err) {
+		b, err := ioutil.ReadAll(zr)
+		assert.NoError(t, err)
+		assert.Equal(t, mockTarBytes(t), b)
+	}
+
+	subject = NewServer(ServerOpts{})
+	params = url.Values{"image": images, "compression": {"zstd"}}.Encode()
+	expectResponseCode(t, subject, "/tar?"+params, http.StatusBadRequest)
+}
+
+func TestGetTarWithSBOM(t *testing.T) {
+	layer := tarOf(t, map[string]string{"lib/apk/db/installed": "P:musl\nV:1.2.4-r2\nA:x86_64\n"})
+	saved := tarOf(t, map[string]string{
+		"abc/layer.tar": string(layer),
+		"manifest.json": `[{"Config":"def.json","RepoTags":["docker.io/library/alpine:latest"],"Layers":["abc/layer.tar"]}]`,
+	})
+
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/alpine:latest", gomock.Any()).Return(mockProgessReader(), nil)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"docker.io/library/alpine:latest"}).Return(ioutil.NopCloser(bytes.NewReader(saved)), nil)
+
+	subject := NewServer(ServerOpts{DockerClient: mc})
+	params := url.Values{"image": {"alpine"}, "sbom": {"cyclonedx"}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	files := tarFiles(t, rec.Body.Bytes())
+	assert.Equal(t, layer, files["abc/layer.tar"])
+	assert.Contains(t, string(files["sboms/docker.io_library_alpine_latest.cdx.json"]), "pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64")
+
+	params = url.Values{"image": {"alpine"}, "sbom": {"swid"}}.Encode()
+	expectResponseCode(t, subject, "/tar?"+params, http.StatusBadRequest)
+}
+
+func TestGetTarSplit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	mc.EXPECT().ImagePull(gomock.Any(), "docker.io/library/busybox:latest", gomock.Any()).Return(mockProgessReader(), nil)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{"docker.io/library/busybox:latest"}).Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{DockerClient: mc, SpoolDir: t.TempDir()})
+	req := httptest.NewRequest(http.MethodGet, "/tar?image=busybox&split=1K", nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/zip", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, `attachment; filename="images.zip"`, rec.Header().Get(echo.HeaderContentDisposition))
+	assert.Equal(t, sha256Hex(rec.Body.Bytes()), rec.Header().Get(HeaderSHA256))
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	assert.NoError(t, err)
+	joined := new(bytes.Buffer)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if strings.HasSuffix(f.Name, split.ManifestSuffix) {
+			continue
+		}
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		io.Copy(joined, rc)
+		rc.Close()
+	}
+	assert.Equal(t, []string{"images.tar.000", "images.tar.001", "images.tar.parts.json"}, names)
+	assert.Equal(t, mockTarBytes(t), joined.Bytes())
+
+	expectResponseCode(t, subject, "/tar?image=busybox&split=1X", http.StatusBadRequest)
+}
+
+// tarOf creates a tar archive of the files in name order.
+func tarOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := new(bytes.Buffer)
+	tw := tar.NewWriter(b)
+	for _, name := range names {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(files[name])), Mode: 0644}))
+		_, err := tw.Write([]byte(files[name]))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	return b.Bytes()
+}
+
+func sha256Hex(b []byte) string {
+	d := sha256.Sum256(b)
+	return hex.EncodeToString(d[:])
+}
+
+func TestGetTarWithMirror(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+
+	emptyAuth, err := auth.RegistryAuthFor(auth.EmptyAuthenticator, "busybox")
+	assert.NoError(t, err)
+
+	// busybox is available on the mirror and retagged
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "harbor.internal/dockerhub/library/busybox:latest", types.ImagePullOptions{RegistryAuth: emptyAuth}).
+		Return(mockProgessReader(), nil)
+	mc.EXPECT().
+		ImageTag(gomock.Any(), "harbor.internal/dockerhub/library/busybox:latest", "docker.io/library/busybox:latest").
+		Return(nil)
+	// quay.io/foo/bar is missing on the mirror and pulled from quay.io
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "harbor.internal/quay/foo/bar:latest", types.ImagePullOptions{RegistryAuth: emptyAuth}).
+		Return(ioutil.NopCloser(strings.NewReader(`{"errorDetail":{"message":"not found"},"error":"not found"}`)), nil)
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "quay.io/foo/bar:latest", types.ImagePullOptions{RegistryAuth: emptyAuth}).
+		Return(mockProgessReader(), nil)
+	// open.io is not mirrored
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "open.io/busybox:latest", types.ImagePullOptions{RegistryAuth: emptyAuth}).
+		Return(mockProgessReader(), nil)
+
+	images := []string{"busybox", "quay.io/foo/bar", "open.io/busybox"}
+	mc.EXPECT().
+		ImageSave(gomock.Any(), []string{"docker.io/library/busybox:latest", "quay.io/foo/bar:latest", "open.io/busybox:latest"}).
+		Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{
+		DockerClient: mc,
+		Mirrors: mirror.Rules{
+			{Source: "docker.io", Mirror: "harbor.internal/dockerhub"},
+			{Source: "quay.io", Mirror: "harbor.internal/quay"},
+		},
+	})
+
+	params := url.Values{"image": images}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestPostTarWithMirrorCredentials(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+
+	authn, err := auth.FromReader(strings.NewReader(testAuthConf))
+	assert.NoError(t, err)
+	testAuth, err := auth.RegistryAuthFor(authn, "test.io/busybox")
+	assert.NoError(t, err)
+
+	// The mirror gets the credentials of the original registry
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "harbor.internal/test/busybox:latest", types.ImagePullOptions{RegistryAuth: testAuth}).
+		Return(mockProgessReader(), nil)
+	mc.EXPECT().
+		ImageTag(gomock.Any(), "harbor.internal/test/busybox:latest", "test.io/busybox:latest").
+		Return(nil)
+	mc.EXPECT().
+		ImageSave(gomock.Any(), []string{"test.io/busybox:latest"}).
+		Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{
+		DockerClient: mc,
+		Mirrors:      mirror.Rules{{Source: "test.io", Mirror: "harbor.internal/test"}},
+	})
+
+	rec := postFiles(t, subject, "/tar", formFile{"images.txt", "test.io/busybox\n"}, formFile{"config.json", testAuthConf})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestPostTarWithRetag(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+
+	emptyAuth, err := auth.RegistryAuthFor(auth.EmptyAuthenticator, "busybox")
+	assert.NoError(t, err)
+
+	for img, target := range map[string]string{
+		"docker.io/library/nginx:1.25":     "registry.airgap.local/mirror/nginx:1.25",
+		"docker.io/library/busybox:latest": "registry.airgap.local/busybox:latest",
+		"quay.io/foo/bar:latest":           "registry.airgap.local/foo/bar:latest",
+	} {
+		mc.EXPECT().
+			ImagePull(gomock.Any(), img, types.ImagePullOptions{RegistryAuth: emptyAuth}).
+			Return(mockProgessReader(), nil)
+		mc.EXPECT().
+			ImageTag(gomock.Any(), img, target).
+			Return(nil)
+	}
+	mc.EXPECT().
+		ImageSave(gomock.Any(), []string{"registry.airgap.local/mirror/nginx:1.25", "registry.airgap.local/busybox:latest", "registry.airgap.local/foo/bar:latest"}).
+		Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{DockerClient: mc})
+
+	upload := new(bytes.Buffer)
+	mpw := multipart.NewWriter(upload)
+	fw, err := mpw.CreateFormFile("images.txt", "images.txt")
+	assert.NoError(t, err)
+	fw.Write([]byte("nginx:1.25 => registry.airgap.local/mirror/nginx:1.25\nbusybox\nquay.io/foo/bar\n"))
+	assert.NoError(t, mpw.WriteField("retag-prefix", "registry.airgap.local/"))
+	mpw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/tar", upload)
+	req.Header.Set(echo.HeaderContentType, mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestPostTarWithCompose(t *testing.T) {
+	images := []string{"busybox", "registry.example.com/app:v1", "postgres:15", "nginx:1.25-alpine"}
+
+	subject := NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+	})
+
+	rec := postFiles(t, subject, "/tar",
+		formFile{"images.txt", "busybox"},
+		formFile{"docker-compose.yml", "services:\n  app:\n    image: ${REGISTRY}/app:${TAG:-latest}\n  web:\n    image: nginx:1.25\n"},
+		formFile{"docker-compose.yml", "services:\n  web:\n    image: nginx:1.25-alpine\n  db:\n    image: postgres:15\n"},
+		formFile{".env", "REGISTRY=registry.example.com\nTAG=v1\n"},
+	)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestPostTarWithManifests(t *testing.T) {
+	images := []string{"registry.example.com/app-migrations:v1", "registry.example.com/app:v1", "redis:7"}
+
+	subject := NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, images, nil),
+	})
+
+	rec := postFiles(t, subject, "/tar",
+		formFile{"manifests.yaml", "kind: Deployment\nspec:\n  template:\n    spec:\n      initContainers:\n      - image: registry.example.com/app-migrations:v1\n      containers:\n      - image: registry.example.com/app:v1\n"},
+		formFile{"manifests.yaml", "kind: Pod\nspec:\n  containers:\n  - image: redis:7\n"},
+	)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestGetTarCollapsesDuplicates(t *testing.T) {
+	subject := NewServer(ServerOpts{
+		DockerClient: dockerMockFor(t, []string{"busybox"}, nil),
+	})
+
+	params := url.Values{"image": []string{"busybox", "docker.io/library/busybox:latest", "index.docker.io/busybox"}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestPostTarValidatesBeforePulling(t *testing.T) {
+	// The mock fails the test on any pull
+	subject := NewServer(ServerOpts{
+		DockerClient: NewMockImageAPIClient(gomock.NewController(t)),
+	})
+
+	rec := postFiles(t, subject, "/tar",
+		formFile{"images.txt", "busybox\nBusybox\nalpine => registry.airgap.local/alpine@sha256:0000000000000000000000000000000000000000000000000000000000000000\n"},
+		formFile{"manifests.yaml", "kind: Pod\nspec:\n  containers:\n  - image: nginx\n  - image: Nginx\n"},
+	)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{
+		"message": "invalid image list",
+		"errors": [
+			{"file": "images.txt", "line": 2, "entry": "Busybox", "message": "invalid reference \"Busybox\": invalid reference format: repository name must be lowercase"},
+			{"file": "images.txt", "line": 3, "entry": "alpine => registry.airgap.local/alpine@sha256:0000000000000000000000000000000000000000000000000000000000000000", "message": "invalid target \"registry.airgap.local/alpine@sha256:0000000000000000000000000000000000000000000000000000000000000000\": targets can not contain a digest"}
+		]
+	}`, rec.Body.String())
+
+	rec = postFiles(t, subject, "/tar",
+		formFile{"images.txt", "busybox\n"},
+		formFile{"manifests.yaml", "kind: Pod\nspec:\n  containers:\n  - image: nginx\n  - image: Nginx\n"},
+	)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{
+		"message": "invalid image list",
+		"errors": [
+			{"file": "manifests.yaml", "entry": "Nginx", "message": "invalid reference \"Nginx\": invalid reference format: repository name must be lowercase"}
+		]
+	}`, rec.Body.String())
+	// Each list is within the limit, but not all of them together.
+	list := strings.Repeat("busybox\n", imagelist.MaxEntries/2+1)
+	rec = postFiles(t, subject, "/tar", formFile{"images.txt", list}, formFile{"images.txt", list})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "requests are limited to 10000 images")
+}
+
+func TestPostTarWithoutImages(t *testing.T) {
+	subject := NewServer(ServerOpts{})
+
+	rec := postFiles(t, subject, "/tar", formFile{"config.json", testAuthConf})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = postFiles(t, subject, "/tar", formFile{"docker-compose.yml", "services:\n  app:\n    image: ${TAG:?must be set}\n"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+type formFile struct {
+	name    string
+	content string
+}
+
+func postFiles(t *testing.T, handler http.Handler, path string, files ...formFile) *httptest.ResponseRecorder {
+	t.Helper()
+
+	upload := new(bytes.Buffer)
+	mpw := multipart.NewWriter(upload)
+	for _, f := range files {
+		fw, err := mpw.CreateFormFile(f.name, f.name)
+		assert.NoError(t, err)
+		fw.Write([]byte(f.content))
+	}
+	mpw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, upload)
+	req.Header.Set(echo.HeaderContentType, mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPostTarWithOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+
+	emptyAuth, err := auth.RegistryAuthFor(auth.EmptyAuthenticator, "busybox")
+	assert.NoError(t, err)
+
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "docker.io/library/alpine:3.18", types.ImagePullOptions{RegistryAuth: emptyAuth, Platform: "linux/arm64"}).
+		Return(mockProgessReader(), nil)
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "docker.io/library/busybox:latest", types.ImagePullOptions{RegistryAuth: emptyAuth, Platform: "linux/amd64"}).
+		Return(mockProgessReader(), nil)
+	mc.EXPECT().
+		ImagePull(gomock.Any(), "registry.example.com/nightly:latest", types.ImagePullOptions{RegistryAuth: emptyAuth, Platform: "linux/amd64"}).
+		Return(ioutil.NopCloser(strings.NewReader(`{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`)), nil)
+	mc.EXPECT().
+		ImageSave(gomock.Any(), []string{"docker.io/library/alpine:3.18", "docker.io/library/busybox:latest"}).
+		Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{DockerClient: mc})
+
+	upload := new(bytes.Buffer)
+	mpw := multipart.NewWriter(upload)
+	fw, err := mpw.CreateFormFile("images.txt", "images.txt")
+	assert.NoError(t, err)
+	fw.Write([]byte("alpine:3.18 platform=linux/arm64 # pinned\ninclude base.txt\n"))
+	fw, err = mpw.CreateFormFile("base.txt", "base.txt")
+	assert.NoError(t, err)
+	fw.Write([]byte("busybox\nregistry.example.com/nightly optional\n"))
+	assert.NoError(t, mpw.WriteField("platform", "linux/amd64"))
+	mpw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/tar", upload)
+	req.Header.Set(echo.HeaderContentType, mpw.FormDataContentType())
+	rec := httptest.NewRecorder()
+
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestPostTarWithInvalidList(t *testing.T) {
+	subject := NewServer(ServerOpts{})
+
+	rec := postFiles(t, subject, "/tar", formFile{"images.txt", "busybox\n\nalpine platform=arm64\ninclude missing.txt\n"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{
+		"message": "invalid image list",
+		"errors": [
+			{"file": "images.txt", "line": 3, "entry": "alpine platform=arm64", "message": "invalid platform \"arm64\": expected os/arch[/variant]"},
+			{"file": "images.txt", "line": 4, "entry": "include missing.txt", "message": "included file missing.txt was not uploaded"}
+		]
+	}`, rec.Body.String())
+}
+
+func TestPostTarJSON(t *testing.T) {
+	authn, err := auth.FromReader(strings.NewReader(testAuthConf))
+	assert.NoError(t, err)
+	subject := NewServer(ServerOpts{DockerClient: dockerMockFor(t, []string{"test.io/busybox"}, authn)})
+
+	req := httptest.NewRequest(http.MethodPost, "/tar?format=oci", strings.NewReader(`{
+		"images": ["test.io/busybox # pinned"],
+		"auths": {"test.io": {"auth": "`+auth64+`"}},
+		"format": "docker",
+		"compression": "none"
+	}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, mockTarBytes(t), rec.Body.Bytes())
+}
+
+func TestImagesFromJSONKeepsQuery(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/tar?format=oci&platform=linux/arm64", strings.NewReader(`{"images": ["busybox"], "format": "docker"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	query := c.QueryParams()
+
+	_, _, err := imagesFromJSON(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "docker", c.FormValue("format"))
+	assert.Equal(t, "linux/arm64", c.FormValue("platform"))
+	assert.Equal(t, "oci", query.Get("format"))
+	assert.Equal(t, "oci", c.QueryParam("format"))
+}
+
+func TestPostTarJSONInvalid(t *testing.T) {
+	// The mock fails the test on any pull
+	subject := NewServer(ServerOpts{DockerClient: NewMockImageAPIClient(gomock.NewController(t))})
+
+	for _, tc := range []struct {
+		contentType, body string
+		code              int
+		message           string
+	}{
+		{echo.MIMEApplicationJSON, `{"images": ["busybox"], "image": "alpine"}`, http.StatusBadRequest, `invalid JSON body: json: unknown field "image"`},
+		{echo.MIMEApplicationJSON, `{"images": "busybox"}`, http.StatusBadRequest, "invalid JSON body: json: cannot unmarshal string into Go struct field tarRequest.images of type []string"},
+		{echo.MIMEApplicationJSON, `{"format": "oci"}`, http.StatusBadRequest, "missing images"},
+		{echo.MIMEApplicationJSON, `{"images": ["busybox\nalpine"]}`, http.StatusBadRequest, "images[0] must be a single line"},
+		{echo.MIMEApplicationJSON, `{"images": ["busybox"], "format": "zip"}`, http.StatusBadRequest, `invalid format "zip", expected "docker" or "oci"`},
+		{echo.MIMEApplicationJSON, `{"images": ["busybox"], "auths": []}`, http.StatusBadRequest, "invalid auths: json: cannot unmarshal array into Go struct field .auths of type map[string]types.AuthConfig"},
+		{echo.MIMETextPlain, "busybox", http.StatusUnsupportedMediaType, `unsupported content type "text/plain", expected application/json or multipart/form-data`},
+		{"", "busybox", http.StatusUnsupportedMediaType, `unsupported content type "", expected application/json or multipart/form-data`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/tar", strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set(echo.HeaderContentType, tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		subject.ServeHTTP(rec, req)
+
+		assert.Equal(t, tc.code, rec.Code, tc.body)
+		var res struct{ Message string }
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		assert.Equal(t, tc.message, res.Message, tc.body)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/tar", strings.NewReader(`{"images": ["busybox", "Alpine"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{
+		"message": "invalid image list",
+		"errors": [
+			{"file": "images", "line": 2, "entry": "Alpine", "message": "invalid reference \"Alpine\": invalid reference format: repository name must be lowercase"}
+		]
+	}`, rec.Body.String())
+}
+
 func dockerMockFor(t *testing.T, images []string, authn auth.Authenticator) *MockImageAPIClient {
 	ctrl := gomock.NewController(t)
 	mc := NewMockImageAPIClient(ctrl)
@@ -145,7 +765,11 @@ func dockerMockFor(t *testing.T, images []string, authn auth.Authenticator) *Moc
 		authn = auth.EmptyAuthenticator
 	}
 
+	normalized := make([]string, 0, len(images))
 	for _, img := range images {
+		img = normalizedRef(t, img)
+		normalized = append(normalized, img)
+
 		emptyAuth, err := auth.RegistryAuthFor(authn, img)
 		assert.NoError(t, err)
 		mc.
@@ -161,12 +785,20 @@ func dockerMockFor(t *testing.T, images []string, authn auth.Authenticator) *Moc
 
 	mc.
 		EXPECT().
-		ImageSave(gomock.Any(), gomock.Eq(images)).
+		ImageSave(gomock.Any(), gomock.Eq(normalized)).
 		Return(mockTarReader(t), nil)
 
 	return mc
 }
 
+func normalizedRef(t *testing.T, img string) string {
+	t.Helper()
+
+	ref, err := reference.ParseNormalizedNamed(img)
+	assert.NoError(t, err)
+	return reference.TagNameOnly(ref).String()
+}
+
 func mockProgessReader() io.ReadCloser {
 	return ioutil.NopCloser(strings.NewReader(`{}`))
 }
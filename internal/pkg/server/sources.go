@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/bundle"
+	"github.com/bastjan/saveomat/internal/pkg/compose"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/kube"
+	"github.com/labstack/echo/v4"
+)
+
+// imagesFromQuery reads the images of a GET request from the query parameters.
+func imagesFromQuery(c echo.Context) ([]imagelist.Entry, error) {
+	p := c.QueryParams()
+	lines, ok := p["image"]
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "missing image parameter")
+	}
+
+	entries, err := imagelist.Parse("query", strings.NewReader(strings.Join(lines, "\n")), nil)
+	if err != nil {
+		return nil, badImageList(err)
+	}
+	images, err := bundle.Normalize(entries, p.Get("retag-prefix"), p.Get("platform"))
+	if err != nil {
+		return nil, badImageList(err)
+	}
+	return images, nil
+}
+
+// imagesFromPost reads the images and credentials of a POST request from the
+// multipart form.
+func imagesFromPost(c echo.Context) ([]imagelist.Entry, auth.Authenticator, error) {
+	entries, err := imagesFromForm(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authn, err := authFromFormFile(c, "config.json")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	images, err := bundle.Normalize(entries, c.FormValue("retag-prefix"), c.FormValue("platform"))
+	if err != nil {
+		return nil, nil, badImageList(err)
+	}
+	return images, authn, nil
+}
+
+// tarRequest is the JSON body of POST /tar, an alternative to the multipart
+// form. Its options correspond to the form fields.
+type tarRequest struct {
+	Images []string `json:"images"`
+	// Auths are the "auths" of a docker config file.
+	Auths       json.RawMessage `json:"auths,omitempty"`
+	RetagPrefix string          `json:"retagPrefix,omitempty"`
+	Platform    string          `json:"platform,omitempty"`
+	Format      string          `json:"format,omitempty"`
+	Compression string          `json:"compression,omitempty"`
+	Checksums   bool            `json:"checksums,omitempty"`
+	Referrers   bool            `json:"referrers,omitempty"`
+	SBOM        string          `json:"sbom,omitempty"`
+	Scan        bool            `json:"scan,omitempty"`
+	FailOn      string          `json:"failOn,omitempty"`
+	Output      string          `json:"output,omitempty"`
+	Split       string          `json:"split,omitempty"`
+	Have        []string        `json:"have,omitempty"`
+	Webhooks    []string        `json:"webhooks,omitempty"`
+}
+
+// form returns the options as form values.
+func (r tarRequest) form() map[string][]string {
+	form := map[string][]string{}
+	set := func(name, v string) {
+		if v != "" {
+			form[name] = []string{v}
+		}
+	}
+	set("retag-prefix", r.RetagPrefix)
+	set("platform", r.Platform)
+	set("format", r.Format)
+	set("compression", r.Compression)
+	set("sbom", r.SBOM)
+	set("fail-on", r.FailOn)
+	set("output", r.Output)
+	set("split", r.Split)
+	for name, b := range map[string]bool{"checksums": r.Checksums, "referrers": r.Referrers, "scan": r.Scan} {
+		if b {
+			set(name, strconv.FormatBool(b))
+		}
+	}
+	if len(r.Have) > 0 {
+		form["have"] = r.Have
+	}
+	if len(r.Webhooks) > 0 {
+		form["webhook"] = r.Webhooks
+	}
+	return form
+}
+
+// imagesFromJSON reads the images and credentials of a POST request from its
+// JSON body. The options of the body replace the query parameters of the same
+// name, so the request is handled like a form afterwards.
+func imagesFromJSON(c echo.Context) ([]imagelist.Entry, auth.Authenticator, error) {
+	var body tarRequest
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "invalid JSON body: "+err.Error())
+	}
+	if len(body.Images) == 0 {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "missing images")
+	}
+	for i, img := range body.Images {
+		if strings.ContainsAny(img, "\r\n") {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("images[%d] must be a single line", i))
+		}
+	}
+
+	// Copy the query, echo caches it for QueryParams.
+	form := url.Values{}
+	for name, values := range c.QueryParams() {
+		form[name] = values
+	}
+	for name, values := range body.form() {
+		form[name] = values
+	}
+	c.Request().Form = form
+
+	authn := auth.EmptyAuthenticator
+	if len(body.Auths) > 0 {
+		config, err := json.Marshal(struct {
+			Auths json.RawMessage `json:"auths"`
+		}{body.Auths})
+		if err != nil {
+			return nil, nil, err
+		}
+		if authn, err = auth.FromReader(bytes.NewReader(config)); err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "invalid auths: "+err.Error())
+		}
+	}
+
+	entries, err := imagelist.Parse("images", strings.NewReader(strings.Join(body.Images, "\n")), nil)
+	if err != nil {
+		return nil, nil, badImageList(err)
+	}
+	images, err := bundle.Normalize(entries, c.FormValue("retag-prefix"), c.FormValue("platform"))
+	if err != nil {
+		return nil, nil, badImageList(err)
+	}
+	return images, authn, nil
+}
+
+// mediaType returns the media type of the request body without parameters.
+func mediaType(c echo.Context) string {
+	mt, _, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+// imagesFromForm collects the images from all image sources uploaded in a
+// multipart form.
+func imagesFromForm(c echo.Context) ([]imagelist.Entry, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	imageFiles := form.File["images.txt"]
+	composeFiles := form.File["docker-compose.yml"]
+	manifestFiles := form.File["manifests.yaml"]
+	if len(imageFiles) == 0 && len(composeFiles) == 0 && len(manifestFiles) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "missing images.txt, docker-compose.yml or manifests.yaml")
+	}
+
+	// Lists can include any other uploaded file by its field name.
+	resolve := func(name string) (io.ReadCloser, error) {
+		files := form.File[name]
+		if len(files) == 0 {
+			return nil, fmt.Errorf("included file %s was not uploaded", name)
+		}
+		return files[0].Open()
+	}
+
+	entries := make([]imagelist.Entry, 0, 5)
+	var listErrs imagelist.Errors
+	for _, fh := range imageFiles {
+		err := withFormFile(fh, func(r io.Reader) error {
+			parsed, err := imagelist.Parse(fh.Filename, r, resolve)
+			entries = append(entries, parsed...)
+			return err
+		})
+		var errs imagelist.Errors
+		if errors.As(err, &errs) {
+			listErrs = append(listErrs, errs...)
+		} else if err != nil {
+			return nil, err
+		}
+		if len(entries) > imagelist.MaxEntries {
+			return nil, tooManyEntries()
+		}
+	}
+	if len(listErrs) > 0 {
+		return nil, badImageList(listErrs)
+	}
+
+	if len(composeFiles) > 0 {
+		images, err := composeImages(composeFiles, form.File[".env"])
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		entries = append(entries, entriesFor("docker-compose.yml", images)...)
+	}
+
+	for _, fh := range manifestFiles {
+		err := withFormFile(fh, func(r io.Reader) error {
+			images, err := kube.Images(r)
+			entries = append(entries, entriesFor(fh.Filename, images)...)
+			return err
+		})
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fh.Filename+": "+err.Error())
+		}
+	}
+	if len(entries) > imagelist.MaxEntries {
+		return nil, tooManyEntries()
+	}
+
+	return entries, nil
+}
+
+func tooManyEntries() error {
+	return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("requests are limited to %d images", imagelist.MaxEntries))
+}
+
+func entriesFor(file string, images []string) []imagelist.Entry {
+	entries := make([]imagelist.Entry, 0, len(images))
+	for _, img := range images {
+		entries = append(entries, imagelist.Entry{File: file, Source: img})
+	}
+	return entries
+}
+
+func composeImages(files, envFiles []*multipart.FileHeader) ([]string, error) {
+	env := map[string]string{}
+	for _, fh := range envFiles {
+		err := withFormFile(fh, func(r io.Reader) error {
+			parsed, err := compose.ParseEnv(r)
+			for k, v := range parsed {
+				env[k] = v
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	readers := make([]io.Reader, 0, len(files))
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	return compose.Images(readers, env)
+}
+
+func withFormFile(fh *multipart.FileHeader, fn func(io.Reader) error) error {
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/cosign"
+	"github.com/bastjan/saveomat/internal/pkg/cosign/cosigntest"
+	"github.com/bastjan/saveomat/internal/pkg/registry/registrytest"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTarVerifiesSignatures(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	key, _ := cosigntest.GenerateKey()
+	signed := reg.PushImage("signed", "v1", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("signed"))
+	cosigntest.PushSignatures(reg, "signed", signed.Digest, key)
+
+	ctrl := gomock.NewController(t)
+	mc := NewMockImageAPIClient(ctrl)
+	pinned := reg.Host() + "/signed@" + signed.Digest.String()
+	mc.EXPECT().
+		ImagePull(gomock.Any(), pinned, gomock.Eq(types.ImagePullOptions{RegistryAuth: "e30="})).
+		Return(mockProgessReader(), nil)
+	mc.EXPECT().ImageTag(gomock.Any(), pinned, reg.Host()+"/signed:v1").Return(nil)
+	mc.EXPECT().ImageSave(gomock.Any(), []string{reg.Host() + "/signed:v1"}).Return(mockTarReader(t), nil)
+
+	subject := NewServer(ServerOpts{
+		DockerClient: mc,
+		CosignKeys:   []crypto.PublicKey{key.Public()},
+	})
+	params := url.Values{"image": {reg.Host() + "/signed:v1"}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	files := tarFiles(t, rec.Body.Bytes())
+	dir := "cosign/" + cosign.SignatureTag(signed.Digest) + "/"
+	if assert.Contains(t, files, dir+"payload-0.json") && assert.Contains(t, files, dir+"payload-0.json.sig") {
+		valid := cosign.VerifySignatures([]cosign.Signature{{
+			Payload:         files[dir+"payload-0.json"],
+			Base64Signature: string(files[dir+"payload-0.json.sig"]),
+		}}, []crypto.PublicKey{key.Public()}, signed.Digest)
+		assert.Len(t, valid, 1, "bundled signatures can be verified")
+	}
+	assert.Contains(t, files, "images")
+}
+
+func TestGetTarRejectsUnsignedImages(t *testing.T) {
+	reg := registrytest.New()
+	defer reg.Close()
+
+	key, _ := cosigntest.GenerateKey()
+	otherKey, _ := cosigntest.GenerateKey()
+	signed := reg.PushImage("signed", "v1", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("signed"))
+	cosigntest.PushSignatures(reg, "signed", signed.Digest, key)
+	reg.PushImage("unsigned", "v1", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("unsigned"))
+	foreign := reg.PushImage("foreign", "v1", ocispec.Platform{OS: "linux", Architecture: "amd64"}, []byte("foreign"))
+	cosigntest.PushSignatures(reg, "foreign", foreign.Digest, otherKey)
+
+	for _, img := range []string{"unsigned:v1", "foreign:v1"} {
+		ctrl := gomock.NewController(t)
+		mc := NewMockImageAPIClient(ctrl)
+		// Verification runs in parallel, the signed image may be pulled
+		// before the request fails.
+		mc.EXPECT().ImagePull(gomock.Any(), gomock.Any(), gomock.Any()).Return(mockProgessReader(), nil).AnyTimes()
+		mc.EXPECT().ImageTag(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+		subject := NewServer(ServerOpts{
+			DockerClient: mc,
+			CosignKeys:   []crypto.PublicKey{key.Public()},
+		})
+		params := url.Values{"image": {reg.Host() + "/signed:v1", reg.Host() + "/" + img}}.Encode()
+		req := httptest.NewRequest(http.MethodGet, "/tar?"+params, nil)
+		rec := httptest.NewRecorder()
+		subject.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code, img)
+		assert.Contains(t, rec.Body.String(), "no valid signature", img)
+	}
+}
+
+func tarFiles(t *testing.T, b []byte) map[string][]byte {
+	t.Helper()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			break
+		}
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		files[hdr.Name] = content
+	}
+	return files
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/webhook"
+	"github.com/labstack/echo/v4"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	eventSucceeded = "bundle.succeeded"
+	eventFailed    = "bundle.failed"
+
+	// webhookTimeout limits the delivery of an event including retries.
+	webhookTimeout = 5 * time.Minute
+)
+
+// bundleResult is the outcome of a request for an archive.
+type bundleResult struct {
+	images  []string
+	digests []digest.Digest
+	size    int64
+	sha256  string
+	// location is the download URL of an uploaded archive.
+	location string
+	// failure is set if the request failed without returning an error.
+	failure string
+}
+
+// bundleEvent is sent to webhooks.
+type bundleEvent struct {
+	Event  string       `json:"event"`
+	Time   time.Time    `json:"time"`
+	Images []eventImage `json:"images"`
+	Size   int64        `json:"size,omitempty"`
+	SHA256 string       `json:"sha256,omitempty"`
+	// Duration is in seconds.
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+	Location string  `json:"location,omitempty"`
+}
+
+type eventImage struct {
+	Name   string        `json:"name"`
+	Digest digest.Digest `json:"digest,omitempty"`
+}
+
+// event describes the result. The requested images are listed if the request
+// failed before they were pulled.
+func (r *bundleResult) event(requested []imagelist.Entry, d time.Duration, err error) bundleEvent {
+	ev := bundleEvent{
+		Event:    eventSucceeded,
+		Time:     time.Now().UTC(),
+		Images:   []eventImage{},
+		Size:     r.size,
+		SHA256:   r.sha256,
+		Duration: d.Seconds(),
+		Location: r.location,
+	}
+	if r.images != nil {
+		for i, name := range r.images {
+			ev.Images = append(ev.Images, eventImage{Name: name, Digest: r.digests[i]})
+		}
+	} else {
+		for _, e := range requested {
+			ev.Images = append(ev.Images, eventImage{Name: e.Target})
+		}
+	}
+
+	var he *echo.HTTPError
+	switch {
+	case errors.As(err, &he):
+		ev.Error = errorMessage(he)
+	case err != nil:
+		ev.Error = err.Error()
+	default:
+		ev.Error = r.failure
+	}
+	if ev.Error != "" {
+		ev.Event = eventFailed
+	}
+	return ev
+}
+
+func errorMessage(he *echo.HTTPError) string {
+	switch m := he.Message.(type) {
+	case string:
+		return m
+	case imageListErrorResponse:
+		return m.Message + ": " + m.Errors.Error()
+	case scanFailure:
+		return m.Message
+	}
+	return http.StatusText(he.Code)
+}
+
+// requestWebhooks returns the webhooks given in the "webhook" parameters,
+// except the configured webhooks, which are notified anyway.
+func (s *Server) requestWebhooks(c echo.Context) ([]string, error) {
+	var hooks []string
+	seen := map[string]bool{}
+	for _, h := range s.Webhooks {
+		seen[h] = true
+	}
+	// FormValue parses the query and the form.
+	c.FormValue("webhook")
+	for _, h := range c.Request().Form["webhook"] {
+		if h == "" {
+			continue
+		}
+		if err := s.RequestWebhookSender.ValidateURL(h); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if !seen[h] {
+			seen[h] = true
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks, nil
+}
+
+// notify sends the event to the webhooks in the background.
+func (s *Server) notify(sender *webhook.Sender, hooks []string, ev bundleEvent) {
+	for _, h := range hooks {
+		h := h
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+			defer cancel()
+			if err := sender.Send(ctx, h, ev.Event, ev); err != nil {
+				s.Logger.Warnf("notifying webhook %s: %v", redactURL(h), err)
+			}
+		}()
+	}
+}
+
+// redactURL removes credentials and the query, which may contain tokens.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.User, u.RawQuery = nil, ""
+	return u.String()
+}
@@ -0,0 +1,119 @@
+// Package sources reads local files listing images, like images.txt, docker
+// compose files and Kubernetes manifests.
+package sources
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bastjan/saveomat/internal/pkg/compose"
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/kube"
+)
+
+// File is a file read from the sources. Field is the name of the form field
+// the server expects it in, e.g. "images.txt".
+type File struct {
+	Field    string
+	Filename string
+	Content  []byte
+}
+
+// Sources are local files listing the images of an archive.
+type Sources struct {
+	// ImageLists are in the format of images.txt. Included lists are
+	// resolved relative to the including list.
+	ImageLists   []string
+	ComposeFiles []string
+	Manifests    []string
+}
+
+// Read reads the files and the files they include. It returns the files and
+// the images they reference. A .env file next to the first compose file is
+// read as well.
+func (s Sources) Read() ([]File, []imagelist.Entry, error) {
+	var read []File
+	var images []imagelist.Entry
+	seen := map[string]bool{}
+
+	for _, path := range s.ImageLists {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		read = append(read, File{Field: "images.txt", Filename: filepath.Base(path), Content: content})
+
+		dir := filepath.Dir(path)
+		entries, err := imagelist.Parse(filepath.Base(path), bytes.NewReader(content), func(name string) (io.ReadCloser, error) {
+			included, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil {
+				return nil, err
+			}
+			if !seen[name] {
+				seen[name] = true
+				read = append(read, File{Field: name, Filename: filepath.Base(name), Content: included})
+			}
+			return ioutil.NopCloser(bytes.NewReader(included)), nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, entries...)
+	}
+
+	if len(s.ComposeFiles) > 0 {
+		var composeFiles []io.Reader
+		for _, path := range s.ComposeFiles {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			read = append(read, File{Field: "docker-compose.yml", Filename: filepath.Base(path), Content: content})
+			composeFiles = append(composeFiles, bytes.NewReader(content))
+		}
+
+		var env map[string]string
+		envFile := filepath.Join(filepath.Dir(s.ComposeFiles[0]), ".env")
+		content, err := ioutil.ReadFile(envFile)
+		switch {
+		case err == nil:
+			read = append(read, File{Field: ".env", Filename: ".env", Content: content})
+			if env, err = compose.ParseEnv(bytes.NewReader(content)); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", envFile, err)
+			}
+		case !os.IsNotExist(err):
+			return nil, nil, err
+		}
+		composeImages, err := compose.Images(composeFiles, env)
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, entriesFor(filepath.Base(s.ComposeFiles[0]), composeImages)...)
+	}
+
+	for _, path := range s.Manifests {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		read = append(read, File{Field: "manifests.yaml", Filename: filepath.Base(path), Content: content})
+		manifestImages, err := kube.Images(bytes.NewReader(content))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		images = append(images, entriesFor(filepath.Base(path), manifestImages)...)
+	}
+	return read, images, nil
+}
+
+func entriesFor(file string, images []string) []imagelist.Entry {
+	entries := make([]imagelist.Entry, 0, len(images))
+	for _, img := range images {
+		entries = append(entries, imagelist.Entry{File: file, Source: img})
+	}
+	return entries
+}
@@ -0,0 +1,79 @@
+package sources_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/bastjan/saveomat/internal/pkg/sources"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestSourcesImageLists(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"images.txt":     "include lists/base.txt\nnginx:1.25\n",
+		"apps.txt":       "include lists/base.txt\nredis\n",
+		"lists/base.txt": "busybox\n",
+	})
+
+	files, images, err := sources.Sources{ImageLists: []string{
+		filepath.Join(dir, "images.txt"),
+		filepath.Join(dir, "apps.txt"),
+	}}.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []sources.File{
+		{Field: "images.txt", Filename: "images.txt", Content: []byte("include lists/base.txt\nnginx:1.25\n")},
+		{Field: "lists/base.txt", Filename: "base.txt", Content: []byte("busybox\n")},
+		{Field: "images.txt", Filename: "apps.txt", Content: []byte("include lists/base.txt\nredis\n")},
+	}, files)
+	assert.Equal(t, []string{"busybox", "nginx:1.25", "busybox", "redis"}, sourcesOf(images))
+	assert.Equal(t, imagelist.Entry{File: "lists/base.txt", Line: 1, Source: "busybox"}, images[0])
+}
+
+func TestSourcesComposeAndManifests(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"docker-compose.yml": "services:\n  web:\n    image: nginx:${TAG}\n",
+		".env":               "TAG=1.25\n",
+		"deploy.yaml":        "apiVersion: v1\nkind: Pod\nspec:\n  containers:\n  - image: redis:7\n",
+	})
+
+	files, images, err := sources.Sources{
+		ComposeFiles: []string{filepath.Join(dir, "docker-compose.yml")},
+		Manifests:    []string{filepath.Join(dir, "deploy.yaml")},
+	}.Read()
+	assert.NoError(t, err)
+	var fields []string
+	for _, f := range files {
+		fields = append(fields, f.Field)
+	}
+	assert.Equal(t, []string{"docker-compose.yml", ".env", "manifests.yaml"}, fields)
+	assert.Equal(t, []imagelist.Entry{
+		{File: "docker-compose.yml", Source: "nginx:1.25"},
+		{File: "deploy.yaml", Source: "redis:7"},
+	}, images)
+}
+
+func sourcesOf(entries []imagelist.Entry) []string {
+	sources := make([]string, 0, len(entries))
+	for _, e := range entries {
+		sources = append(sources, e.Source)
+	}
+	return sources
+}
+
+func TestSourcesMissing(t *testing.T) {
+	_, _, err := sources.Sources{ImageLists: []string{filepath.Join(t.TempDir(), "images.txt")}}.Read()
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,222 @@
+// Package split splits archives into fixed-size parts and joins them again.
+package split
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestSuffix is appended to the archive name to name the manifest.
+const ManifestSuffix = ".parts.json"
+
+// Manifest describes a split archive.
+type Manifest struct {
+	// Name is the name of the joined archive.
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex encoded checksum of the joined archive.
+	SHA256 string `json:"sha256"`
+	Parts  []Part `json:"parts"`
+}
+
+// Part is a part of a split archive.
+type Part struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ParseSize parses sizes like 4000M or 2G. The units K, M, G and T are powers
+// of 1024 and may be followed by "i" and "B".
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	shift := 0
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:n-1]
+		}
+	}
+	size, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || size <= 0 || size > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid size %q, expected a positive number of bytes with an optional unit K, M, G or T", s)
+	}
+	return size << shift, nil
+}
+
+// Zip splits the archive read from r into parts of the given size and writes
+// them to w as a zip file. The parts are named <name>.000, <name>.001, ... and
+// are followed by the manifest <name>.parts.json.
+func Zip(w io.Writer, r io.Reader, name string, size int64) error {
+	zw := zip.NewWriter(w)
+	m := Manifest{Name: name, Parts: []Part{}}
+	whole := sha256.New()
+	br := bufio.NewReader(io.TeeReader(r, whole))
+
+	for i := 0; ; i++ {
+		if _, err := br.Peek(1); err == io.EOF && i > 0 {
+			break
+		}
+		part := Part{Name: fmt.Sprintf("%s.%03d", name, i)}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: part.Name, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		part.Size, err = io.Copy(io.MultiWriter(fw, h), io.LimitReader(br, size))
+		if err != nil {
+			return err
+		}
+		part.SHA256 = hex.EncodeToString(h.Sum(nil))
+		m.Parts = append(m.Parts, part)
+		m.Size += part.Size
+		if part.Size < size {
+			break
+		}
+	}
+	m.SHA256 = hex.EncodeToString(whole.Sum(nil))
+
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	fw, err := zw.Create(name + ManifestSuffix)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(append(content, '\n')); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Set is a split archive opened for joining.
+type Set struct {
+	Manifest Manifest
+	open     func(name string) (io.ReadCloser, error)
+	close    func() error
+}
+
+// OpenManifest opens the parts next to the manifest file.
+func OpenManifest(name string) (*Set, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &Set{close: func() error { return nil }}
+	if err := json.NewDecoder(f).Decode(&s.Manifest); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", name, err)
+	}
+	dir := filepath.Dir(name)
+	s.open = func(part string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, filepath.Base(part)))
+	}
+	return s, nil
+}
+
+// OpenZip opens the parts contained in a zip file created by Zip.
+func OpenZip(name string) (*Set, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]*zip.File{}
+	var manifest *zip.File
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if strings.HasSuffix(f.Name, ManifestSuffix) {
+			manifest = f
+		}
+	}
+	if manifest == nil {
+		zr.Close()
+		return nil, fmt.Errorf("%s does not contain a *%s manifest", name, ManifestSuffix)
+	}
+
+	s := &Set{close: zr.Close}
+	err = func() error {
+		rc, err := manifest.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return json.NewDecoder(rc).Decode(&s.Manifest)
+	}()
+	if err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("decoding %s: %w", manifest.Name, err)
+	}
+	s.open = func(part string) (io.ReadCloser, error) {
+		f, ok := files[part]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return f.Open()
+	}
+	return s, nil
+}
+
+// Close releases the parts.
+func (s *Set) Close() error {
+	return s.close()
+}
+
+// Join writes the joined archive to w, verifying the size and checksum of
+// every part and of the whole archive. Use ioutil.Discard to verify only.
+func (s *Set) Join(w io.Writer) error {
+	whole := sha256.New()
+	var size int64
+	for _, p := range s.Manifest.Parts {
+		n, err := s.copyPart(io.MultiWriter(w, whole), p)
+		size += n
+		if err != nil {
+			return err
+		}
+	}
+	if size != s.Manifest.Size || !matches(whole, s.Manifest.SHA256) {
+		return fmt.Errorf("%s: checksum mismatch", s.Manifest.Name)
+	}
+	return nil
+}
+
+func (s *Set) copyPart(w io.Writer, p Part) (int64, error) {
+	rc, err := s.open(p.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("%s: missing", p.Name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	// Read one more byte than expected to detect oversized parts.
+	n, err := io.Copy(io.MultiWriter(w, h), io.LimitReader(rc, p.Size+1))
+	if err != nil {
+		return n, err
+	}
+	if n != p.Size {
+		return n, fmt.Errorf("%s: expected %d bytes, got %d", p.Name, p.Size, n)
+	}
+	if !matches(h, p.SHA256) {
+		return n, fmt.Errorf("%s: checksum mismatch", p.Name)
+	}
+	return n, nil
+}
+
+func matches(h hash.Hash, sum string) bool {
+	return hex.EncodeToString(h.Sum(nil)) == strings.ToLower(sum)
+}
@@ -0,0 +1,106 @@
+package split
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"1024":  1024,
+		"4000M": 4000 << 20,
+		"2G":    2 << 30,
+		"2GiB":  2 << 30,
+		"1kb":   1 << 10,
+		" 1T ":  1 << 40,
+	} {
+		size, err := ParseSize(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, size, s)
+	}
+	for _, s := range []string{"", "0", "-1M", "1.5G", "1P", "G", "9999999T"} {
+		_, err := ParseSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestZipAndJoin(t *testing.T) {
+	for content, parts := range map[string][]string{
+		"":           {"images.tar.000"},
+		"0123":       {"images.tar.000"},
+		"01234567":   {"images.tar.000", "images.tar.001"},
+		"0123456789": {"images.tar.000", "images.tar.001", "images.tar.002"},
+	} {
+		zipped := new(bytes.Buffer)
+		assert.NoError(t, Zip(zipped, strings.NewReader(content), "images.tar", 4))
+
+		dir := t.TempDir()
+		names := unzip(t, zipped.Bytes(), dir)
+		assert.Equal(t, append(parts, "images.tar.parts.json"), names, content)
+
+		for _, open := range []func() (*Set, error){
+			func() (*Set, error) { return OpenManifest(filepath.Join(dir, "images.tar.parts.json")) },
+			func() (*Set, error) { return OpenZip(filepath.Join(dir, "images.zip")) },
+		} {
+			set, err := open()
+			if !assert.NoError(t, err) {
+				continue
+			}
+			assert.Equal(t, "images.tar", set.Manifest.Name)
+			assert.Equal(t, int64(len(content)), set.Manifest.Size)
+			assert.Len(t, set.Manifest.Parts, len(parts))
+			joined := new(bytes.Buffer)
+			assert.NoError(t, set.Join(joined))
+			assert.Equal(t, content, joined.String())
+			assert.NoError(t, set.Close())
+		}
+	}
+}
+
+func TestJoinDetectsTampering(t *testing.T) {
+	zipped := new(bytes.Buffer)
+	assert.NoError(t, Zip(zipped, strings.NewReader("0123456789"), "images.tar", 4))
+	dir := t.TempDir()
+	unzip(t, zipped.Bytes(), dir)
+	manifest := filepath.Join(dir, "images.tar.parts.json")
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "images.tar.001"), []byte("4X67"), 0644))
+	set, err := OpenManifest(manifest)
+	assert.NoError(t, err)
+	assert.EqualError(t, set.Join(ioutil.Discard), "images.tar.001: checksum mismatch")
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "images.tar.001"), []byte("45678"), 0644))
+	assert.EqualError(t, set.Join(ioutil.Discard), "images.tar.001: expected 4 bytes, got 5")
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "images.tar.001")))
+	assert.EqualError(t, set.Join(ioutil.Discard), "images.tar.001: missing")
+}
+
+// unzip extracts the zip to dir, stores the zip itself as images.zip and
+// returns the names of the extracted files.
+func unzip(t *testing.T, b []byte, dir string) []string {
+	t.Helper()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "images.zip"), b, 0644))
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(rc)
+		assert.NoError(t, err)
+		rc.Close()
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, f.Name), content, 0644))
+	}
+	return names
+}
@@ -0,0 +1,55 @@
+package vuln
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCVSS3Score(t *testing.T) {
+	for vector, expected := range map[string]float64{
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H": 9.8,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H": 10,
+		"CVSS:3.0/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N": 6.1,
+		"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N": 5.5,
+		"CVSS:3.1/AV:N/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N": 2.0,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N": 0,
+	} {
+		score, err := cvss3Score(vector)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, score, vector)
+	}
+
+	_, err := cvss3Score("AV:N/AC:L/Au:N/C:P/I:P/A:P")
+	assert.Error(t, err)
+	_, err = cvss3Score("CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H")
+	assert.EqualError(t, err, `invalid CVSS v3 vector "CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H": missing or invalid AV`)
+}
+
+func TestParseSeverity(t *testing.T) {
+	for name, expected := range map[string]Severity{
+		"LOW":       SeverityLow,
+		"moderate":  SeverityMedium,
+		"High":      SeverityHigh,
+		"critical":  SeverityCritical,
+		"important": SeverityHigh,
+	} {
+		s, err := ParseSeverity(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, s, name)
+	}
+
+	_, err := ParseSeverity("severe")
+	assert.EqualError(t, err, `unknown severity "severe", expected one of low, medium, high, critical`)
+	assert.Equal(t, "critical", SeverityCritical.String())
+}
+
+func TestSeverityText(t *testing.T) {
+	for _, s := range []Severity{SeverityUnknown, SeverityLow, SeverityCritical} {
+		text, err := s.MarshalText()
+		assert.NoError(t, err)
+		var parsed Severity
+		assert.NoError(t, parsed.UnmarshalText(text))
+		assert.Equal(t, s, parsed)
+	}
+}
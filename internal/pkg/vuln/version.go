@@ -0,0 +1,281 @@
+package vuln
+
+import (
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// compareVersions compares versions using the rules of the package type.
+func compareVersions(typ, a, b string) int {
+	switch typ {
+	case "deb":
+		return compareDpkg(a, b)
+	case "apk":
+		return compareApk(a, b)
+	case "rpm":
+		return compareRpm(a, b)
+	case "golang":
+		return semver.Compare(canonicalSemver(a), canonicalSemver(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// canonicalSemver adds the "v" prefix required by semver. Versions of the
+// Go standard library are reported as "go1.21.5".
+func canonicalSemver(v string) string {
+	v = strings.TrimPrefix(v, "go")
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	return v
+}
+
+// compareDpkg compares Debian versions "[epoch:]upstream[-revision]".
+// See deb-version(7).
+func compareDpkg(a, b string) int {
+	ea, ua, ra := splitDpkg(a)
+	eb, ub, rb := splitDpkg(b)
+	if c := compareNumeric(ea, eb); c != 0 {
+		return c
+	}
+	if c := compareDpkgPart(ua, ub); c != 0 {
+		return c
+	}
+	return compareDpkgPart(ra, rb)
+}
+
+func splitDpkg(v string) (epoch, upstream, revision string) {
+	epoch = "0"
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		epoch, v = v[:i], v[i+1:]
+	}
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+// compareDpkgPart alternately compares non-digit and digit runs. In
+// non-digit runs "~" sorts before everything, even the end of the string,
+// and letters sort before other characters.
+func compareDpkgPart(a, b string) int {
+	for a != "" || b != "" {
+		var na, nb string
+		na, a = splitRun(a, false)
+		nb, b = splitRun(b, false)
+		if c := compareDpkgLexical(na, nb); c != 0 {
+			return c
+		}
+		na, a = splitRun(a, true)
+		nb, b = splitRun(b, true)
+		if c := compareNumeric(na, nb); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareDpkgLexical(a, b string) int {
+	order := func(s string, i int) int {
+		if i >= len(s) {
+			return 0
+		}
+		c := s[i]
+		switch {
+		case c == '~':
+			return -1
+		case isLetter(c):
+			return int(c)
+		default:
+			return int(c) + 256
+		}
+	}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if oa, ob := order(a, i), order(b, i); oa != ob {
+			if oa < ob {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// compareApk compares Alpine versions "1.2.3[_suffix][-rN]".
+func compareApk(a, b string) int {
+	va, ra := splitApk(a)
+	vb, rb := splitApk(b)
+	if c := compareApkVersion(va, vb); c != 0 {
+		return c
+	}
+	return compareNumeric(ra, rb)
+}
+
+func splitApk(v string) (version, release string) {
+	if i := strings.LastIndex(v, "-r"); i >= 0 {
+		return v[:i], v[i+2:]
+	}
+	return v, "0"
+}
+
+// apkSuffixes in ascending order. Versions without suffix sort between
+// "rc" and "cvs".
+var apkSuffixes = []string{"alpha", "beta", "pre", "rc", "", "cvs", "svn", "git", "hg", "p"}
+
+func compareApkVersion(a, b string) int {
+	sa, suffixA := splitApkSuffix(a)
+	sb, suffixB := splitApkSuffix(b)
+	if c := compareDpkgPart(sa, sb); c != 0 {
+		return c
+	}
+	return compareApkSuffix(suffixA, suffixB)
+}
+
+func splitApkSuffix(v string) (string, string) {
+	if i := strings.IndexByte(v, '_'); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+func compareApkSuffix(a, b string) int {
+	rank := func(s string) (int, string) {
+		name, num := splitRun(s, false)
+		for i, suffix := range apkSuffixes {
+			if suffix == name {
+				return i, num
+			}
+		}
+		return len(apkSuffixes), num
+	}
+	ra, na := rank(a)
+	rb, nb := rank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	return compareNumeric(na, nb)
+}
+
+// compareRpm compares RPM versions "[epoch:]version[-release]" like
+// rpmvercmp.
+func compareRpm(a, b string) int {
+	ea, va, ra := splitDpkg(a)
+	eb, vb, rb := splitDpkg(b)
+	if c := compareNumeric(ea, eb); c != 0 {
+		return c
+	}
+	if c := compareRpmPart(va, vb); c != 0 {
+		return c
+	}
+	return compareRpmPart(ra, rb)
+}
+
+// compareRpmPart compares alphanumeric segments, ignoring separators. Digits
+// sort after letters, "~" sorts before everything, even the end of the
+// string, and "^" only before the end of the string.
+func compareRpmPart(a, b string) int {
+	for a != "" || b != "" {
+		a, b = trimRpmSeparators(a), trimRpmSeparators(b)
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			switch {
+			case a == "":
+				return -1
+			case b == "":
+				return 1
+			case a[0] != '^':
+				return 1
+			case b[0] != '^':
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		var sa, sb string
+		if isDigit(a[0]) {
+			sa, a = splitRun(a, true)
+			sb, b = splitRun(b, true)
+			if sb == "" {
+				return 1
+			}
+			if c := compareNumeric(sa, sb); c != 0 {
+				return c
+			}
+			continue
+		}
+		sa, a = splitLetters(a)
+		sb, b = splitLetters(b)
+		if sb == "" {
+			return -1
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	}
+	return 1
+}
+
+func trimRpmSeparators(s string) string {
+	i := 0
+	for i < len(s) && !isDigit(s[i]) && !isLetter(s[i]) && s[i] != '~' && s[i] != '^' {
+		i++
+	}
+	return s[i:]
+}
+
+// splitLetters splits off the leading run of letters.
+func splitLetters(s string) (string, string) {
+	i := 0
+	for i < len(s) && isLetter(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// splitRun splits off the leading run of digits or non-digits.
+func splitRun(s string, digits bool) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
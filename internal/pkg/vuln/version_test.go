@@ -0,0 +1,78 @@
+package vuln
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareDpkg(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0", "1.0+b1", -1},
+		{"1:1.0", "2.0", 1},
+		{"2.36-9+deb12u4", "2.36-9+deb12u7", -1},
+		{"2.36-9", "2.36-9+deb12u1", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0-1", "1.0-1ubuntu1", -1},
+		{"5.2.15-2+b2", "5.2.15-2+b10", -1},
+		{"01.0", "1.0", 0},
+	} {
+		assert.Equal(t, tc.expected, compareDpkg(tc.a, tc.b), "%s <=> %s", tc.a, tc.b)
+		assert.Equal(t, -tc.expected, compareDpkg(tc.b, tc.a), "%s <=> %s", tc.b, tc.a)
+	}
+}
+
+func TestCompareApk(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.4-r2", "1.2.4-r2", 0},
+		{"1.2.4-r2", "1.2.4-r10", -1},
+		{"1.2.4", "1.2.4-r0", 0},
+		{"1.2.4_rc1-r0", "1.2.4-r0", -1},
+		{"1.2.4_p1-r0", "1.2.4-r0", 1},
+		{"1.2.4_alpha2", "1.2.4_beta1", -1},
+		{"3.1.4-r5", "3.1.10-r0", -1},
+	} {
+		assert.Equal(t, tc.expected, compareApk(tc.a, tc.b), "%s <=> %s", tc.a, tc.b)
+		assert.Equal(t, -tc.expected, compareApk(tc.b, tc.a), "%s <=> %s", tc.b, tc.a)
+	}
+}
+
+func TestCompareRpm(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"5.1.8-6.el9", "5.1.8-6.el9", 0},
+		{"5.1.8-6.el9", "5.1.8-9.el9", -1},
+		{"3.0.7-24.el9", "3.0.7-24.el9_2", -1},
+		{"1:3.0.7-24.el9", "3.0.8-1.el9", 1},
+		{"0:3.0.7-24.el9", "3.0.7-24.el9", 0},
+		{"1.10", "1.9", 1},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0.1", -1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0.1", -1},
+		{"1_0", "1.0", 0},
+	} {
+		assert.Equal(t, tc.expected, compareRpm(tc.a, tc.b), "%s <=> %s", tc.a, tc.b)
+		assert.Equal(t, -tc.expected, compareRpm(tc.b, tc.a), "%s <=> %s", tc.b, tc.a)
+	}
+}
+
+func TestCompareVersionsGo(t *testing.T) {
+	assert.Equal(t, -1, compareVersions("golang", "v0.1.0", "v0.10.0"))
+	assert.Equal(t, -1, compareVersions("golang", "go1.21.5", "1.21.6"))
+	assert.Equal(t, 1, compareVersions("golang", "go1.22.0", "1.21.6"))
+	assert.Equal(t, 0, compareVersions("golang", "1.2.3", "v1.2.3"))
+}
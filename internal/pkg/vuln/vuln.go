@@ -0,0 +1,373 @@
+// Package vuln matches the packages of images against a local database of
+// advisories in the OSV format.
+//
+// See https://ossf.github.io/osv-schema/
+package vuln
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/sbom"
+)
+
+// Advisory is an OSV vulnerability entry. Only the fields used for
+// matching are decoded.
+type Advisory struct {
+	ID       string   `json:"id"`
+	Summary  string   `json:"summary"`
+	Aliases  []string `json:"aliases"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected         []Affected             `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+// Affected lists the affected versions of a package.
+type Affected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges []struct {
+		Type   string              `json:"type"`
+		Events []map[string]string `json:"events"`
+	} `json:"ranges"`
+	Versions          []string               `json:"versions"`
+	EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+}
+
+// DB is an in-memory advisory database.
+type DB struct {
+	// entries are indexed by lowercase ecosystem without release and
+	// package name.
+	entries map[string][]entry
+	count   int
+}
+
+type entry struct {
+	advisory *Advisory
+	affected *Affected
+	// release is the ecosystem release like "12" of "Debian:12".
+	release string
+}
+
+// NewDB returns an empty database.
+func NewDB() *DB {
+	return &DB{entries: map[string][]entry{}}
+}
+
+// Len returns the number of advisories in the database.
+func (db *DB) Len() int {
+	return db.count
+}
+
+// Add adds an advisory to the database.
+func (db *DB) Add(a *Advisory) {
+	db.count++
+	for i := range a.Affected {
+		af := &a.Affected[i]
+		parts := strings.SplitN(af.Package.Ecosystem, ":", 3)
+		release := ""
+		if len(parts) > 1 {
+			release = parts[1]
+		}
+		key := strings.ToLower(parts[0]) + "/" + af.Package.Name
+		db.entries[key] = append(db.entries[key], entry{advisory: a, affected: af, release: release})
+	}
+}
+
+// LoadFile loads advisories from a JSON file or a zip archive of JSON files
+// like the exports of osv.dev.
+func LoadFile(name string) (*DB, error) {
+	db := NewDB()
+	if path.Ext(name) == ".zip" {
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if path.Ext(f.Name) != ".json" {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = db.Load(rc)
+			rc.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+		return db, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return db, db.Load(f)
+}
+
+// Load reads a JSON array of advisories or a stream of advisory objects.
+func (db *DB) Load(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(b)) == "" {
+			br.ReadByte()
+			continue
+		}
+		break
+	}
+
+	dec := json.NewDecoder(br)
+	if b, _ := br.Peek(1); b[0] == '[' {
+		var advisories []*Advisory
+		if err := dec.Decode(&advisories); err != nil {
+			return err
+		}
+		for _, a := range advisories {
+			db.Add(a)
+		}
+		return nil
+	}
+	for {
+		a := &Advisory{}
+		err := dec.Decode(a)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		db.Add(a)
+	}
+}
+
+// Finding is a vulnerability affecting a package.
+type Finding struct {
+	ID           string   `json:"id"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Package      string   `json:"package"`
+	Version      string   `json:"version"`
+	FixedVersion string   `json:"fixedVersion,omitempty"`
+	Severity     Severity `json:"severity"`
+	Score        float64  `json:"score,omitempty"`
+	Summary      string   `json:"summary,omitempty"`
+	Location     string   `json:"location,omitempty"`
+}
+
+// Report lists the vulnerabilities found in an image.
+type Report struct {
+	Image           string         `json:"image"`
+	Platform        string         `json:"platform,omitempty"`
+	OS              string         `json:"os,omitempty"`
+	Packages        int            `json:"packages"`
+	Vulnerabilities []Finding      `json:"vulnerabilities"`
+	Summary         map[string]int `json:"summary"`
+	Errors          []string       `json:"errors,omitempty"`
+}
+
+// AtLeast returns the findings with at least the given severity.
+func (r Report) AtLeast(threshold Severity) []Finding {
+	findings := []Finding{}
+	for _, f := range r.Vulnerabilities {
+		if f.Severity >= threshold {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// Scan matches the packages of the image against the database.
+func (db *DB) Scan(img sbom.Image) Report {
+	r := Report{
+		Image:           img.Name,
+		Platform:        img.Platform,
+		OS:              strings.TrimSpace(img.OS.ID + " " + img.OS.VersionID),
+		Packages:        len(img.Packages),
+		Vulnerabilities: []Finding{},
+		Summary:         map[string]int{},
+		Errors:          img.Errors,
+	}
+
+	seen := map[string]bool{}
+	for _, p := range img.Packages {
+		ecosystem, release := ecosystemOf(p, img.OS)
+		names := []string{p.Name}
+		if p.Source != "" {
+			names = append(names, p.Source)
+		}
+		for _, name := range names {
+			for _, e := range db.entries[ecosystem+"/"+name] {
+				if e.release != "" && release != "" && e.release != release {
+					continue
+				}
+				affected, fixed := isAffected(e.affected, p.Type, p.Version)
+				key := e.advisory.ID + " " + p.Name + " " + p.Location
+				if !affected || seen[key] {
+					continue
+				}
+				seen[key] = true
+				severity, score := severityOf(e.advisory, e.affected)
+				r.Vulnerabilities = append(r.Vulnerabilities, Finding{
+					ID:           e.advisory.ID,
+					Aliases:      e.advisory.Aliases,
+					Package:      p.Name,
+					Version:      p.Version,
+					FixedVersion: fixed,
+					Severity:     severity,
+					Score:        score,
+					Summary:      e.advisory.Summary,
+					Location:     p.Location,
+				})
+				r.Summary[severity.String()]++
+			}
+		}
+	}
+
+	sort.SliceStable(r.Vulnerabilities, func(i, j int) bool {
+		a, b := r.Vulnerabilities[i], r.Vulnerabilities[j]
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		return a.ID < b.ID
+	})
+	return r
+}
+
+// ecosystemOf returns the lowercase OSV ecosystem and release of a package.
+func ecosystemOf(p sbom.Package, os sbom.OS) (string, string) {
+	switch p.Type {
+	case sbom.TypeDeb:
+		if os.ID == "ubuntu" {
+			return "ubuntu", os.VersionID
+		}
+		return "debian", strings.SplitN(os.VersionID, ".", 2)[0]
+	case sbom.TypeApk:
+		parts := strings.SplitN(os.VersionID, ".", 3)
+		if len(parts) < 2 {
+			return "alpine", ""
+		}
+		return "alpine", "v" + parts[0] + "." + parts[1]
+	case sbom.TypeGolang:
+		return "go", ""
+	case sbom.TypeRpm:
+		major := strings.SplitN(os.VersionID, ".", 2)[0]
+		switch os.ID {
+		case "rhel":
+			// Red Hat advisories name products like "enterprise_linux"
+			// instead of releases.
+			return "red hat", ""
+		case "rocky":
+			return "rocky linux", major
+		case "almalinux":
+			return "almalinux", major
+		}
+	}
+	return p.Type, ""
+}
+
+// isAffected evaluates the affected versions and ranges. It returns the
+// first fixed version above the version, if any.
+func isAffected(af *Affected, typ, version string) (bool, string) {
+	for _, v := range af.Versions {
+		if v == version {
+			return true, ""
+		}
+	}
+
+	for _, rng := range af.Ranges {
+		if rng.Type != "ECOSYSTEM" && rng.Type != "SEMVER" {
+			continue
+		}
+		// Events are evaluated in version order, see
+		// https://ossf.github.io/osv-schema/#evaluation
+		events := append([]map[string]string{}, rng.Events...)
+		sort.SliceStable(events, func(i, j int) bool {
+			vi, vj := eventVersion(events[i]), eventVersion(events[j])
+			if vi == "0" || vj == "0" {
+				return vi == "0" && vj != "0"
+			}
+			return compareVersions(typ, vi, vj) < 0
+		})
+
+		affected := false
+		fixed := ""
+		for _, ev := range events {
+			switch {
+			case ev["introduced"] != "":
+				if ev["introduced"] == "0" || compareVersions(typ, version, ev["introduced"]) >= 0 {
+					affected = true
+				}
+			case ev["fixed"] != "":
+				if compareVersions(typ, version, ev["fixed"]) >= 0 {
+					affected = false
+				} else if fixed == "" {
+					fixed = ev["fixed"]
+				}
+			case ev["last_affected"] != "":
+				if compareVersions(typ, version, ev["last_affected"]) > 0 {
+					affected = false
+				}
+			}
+		}
+		if affected {
+			return true, fixed
+		}
+	}
+	return false, ""
+}
+
+func eventVersion(ev map[string]string) string {
+	for _, k := range []string{"introduced", "fixed", "last_affected", "limit"} {
+		if v, ok := ev[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// severityOf returns the highest severity given by CVSS v3 vectors or
+// severity ratings of the advisory.
+func severityOf(a *Advisory, af *Affected) (Severity, float64) {
+	severity, score := SeverityUnknown, 0.0
+	for _, s := range a.Severity {
+		if s.Type != "CVSS_V3" {
+			continue
+		}
+		if sc, err := cvss3Score(s.Score); err == nil && sc > score {
+			score = sc
+			if sev := severityForScore(sc); sev > severity {
+				severity = sev
+			}
+		}
+	}
+	for _, m := range []map[string]interface{}{af.EcosystemSpecific, af.DatabaseSpecific, a.DatabaseSpecific} {
+		s, _ := m["severity"].(string)
+		if sev, err := ParseSeverity(s); err == nil && sev > severity {
+			severity = sev
+		}
+	}
+	return severity, score
+}
@@ -0,0 +1,146 @@
+package vuln
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/internal/pkg/sbom"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdvisories = `[
+  {
+    "id": "DSA-0001-1",
+    "summary": "glibc buffer overflow",
+    "aliases": ["CVE-2024-0001"],
+    "severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}],
+    "affected": [{
+      "package": {"ecosystem": "Debian:12", "name": "glibc"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"fixed": "2.36-9+deb12u7"}, {"introduced": "0"}]}]
+    }]
+  },
+  {
+    "id": "DSA-0002-1",
+    "summary": "only affects bullseye",
+    "affected": [{
+      "package": {"ecosystem": "Debian:11", "name": "bash"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "9.9"}]}]
+    }]
+  },
+  {
+    "id": "GO-2024-0001",
+    "affected": [{
+      "package": {"ecosystem": "Go", "name": "golang.org/x/net"},
+      "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "0.23.0"}]}],
+      "database_specific": {"severity": "MODERATE"}
+    }]
+  },
+  {
+    "id": "GO-2024-0002",
+    "affected": [{
+      "package": {"ecosystem": "Go", "name": "golang.org/x/net"},
+      "ranges": [{"type": "SEMVER", "events": [{"introduced": "0.30.0"}, {"last_affected": "0.31.0"}]}]
+    }]
+  },
+  {
+    "id": "ALPINE-CVE-2024-0003",
+    "affected": [{
+      "package": {"ecosystem": "Alpine:v3.19", "name": "openssl"},
+      "versions": ["3.1.4-r5"]
+    }]
+  },
+  {
+    "id": "RLSA-2024:0001",
+    "affected": [{
+      "package": {"ecosystem": "Rocky Linux:9", "name": "openssl"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1:3.0.7-25.el9_3"}]}]
+    }]
+  }
+]`
+
+func TestScan(t *testing.T) {
+	db := NewDB()
+	assert.NoError(t, db.Load(strings.NewReader(testAdvisories)))
+	assert.Equal(t, 6, db.Len())
+
+	report := db.Scan(sbom.Image{
+		Name: "docker.io/library/app:latest",
+		OS:   sbom.OS{ID: "debian", VersionID: "12"},
+		Packages: []sbom.Package{
+			{Type: sbom.TypeDeb, Name: "libc6", Source: "glibc", Version: "2.36-9+deb12u4", Location: "/var/lib/dpkg/status"},
+			{Type: sbom.TypeDeb, Name: "libc-bin", Source: "glibc", Version: "2.36-9+deb12u7", Location: "/var/lib/dpkg/status"},
+			{Type: sbom.TypeDeb, Name: "bash", Version: "5.2.15-2+b2", Location: "/var/lib/dpkg/status"},
+			{Type: sbom.TypeGolang, Name: "golang.org/x/net", Version: "v0.17.0", Location: "/usr/bin/app"},
+			{Type: sbom.TypeGolang, Name: "golang.org/x/net", Version: "v0.30.0", Location: "/usr/bin/other"},
+		},
+	})
+
+	assert.Equal(t, "debian 12", report.OS)
+	assert.Equal(t, 5, report.Packages)
+	assert.Equal(t, []Finding{
+		{
+			ID: "DSA-0001-1", Aliases: []string{"CVE-2024-0001"}, Package: "libc6", Version: "2.36-9+deb12u4",
+			FixedVersion: "2.36-9+deb12u7", Severity: SeverityCritical, Score: 9.8, Summary: "glibc buffer overflow", Location: "/var/lib/dpkg/status",
+		},
+		{ID: "GO-2024-0001", Package: "golang.org/x/net", Version: "v0.17.0", FixedVersion: "0.23.0", Severity: SeverityMedium, Location: "/usr/bin/app"},
+		{ID: "GO-2024-0002", Package: "golang.org/x/net", Version: "v0.30.0", Severity: SeverityUnknown, Location: "/usr/bin/other"},
+	}, report.Vulnerabilities)
+	assert.Equal(t, map[string]int{"critical": 1, "medium": 1, "unknown": 1}, report.Summary)
+	assert.Len(t, report.AtLeast(SeverityHigh), 1)
+	assert.Len(t, report.AtLeast(SeverityUnknown), 3)
+
+	report = db.Scan(sbom.Image{
+		OS:       sbom.OS{ID: "alpine", VersionID: "3.19.1"},
+		Packages: []sbom.Package{{Type: sbom.TypeApk, Name: "ssl_client", Source: "openssl", Version: "3.1.4-r5"}},
+	})
+	if assert.Len(t, report.Vulnerabilities, 1) {
+		assert.Equal(t, "ALPINE-CVE-2024-0003", report.Vulnerabilities[0].ID)
+	}
+
+	report = db.Scan(sbom.Image{
+		OS: sbom.OS{ID: "rocky", VersionID: "9.3"},
+		Packages: []sbom.Package{
+			{Type: sbom.TypeRpm, Name: "openssl-libs", Source: "openssl", Version: "1:3.0.7-24.el9"},
+			{Type: sbom.TypeRpm, Name: "openssl", Version: "1:3.0.7-25.el9_3"},
+		},
+		Errors: []string{"layer a.tar could not be scanned: unexpected EOF"},
+	})
+	if assert.Len(t, report.Vulnerabilities, 1) {
+		assert.Equal(t, Finding{ID: "RLSA-2024:0001", Package: "openssl-libs", Version: "1:3.0.7-24.el9", FixedVersion: "1:3.0.7-25.el9_3"}, report.Vulnerabilities[0])
+	}
+	assert.Equal(t, []string{"layer a.tar could not be scanned: unexpected EOF"}, report.Errors)
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	stream := filepath.Join(dir, "advisories.json")
+	assert.NoError(t, os.WriteFile(stream, []byte(`{"id": "A-1"}
+{"id": "A-2"}`), 0644))
+	db, err := LoadFile(stream)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, db.Len())
+
+	archive := filepath.Join(dir, "all.zip")
+	f, err := os.Create(archive)
+	assert.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"A-1.json", "A-2.json", "README"} {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		w.Write([]byte(`{"id": "` + name + `"}`))
+	}
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, f.Close())
+
+	db, err = LoadFile(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, db.Len())
+
+	db = NewDB()
+	assert.Error(t, db.Load(strings.NewReader(`[{"id": 1}]`)))
+	assert.NoError(t, db.Load(strings.NewReader("  \n")))
+}
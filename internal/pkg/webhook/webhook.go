@@ -0,0 +1,263 @@
+// Package webhook delivers JSON events signed with HMAC-SHA256 to HTTP
+// endpoints.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// HeaderEvent holds the type of the event.
+	HeaderEvent = "X-Saveomat-Event"
+	// HeaderDelivery holds a random ID, which is the same for all attempts
+	// to deliver an event.
+	HeaderDelivery = "X-Saveomat-Delivery"
+	// HeaderSignature holds "sha256=" followed by the hex encoded
+	// HMAC-SHA256 of the body. It is only set if a secret is configured.
+	HeaderSignature = "X-Saveomat-Signature"
+
+	// DefaultAttempts is the number of delivery attempts if not configured.
+	DefaultAttempts = 5
+	// DefaultBackoff is the delay before the first retry if not configured.
+	DefaultBackoff = time.Second
+)
+
+// Sender delivers events. Failed deliveries are retried with exponential
+// backoff.
+type Sender struct {
+	// HTTPClient defaults to http.DefaultClient, or to a client connecting
+	// only to public addresses if PublicOnly is set.
+	HTTPClient *http.Client
+	// Secret signs the events if set.
+	Secret []byte
+	// PublicOnly refuses URLs of loopback, private, link-local and other
+	// non-public addresses, which must not be reachable through URLs given
+	// by users of the server.
+	PublicOnly bool
+	// Attempts defaults to DefaultAttempts.
+	Attempts int
+	// Backoff is doubled after every failed attempt. Defaults to
+	// DefaultBackoff.
+	Backoff time.Duration
+}
+
+// Error is a response to a delivery other than 2xx.
+type Error struct {
+	StatusCode int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("webhook: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Send posts the JSON encoded payload to the URL. Network errors and 5xx and
+// 429 responses are retried until the attempts are exhausted or ctx is done.
+func (s *Sender) Send(ctx context.Context, url, event string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	delivery := hex.EncodeToString(id)
+
+	attempts := s.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	for i := 1; ; i++ {
+		err = s.post(ctx, url, event, delivery, body)
+		if err == nil || !retryable(err) || i == attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *Sender) post(ctx context.Context, url, event, delivery string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderDelivery, delivery)
+	if len(s.Secret) > 0 {
+		req.Header.Set(HeaderSignature, Sign(s.Secret, body))
+	}
+
+	client := s.HTTPClient
+	switch {
+	case client != nil:
+	case s.PublicOnly:
+		client = publicClient
+	default:
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &Error{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotPublic) {
+		return false
+	}
+	e, ok := err.(*Error)
+	if !ok {
+		_, ok := err.(*url.Error)
+		return ok
+	}
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Sign returns the value of HeaderSignature for the body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the value of HeaderSignature in constant time.
+func Verify(secret, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// ValidateURL checks that the URL is an absolute http or https URL.
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q, expected an http or https URL", rawURL)
+	}
+	return nil
+}
+
+// ValidateURL checks the URL like the package level ValidateURL. If
+// PublicOnly is set, URLs of localhost or of non-public IP addresses are
+// refused as well. Host names are checked again when connecting.
+func (s *Sender) ValidateURL(rawURL string) error {
+	if err := ValidateURL(rawURL); err != nil {
+		return err
+	}
+	if !s.PublicOnly {
+		return nil
+	}
+	u, _ := url.Parse(rawURL)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ip := net.ParseIP(host); (ip != nil && !IsPublic(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("invalid webhook URL %q: %w", rawURL, ErrNotPublic)
+	}
+	return nil
+}
+
+// ErrNotPublic is returned for webhooks of non-public addresses if
+// PublicOnly is set.
+var ErrNotPublic = errors.New("address is not public")
+
+// nonPublic are the networks not reachable through webhooks given by users,
+// including the cloud metadata endpoints in the link-local networks.
+var nonPublic = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // this network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier-grade NAT
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"224.0.0.0/3",    // multicast and reserved
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"64:ff9b::/96",   // NAT64, may embed any of the above
+		"64:ff9b:1::/48", // local-use NAT64
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+		"ff00::/8",       // multicast
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// IsPublic reports whether the IP address is not in a loopback, private,
+// link-local or otherwise reserved network.
+func IsPublic(ip net.IP) bool {
+	for _, n := range nonPublic {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicClient connects only to public addresses. The addresses are checked
+// after resolving, so host names resolving to internal addresses and
+// redirects to them are refused too. Proxies are not used, as they would
+// connect on behalf of the client.
+var publicClient = func() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+				return fmt.Errorf("connecting to %s: %w", host, ErrNotPublic)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport}
+}()
@@ -0,0 +1,143 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSend(t *testing.T) {
+	var bodies []string
+	var deliveries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		deliveries = append(deliveries, r.Header.Get(webhook.HeaderDelivery))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "bundle.succeeded", r.Header.Get(webhook.HeaderEvent))
+		assert.True(t, webhook.Verify([]byte("secret"), body, r.Header.Get(webhook.HeaderSignature)))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	subject := &webhook.Sender{Secret: []byte("secret"), Backoff: time.Millisecond}
+	err := subject.Send(context.Background(), srv.URL, "bundle.succeeded", map[string]int{"size": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"size":1}`, `{"size":1}`, `{"size":1}`}, bodies)
+	assert.NotEmpty(t, deliveries[0])
+	assert.Equal(t, deliveries[0], deliveries[2])
+}
+
+func TestSendGivesUp(t *testing.T) {
+	calls := 0
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Empty(t, r.Header.Get(webhook.HeaderSignature))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	subject := &webhook.Sender{Attempts: 3, Backoff: time.Millisecond}
+	err := subject.Send(context.Background(), srv.URL, "bundle.failed", nil)
+	assert.EqualError(t, err, "webhook: 503 Service Unavailable")
+	assert.Equal(t, 3, calls)
+
+	calls, status = 0, http.StatusNotFound
+	err = subject.Send(context.Background(), srv.URL, "bundle.failed", nil)
+	var whErr *webhook.Error
+	assert.True(t, errors.As(err, &whErr))
+	assert.Equal(t, 1, calls, "client errors are not retried")
+}
+
+func TestSendPublicOnly(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	subject := &webhook.Sender{PublicOnly: true, Backoff: time.Millisecond}
+	err := subject.Send(context.Background(), srv.URL, "bundle.succeeded", nil)
+	assert.True(t, errors.Is(err, webhook.ErrNotPublic), err)
+	assert.Zero(t, calls)
+
+	// Host names are checked after resolving them.
+	u, err := url.Parse(srv.URL)
+	assert.NoError(t, err)
+	err = subject.Send(context.Background(), "http://localhost:"+u.Port(), "bundle.succeeded", nil)
+	assert.True(t, errors.Is(err, webhook.ErrNotPublic), err)
+	assert.Zero(t, calls)
+}
+
+func TestSign(t *testing.T) {
+	// Computed with `printf '{}' | openssl dgst -sha256 -hmac secret`.
+	signature := webhook.Sign([]byte("secret"), []byte("{}"))
+	assert.Equal(t, "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13", signature)
+	assert.True(t, webhook.Verify([]byte("secret"), []byte("{}"), signature))
+	assert.False(t, webhook.Verify([]byte("other"), []byte("{}"), signature))
+	assert.False(t, webhook.Verify([]byte("secret"), []byte("{} "), signature))
+	assert.False(t, webhook.Verify([]byte("secret"), []byte("{}"), signature[7:]))
+}
+
+func TestValidateURL(t *testing.T) {
+	assert.NoError(t, webhook.ValidateURL("https://ci.example.com/hooks/saveomat?token=1"))
+	assert.Error(t, webhook.ValidateURL("ftp://ci.example.com/"))
+	assert.Error(t, webhook.ValidateURL("/hooks"))
+	assert.Error(t, webhook.ValidateURL("http://"))
+}
+
+func TestSenderValidateURL(t *testing.T) {
+	subject := &webhook.Sender{}
+	assert.NoError(t, subject.ValidateURL("http://127.0.0.1:8080/hooks"))
+	assert.Error(t, subject.ValidateURL("ftp://ci.example.com/"))
+
+	subject.PublicOnly = true
+	assert.NoError(t, subject.ValidateURL("https://ci.example.com/hooks/saveomat"))
+	assert.NoError(t, subject.ValidateURL("http://203.0.113.1/hooks"))
+	for _, u := range []string{
+		"http://127.0.0.1:8080/hooks",
+		"http://localhost/hooks",
+		"http://LOCALHOST./hooks",
+		"http://app.localhost/hooks",
+		"http://10.0.0.1/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/",
+		"http://[::ffff:127.0.0.1]/",
+		"http://[fd00:ec2::254]/",
+		"http://[64:ff9b::a9fe:a9fe]/latest/meta-data/",
+		"http://0.0.0.0/",
+	} {
+		assert.True(t, errors.Is(subject.ValidateURL(u), webhook.ErrNotPublic), u)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"203.0.113.1":        true,
+		"8.8.8.8":            true,
+		"2001:db8::1":        true,
+		"127.0.0.1":          false,
+		"192.168.1.1":        false,
+		"172.31.0.1":         false,
+		"100.64.0.1":         false,
+		"169.254.169.254":    false,
+		"fe80::1":            false,
+		"::":                 false,
+		"64:ff9b::a9fe:a9fe": false,
+		"64:ff9b:1::a00:1":   false,
+	} {
+		assert.Equal(t, public, webhook.IsPublic(net.ParseIP(ip)), ip)
+	}
+}
@@ -1,22 +1,320 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/bastjan/saveomat/internal/pkg/server"
+	"github.com/bastjan/saveomat/internal/pkg/archive"
+	"github.com/bastjan/saveomat/internal/pkg/cosign"
+	"github.com/bastjan/saveomat/internal/pkg/delta"
+	"github.com/bastjan/saveomat/internal/pkg/split"
+	"github.com/bastjan/saveomat/pkg/server"
+	"github.com/docker/cli/cli/config"
 	"github.com/docker/docker/client"
 )
 
 func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "serve":
+		serve()
+	case "verify":
+		err = verify(args)
+	case "reconstruct":
+		err = reconstruct(args)
+	case "join":
+		err = join(args)
+	case "fetch":
+		err = fetch(args)
+	case "bundle":
+		err = bundleImages(args)
+	default:
+		err = fmt.Errorf("unknown command %q, expected one of: serve, verify, reconstruct, join, fetch, bundle", cmd)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func serve() {
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		panic("Could not initialize docker client.")
 	}
 
-	e := server.NewServer(server.ServerOpts{
+	opts := server.ServerOpts{
 		DockerClient: cli,
 		BaseURL:      os.Getenv("BASE_URL"),
-	})
+		Mirrors:      os.Getenv("REGISTRY_MIRRORS"),
+		SpoolDir:     os.Getenv("SPOOL_DIR"),
+		VulnDB:       os.Getenv("VULN_DB"),
+		FailOn:       os.Getenv("VULN_FAIL_SEVERITY"),
+		ListsDir:     os.Getenv("LISTS_DIR"),
+		ListsToken:   os.Getenv("LISTS_TOKEN"),
+		PushLimit:    os.Getenv("PUSH_LIMIT"),
+	}
+	if keyFile := os.Getenv("SIGNING_KEY_FILE"); keyFile != "" {
+		pem, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			panic(err)
+		}
+		opts.SigningKey, err = archive.ParsePrivateKey(pem)
+		if err != nil {
+			panic(fmt.Errorf("parsing %s: %w", keyFile, err))
+		}
+	}
+
+	for _, keyFile := range strings.Split(os.Getenv("COSIGN_PUBLIC_KEYS"), ",") {
+		if keyFile = strings.TrimSpace(keyFile); keyFile == "" {
+			continue
+		}
+		pem, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			panic(err)
+		}
+		keys, err := cosign.ParsePublicKeys(pem)
+		if err != nil {
+			panic(fmt.Errorf("parsing %s: %w", keyFile, err))
+		}
+		opts.CosignKeys = append(opts.CosignKeys, keys...)
+	}
+
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		if opts.ObjectStorage, err = objectStorage(endpoint); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, hook := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if hook = strings.TrimSpace(hook); hook != "" {
+			opts.Webhooks = append(opts.Webhooks, hook)
+		}
+	}
+	if secret := os.Getenv("WEBHOOK_SECRET"); secret != "" {
+		opts.WebhookSecret = []byte(secret)
+	}
+
+	if authFile := os.Getenv("LISTS_AUTH_FILE"); authFile != "" {
+		f, err := os.Open(authFile)
+		if err != nil {
+			panic(err)
+		}
+		opts.ListsAuth, err = config.LoadFromReader(f)
+		f.Close()
+		if err != nil {
+			panic(fmt.Errorf("parsing %s: %w", authFile, err))
+		}
+	}
 
+	e, err := server.NewServer(opts)
+	if err != nil {
+		panic(err)
+	}
+	go e.RunScheduler(context.Background())
 	e.Logger.Fatal(e.Start(":8080"))
 }
+
+func objectStorage(endpoint string) (*server.ObjectStorage, error) {
+	st := &server.ObjectStorage{
+		Endpoint:  endpoint,
+		Region:    os.Getenv("S3_REGION"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Prefix:    os.Getenv("S3_PREFIX"),
+	}
+	var err error
+	if size := os.Getenv("S3_PART_SIZE"); size != "" {
+		if st.PartSize, err = split.ParseSize(size); err != nil {
+			return nil, fmt.Errorf("parsing S3_PART_SIZE: %w", err)
+		}
+	}
+	if expiry := os.Getenv("S3_URL_EXPIRY"); expiry != "" {
+		if st.PresignExpiry, err = time.ParseDuration(expiry); err != nil {
+			return nil, fmt.Errorf("parsing S3_URL_EXPIRY: %w", err)
+		}
+	}
+	return st, nil
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyFile := fs.String("key", "", "PEM encoded Ed25519 public key the archive must be signed with")
+	sha := fs.String("sha256", "", "expected SHA-256 of the whole archive, as sent in the X-Saveomat-Sha256 header")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: saveomat verify [-key public.pem] [-sha256 checksum] images.tar")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var key ed25519.PublicKey
+	if *keyFile != "" {
+		pem, err := ioutil.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		key, err = archive.ParsePublicKey(pem)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", *keyFile, err)
+		}
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := archive.Verify(f, key)
+	if err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(res.SHA256)
+	if *sha != "" && *sha != sum {
+		return fmt.Errorf("archive checksum %s does not match expected %s", sum, *sha)
+	}
+
+	fmt.Printf("%s: OK, %d files", fs.Arg(0), res.Files)
+	if res.Signed {
+		fmt.Print(", signature valid")
+	}
+	fmt.Printf(", sha256 %s\n", sum)
+	return nil
+}
+
+func reconstruct(args []string) error {
+	fs := flag.NewFlagSet("reconstruct", flag.ExitOnError)
+	storeDir := fs.String("store", "", "directory of the layer store")
+	keyFile := fs.String("key", "", "PEM encoded Ed25519 public key the archive must be signed with")
+	output := fs.String("o", "-", "file to write the full archive to, - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: saveomat reconstruct -store dir [-key public.pem] [-o images.tar] delta.tar")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *storeDir == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	contents, err := delta.Inspect(f)
+	if err != nil {
+		return err
+	}
+	if contents.Checksums || *keyFile != "" {
+		var key ed25519.PublicKey
+		if *keyFile != "" {
+			pem, err := ioutil.ReadFile(*keyFile)
+			if err != nil {
+				return err
+			}
+			if key, err = archive.ParsePublicKey(pem); err != nil {
+				return fmt.Errorf("parsing %s: %w", *keyFile, err)
+			}
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := archive.Verify(f, key); err != nil {
+			return err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if err := delta.Reconstruct(out, f, contents, delta.Store{Dir: *storeDir}); err != nil {
+		return err
+	}
+
+	restored := 0
+	if contents.Delta != nil {
+		restored = len(contents.Delta.Omitted)
+	}
+	fmt.Fprintf(os.Stderr, "%s: restored %d layers from %s\n", fs.Arg(0), restored, *storeDir)
+	if out != os.Stdout {
+		return out.Close()
+	}
+	return nil
+}
+
+func join(args []string) error {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	output := fs.String("o", "", "file to write the joined archive to, - for stdout; verifies only if empty")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: saveomat join [-o images.tar] images.zip|images.tar.parts.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	open := split.OpenManifest
+	if strings.HasSuffix(fs.Arg(0), ".zip") {
+		open = split.OpenZip
+	}
+	set, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer set.Close()
+
+	var out io.Writer = ioutil.Discard
+	switch *output {
+	case "":
+	case "-":
+		out = os.Stdout
+	default:
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := set.Join(out); err != nil {
+		return err
+	}
+	if f, ok := out.(*os.File); ok && f != os.Stdout {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: OK, %d parts, sha256 %s\n", set.Manifest.Name, len(set.Manifest.Parts), set.Manifest.SHA256)
+	return nil
+}
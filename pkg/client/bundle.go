@@ -0,0 +1,164 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bastjan/saveomat/internal/pkg/imagelist"
+	"github.com/docker/cli/cli/config/configfile"
+)
+
+const (
+	// FormatDocker is the format written by `docker save`.
+	FormatDocker = "docker"
+	// FormatOCI is an OCI image layout.
+	FormatOCI = "oci"
+
+	// CompressionNone leaves the archive uncompressed.
+	CompressionNone = "none"
+	// CompressionGzip compresses the archive with gzip.
+	CompressionGzip = "gzip"
+)
+
+// Options are the typed parameters of an archive request.
+type Options struct {
+	// Images are lines in the format of images.txt, e.g. "busybox" or
+	// "nginx:1.25 => registry.airgap.local/nginx:1.25 platform=linux/arm64".
+	// Includes are not supported.
+	Images []string
+	// Auth holds the credentials for pulling the images, if any. Only the
+	// credentials of the requested images are sent, see Credentials.
+	Auth *configfile.ConfigFile
+	// Platform is the default platform of the images, e.g. "linux/arm64".
+	Platform string
+	// Format is FormatDocker or FormatOCI, defaults to FormatDocker.
+	Format string
+	// Compression is CompressionNone or CompressionGzip, defaults to
+	// CompressionNone.
+	Compression string
+	// RetagPrefix moves the images below the given registry.
+	RetagPrefix string
+	// Checksums embeds a SHA256SUMS file in the archive.
+	Checksums bool
+}
+
+// Request returns the request for the archive. Syntax errors in the images
+// are returned without contacting the server.
+func (o Options) Request() (Request, error) {
+	list := []byte(strings.Join(o.Images, "\n") + "\n")
+	entries, err := imagelist.Parse("images.txt", bytes.NewReader(list), nil)
+	if err != nil {
+		return Request{}, err
+	}
+
+	req := Request{
+		Uploads: []Upload{{Field: "images.txt", Filename: "images.txt", Content: list}},
+		Params:  url.Values{},
+	}
+	for name, v := range map[string]string{"platform": o.Platform, "format": o.Format, "compression": o.Compression, "retag-prefix": o.RetagPrefix} {
+		if v != "" {
+			req.Params.Set(name, v)
+		}
+	}
+	if o.Checksums {
+		req.Params.Set("checksums", "true")
+	}
+	if o.Auth != nil {
+		images := make([]string, 0, len(entries))
+		for _, e := range entries {
+			images = append(images, e.Source)
+		}
+		creds, err := Credentials(o.Auth, images)
+		if err != nil {
+			return Request{}, err
+		}
+		req.Uploads = append(req.Uploads, Upload{Field: "config.json", Filename: "config.json", Content: creds})
+	}
+	return req, nil
+}
+
+// Archive is an archive received from the server. It must be closed.
+type Archive struct {
+	io.ReadCloser
+	// Images are the names of the bundled images.
+	Images []string
+	// Filename is the name suggested by the server, e.g. "images.tar.gz".
+	Filename    string
+	ContentType string
+	// Size is -1 if unknown.
+	Size int64
+	// SHA256 is the hex encoded checksum of the archive. It is set once the
+	// archive has been read completely.
+	SHA256 string
+}
+
+// Bundle requests an archive of the images, see Open.
+func (c *Client) Bundle(ctx context.Context, opts Options) (*Archive, error) {
+	req, err := opts.Request()
+	if err != nil {
+		return nil, err
+	}
+	return c.Open(ctx, req)
+}
+
+// Open requests the archive without retrying. Reading the end of the archive
+// returns ErrChecksumMismatch if it does not match the checksum sent by the
+// server.
+func (c *Client) Open(ctx context.Context, req Request) (*Archive, error) {
+	body, contentType, err := req.encode()
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("tar"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", contentType)
+	resp, err := c.do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		Images:      result(resp).Images,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		a.Filename = params["filename"]
+	}
+	a.ReadCloser = &checkedBody{archive: a, resp: resp, hash: sha256.New()}
+	return a, nil
+}
+
+// checkedBody checks the body against the checksum of the server once it has
+// been read.
+type checkedBody struct {
+	archive *Archive
+	resp    *http.Response
+	hash    hash.Hash
+}
+
+func (b *checkedBody) Read(p []byte) (int, error) {
+	n, err := b.resp.Body.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF {
+		b.archive.SHA256 = hex.EncodeToString(b.hash.Sum(nil))
+		if err := check(b.resp, b.archive.SHA256); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+func (b *checkedBody) Close() error {
+	return b.resp.Body.Close()
+}
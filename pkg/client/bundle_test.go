@@ -0,0 +1,99 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/bastjan/saveomat/pkg/client"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsRequest(t *testing.T) {
+	req, err := client.Options{
+		Images:      []string{"busybox", "test.io/app:1.0 => registry.airgap.local/app:1.0"},
+		Auth:        &configfile.ConfigFile{AuthConfigs: map[string]types.AuthConfig{"test.io": {Username: "test", Password: "test"}}},
+		Platform:    "linux/arm64",
+		Format:      client.FormatOCI,
+		Compression: client.CompressionGzip,
+		Checksums:   true,
+	}.Request()
+	assert.NoError(t, err)
+	assert.Equal(t, "linux/arm64", req.Params.Get("platform"))
+	assert.Equal(t, "oci", req.Params.Get("format"))
+	assert.Equal(t, "gzip", req.Params.Get("compression"))
+	assert.Equal(t, "true", req.Params.Get("checksums"))
+	assert.Empty(t, req.Params.Get("retag-prefix"))
+	if assert.Len(t, req.Uploads, 2) {
+		assert.Equal(t, "busybox\ntest.io/app:1.0 => registry.airgap.local/app:1.0\n", string(req.Uploads[0].Content))
+		assert.Equal(t, "config.json", req.Uploads[1].Field)
+		assert.Equal(t, map[string]string{"test.io/app": "test:test"}, decodeAuths(t, req.Uploads[1].Content))
+	}
+
+	_, err = client.Options{Images: []string{"busybox unknown=option"}}.Request()
+	assert.Error(t, err)
+}
+
+func TestBundle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseMultipartForm(1<<20))
+		assert.Equal(t, "gzip", r.FormValue("compression"))
+		w.Header().Set("Content-Disposition", `attachment; filename="images.tar.gz"`)
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set(client.HeaderImages, "docker.io/library/busybox:latest")
+		w.Header().Set(client.HeaderSHA256, sha256Hex(archive))
+		w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+		w.Write(archive)
+	}))
+	defer srv.Close()
+	subject := newClient(t, srv)
+
+	a, err := subject.Bundle(context.Background(), client.Options{Images: []string{"busybox"}, Compression: client.CompressionGzip})
+	assert.NoError(t, err)
+	defer a.Close()
+	assert.Equal(t, "images.tar.gz", a.Filename)
+	assert.Equal(t, "application/gzip", a.ContentType)
+	assert.Equal(t, []string{"docker.io/library/busybox:latest"}, a.Images)
+	assert.Equal(t, int64(len(archive)), a.Size)
+
+	b, err := ioutil.ReadAll(a)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+	assert.Equal(t, sha256Hex(archive), a.SHA256)
+}
+
+func TestBundleChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(client.HeaderSHA256, sha256Hex([]byte("other")))
+		w.Write(archive)
+	}))
+	defer srv.Close()
+	subject := newClient(t, srv)
+
+	a, err := subject.Bundle(context.Background(), client.Options{Images: []string{"busybox"}})
+	assert.NoError(t, err)
+	defer a.Close()
+	_, err = ioutil.ReadAll(a)
+	assert.True(t, errors.Is(err, client.ErrChecksumMismatch))
+}
+
+func TestBundleError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"invalid format"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	subject := newClient(t, srv)
+
+	_, err := subject.Bundle(context.Background(), client.Options{Images: []string{"busybox"}, Format: "zip"})
+	var e *client.Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, http.StatusBadRequest, e.StatusCode)
+		assert.Equal(t, "invalid format", e.Message)
+	}
+}
@@ -0,0 +1,458 @@
+// Package client downloads archives from a saveomat server. Interrupted
+// downloads of archives with an ETag are resumed using range requests and
+// verified against the checksum sent by the server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderImages lists the images contained in an archive.
+	HeaderImages = "X-Saveomat-Images"
+	// HeaderSHA256 holds the hex encoded SHA-256 of the whole archive.
+	HeaderSHA256 = "X-Saveomat-Sha256"
+
+	// DefaultAttempts is the number of download attempts if not configured.
+	DefaultAttempts = 5
+)
+
+type Client struct {
+	// URL is the base URL of the server, including its BASE_URL.
+	URL        *url.URL
+	HTTPClient *http.Client
+	// Token is sent as bearer token. Servers with a LISTS_TOKEN require it
+	// for stored lists.
+	Token string
+	// Attempts defaults to DefaultAttempts.
+	Attempts int
+	// Backoff is the delay before the first retry. It doubles on every
+	// retry. Defaults to one second.
+	Backoff time.Duration
+	// Progress is called while downloading with the number of bytes
+	// written and the size of the archive, which is -1 if unknown.
+	Progress func(written, total int64)
+}
+
+// Upload is a file uploaded in the multipart form of a request.
+type Upload struct {
+	// Field is the name of the form field, e.g. "images.txt".
+	Field    string
+	Filename string
+	Content  []byte
+}
+
+// Request requests an archive of the images listed in the uploads.
+type Request struct {
+	Uploads []Upload
+	// Params are passed as form values, e.g. "format" or "checksums".
+	Params url.Values
+}
+
+// Result describes a downloaded archive.
+type Result struct {
+	Size int64
+	// SHA256 is the hex encoded checksum of the archive.
+	SHA256 string
+	Images []string
+	// Resumed reports whether a previous download was continued.
+	Resumed bool
+}
+
+// Error is an error response of the server.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("server responded %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// ErrChecksumMismatch is returned if the downloaded archive does not match
+// the checksum sent by the server.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// errUnexpectedRange is returned if the server sent another range than
+// requested. The download is restarted.
+var errUnexpectedRange = errors.New("unexpected range")
+
+// Download requests the archive and writes it to path. The archive is
+// downloaded to path.part first, which is continued by later calls for the
+// same request if the download is interrupted and the server sent an ETag.
+func (c *Client) Download(ctx context.Context, req Request, path string) (Result, error) {
+	body, contentType, err := req.encode()
+	if err != nil {
+		return Result{}, err
+	}
+	id, err := json.Marshal(req)
+	if err != nil {
+		return Result{}, err
+	}
+	return c.download(ctx, path, requestID(http.MethodPost, c.endpoint("tar"), id), func() (*http.Request, error) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("tar"), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", contentType)
+		return r, nil
+	})
+}
+
+// DownloadList downloads the newest archive of a list stored on the server
+// to path, see Download.
+func (c *Client) DownloadList(ctx context.Context, name, path string) (Result, error) {
+	endpoint := c.endpoint("lists/" + url.PathEscape(name) + "/latest.tar")
+	return c.download(ctx, path, requestID(http.MethodGet, endpoint, nil), func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	})
+}
+
+// Stream requests the archive and writes it to w without resuming.
+func (c *Client) Stream(ctx context.Context, req Request, w io.Writer) (Result, error) {
+	a, err := c.Open(ctx, req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer a.Close()
+
+	n, err := c.copy(w, a, 0, a.Size)
+	res := Result{Size: n, Images: a.Images}
+	if err != nil {
+		return res, err
+	}
+	res.SHA256 = a.SHA256
+	return res, nil
+}
+
+func (c *Client) endpoint(p string) string {
+	u := *c.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + p
+	u.RawPath = ""
+	return u.String()
+}
+
+// partState is stored in path.part.json next to an interrupted download.
+type partState struct {
+	// Request identifies the request, see requestID.
+	Request string `json:"request"`
+	// ETag is the ETag of the archive being downloaded.
+	ETag string `json:"etag"`
+}
+
+// requestID hashes a request to recognize the download of a partial file.
+func requestID(method, endpoint string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, endpoint)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readPartState returns the ETag of the partial download if it was started
+// by the same request, and removes the partial download otherwise.
+func readPartState(part, id string) (string, error) {
+	var state partState
+	b, err := ioutil.ReadFile(part + ".json")
+	if err == nil && json.Unmarshal(b, &state) == nil && state.Request == id && state.ETag != "" {
+		return state.ETag, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return "", removePart(part)
+}
+
+// removePart removes a partial download and its state.
+func removePart(part string) error {
+	for _, name := range []string{part, part + ".json"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) download(ctx context.Context, path, id string, newRequest func() (*http.Request, error)) (Result, error) {
+	attempts := c.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	part := path + ".part"
+	for i := 1; ; i++ {
+		res, err := c.fetch(part, id, newRequest)
+		if err == nil {
+			if err := os.Rename(part, path); err != nil {
+				return res, err
+			}
+			return res, removePart(part)
+		}
+		// The archive may have changed since the download was interrupted.
+		restart := errors.Is(err, ErrChecksumMismatch) && res.Resumed
+		if restart {
+			if err := removePart(part); err != nil {
+				return res, err
+			}
+		}
+		if !(restart || retryable(err)) || i >= attempts {
+			return res, err
+		}
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// fetch continues downloading into the file part. Only archives with an ETag
+// and a checksum header are resumed, as others may change between requests.
+func (c *Client) fetch(part, id string, newRequest func() (*http.Request, error)) (Result, error) {
+	etag, err := readPartState(part, id)
+	if err != nil {
+		return Result{}, err
+	}
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Result{}, err
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return Result{}, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", etag)
+	}
+	resp, err := c.do(req)
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The previous download is larger than the archive.
+		if req, err = newRequest(); err != nil {
+			return Result{}, err
+		}
+		resp, err = c.do(req)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	res := result(resp)
+	total := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		start, size, ok := contentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			f.Truncate(0)
+			return res, fmt.Errorf("%w %q", errUnexpectedRange, resp.Header.Get("Content-Range"))
+		}
+		res.Resumed, total = true, size
+	} else {
+		offset = 0
+	}
+	if err := f.Truncate(offset); err != nil {
+		return res, err
+	}
+	if err := writePartState(part, id, resp); err != nil {
+		return res, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return res, err
+	}
+	if _, err := c.copy(f, resp.Body, offset, total); err != nil {
+		return res, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return res, err
+	}
+	h := sha256.New()
+	if res.Size, err = io.Copy(h, f); err != nil {
+		return res, err
+	}
+	res.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return res, check(resp, res.SHA256)
+}
+
+// writePartState records the ETag of the response for resuming the download.
+// Downloads without an ETag or checksum header are not resumed.
+func writePartState(part, id string, resp *http.Response) error {
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.Header.Get(HeaderSHA256) == "" {
+		if err := os.Remove(part + ".json"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(partState{Request: id, ETag: etag})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(part+".json", b, 0o644)
+}
+
+// copy copies the body reporting progress, starting at offset.
+func (c *Client) copy(w io.Writer, body io.Reader, offset, total int64) (int64, error) {
+	if c.Progress == nil {
+		return io.Copy(w, body)
+	}
+	c.Progress(offset, total)
+	buf := make([]byte, 32*1024)
+	var written int64
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			c.Progress(offset+written, total)
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	e := &Error{StatusCode: resp.StatusCode}
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(content, &msg) == nil {
+		e.Message = msg.Message
+	} else {
+		e.Message = strings.TrimSpace(string(content))
+	}
+	return nil, e
+}
+
+func result(resp *http.Response) Result {
+	var res Result
+	if images := resp.Header.Get(HeaderImages); images != "" {
+		res.Images = strings.Split(images, ",")
+	}
+	return res
+}
+
+// check compares the checksum to the header or trailer sent by the server,
+// if any. Trailers are only available once the body has been read.
+func check(resp *http.Response, sum string) error {
+	expected := resp.Header.Get(HeaderSHA256)
+	if expected == "" {
+		expected = resp.Trailer.Get(HeaderSHA256)
+	}
+	if expected != "" && expected != sum {
+		return fmt.Errorf("%w: got %s, expected %s", ErrChecksumMismatch, sum, expected)
+	}
+	return nil
+}
+
+// contentRange parses "bytes start-end/size". The size is -1 if unknown.
+func contentRange(v string) (start, size int64, ok bool) {
+	v = strings.TrimPrefix(v, "bytes ")
+	i, j := strings.IndexByte(v, '-'), strings.IndexByte(v, '/')
+	if i < 0 || j < i {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(v[:i], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	size = -1
+	if v[j+1:] != "*" {
+		if size, err = strconv.ParseInt(v[j+1:], 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, size, true
+}
+
+// retryable reports whether the request may succeed if it is repeated.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errUnexpectedRange) || errors.As(err, &netErr)
+}
+
+func (r Request) encode() ([]byte, string, error) {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	for name, values := range r.Params {
+		for _, v := range values {
+			if err := w.WriteField(name, v); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	for _, u := range r.Uploads {
+		fw, err := w.CreateFormFile(u.Field, u.Filename)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := fw.Write(u.Content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), w.FormDataContentType(), nil
+}
@@ -0,0 +1,294 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bastjan/saveomat/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+var archive = bytes.Repeat([]byte("0123456789"), 10000)
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// archiveServer serves the archive like a stored list archive. It records the
+// Range headers of all requests.
+func archiveServer(t *testing.T, content []byte) (*httptest.Server, *[]string) {
+	srv, s := resumableServer(content, true)
+	return srv, &s.ranges
+}
+
+// resumable is the state of a resumableServer.
+type resumable struct {
+	content []byte
+	// etag sends an ETag and serves ranges like a stored list archive.
+	etag bool
+	// interrupt is the number of responses cut off after 5000 bytes.
+	interrupt int
+	// ranges and ifRanges record the headers of all requests.
+	ranges, ifRanges []string
+}
+
+func resumableServer(content []byte, etag bool) (*httptest.Server, *resumable) {
+	s := &resumable{content: content, etag: etag}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.ifRanges = append(s.ifRanges, r.Header.Get("If-Range"))
+		w.Header().Set(client.HeaderImages, "docker.io/library/busybox:latest")
+		w.Header().Set(client.HeaderSHA256, sha256Hex(s.content))
+		if s.etag {
+			w.Header().Set("ETag", `"`+sha256Hex(s.content)+`"`)
+		}
+		if s.interrupt > 0 {
+			s.interrupt--
+			// Promise the whole archive, but send only a part.
+			w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+			w.Write(s.content[:5000])
+			return
+		}
+		if !s.etag {
+			w.Write(s.content)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.content))
+	}))
+	return srv, s
+}
+
+func newClient(t *testing.T, srv *httptest.Server) *client.Client {
+	u, err := url.Parse(srv.URL)
+	assert.NoError(t, err)
+	return &client.Client{URL: u, Attempts: 3, Backoff: time.Millisecond}
+}
+
+func TestDownload(t *testing.T) {
+	srv, ranges := archiveServer(t, archive)
+	defer srv.Close()
+	subject := newClient(t, srv)
+	var written, total int64
+	subject.Progress = func(w, t int64) { written, total = w, t }
+
+	path := filepath.Join(t.TempDir(), "images.tar")
+	res, err := subject.Download(context.Background(), client.Request{}, path)
+	assert.NoError(t, err)
+	assert.Equal(t, client.Result{
+		Size:   int64(len(archive)),
+		SHA256: sha256Hex(archive),
+		Images: []string{"docker.io/library/busybox:latest"},
+	}, res)
+	assert.Equal(t, []string{""}, *ranges)
+	assert.Equal(t, int64(len(archive)), written)
+	assert.Equal(t, int64(len(archive)), total)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+	for _, name := range []string{path + ".part", path + ".part.json"} {
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
+
+func TestDownloadResumes(t *testing.T) {
+	srv, s := resumableServer(archive, true)
+	defer srv.Close()
+	subject := newClient(t, srv)
+	subject.Attempts = 1
+
+	path := filepath.Join(t.TempDir(), "images.tar")
+	s.interrupt = 1
+	_, err := subject.Download(context.Background(), client.Request{}, path)
+	assert.Error(t, err)
+
+	res, err := subject.Download(context.Background(), client.Request{}, path)
+	assert.NoError(t, err)
+	assert.True(t, res.Resumed)
+	assert.Equal(t, sha256Hex(archive), res.SHA256)
+	assert.Equal(t, []string{"", "bytes=5000-"}, s.ranges)
+	assert.Equal(t, []string{"", `"` + sha256Hex(archive) + `"`}, s.ifRanges)
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+}
+
+func TestDownloadRestartsChangedArchive(t *testing.T) {
+	srv, s := resumableServer(archive, true)
+	defer srv.Close()
+	subject := newClient(t, srv)
+	subject.Attempts = 1
+
+	path := filepath.Join(t.TempDir(), "images.tar")
+	s.interrupt = 1
+	_, err := subject.DownloadList(context.Background(), "platform-base", path)
+	assert.Error(t, err)
+
+	changed := bytes.Repeat([]byte("9876543210"), 10000)
+	s.content = changed
+	res, err := subject.DownloadList(context.Background(), "platform-base", path)
+	assert.NoError(t, err)
+	assert.False(t, res.Resumed)
+	assert.Equal(t, []string{"", "bytes=5000-"}, s.ranges)
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, changed, b)
+}
+
+func TestDownloadRestartsOtherRequest(t *testing.T) {
+	srv, s := resumableServer(archive, true)
+	defer srv.Close()
+	subject := newClient(t, srv)
+	subject.Attempts = 1
+
+	path := filepath.Join(t.TempDir(), "images.tar")
+	s.interrupt = 1
+	_, err := subject.Download(context.Background(), client.Request{Params: url.Values{"format": {"oci"}}}, path)
+	assert.Error(t, err)
+
+	res, err := subject.Download(context.Background(), client.Request{}, path)
+	assert.NoError(t, err)
+	assert.False(t, res.Resumed)
+	assert.Equal(t, []string{"", ""}, s.ranges)
+
+	// Partial downloads of unknown requests are ignored.
+	assert.NoError(t, ioutil.WriteFile(path+".part", []byte("outdated"), 0o644))
+	_, err = subject.Download(context.Background(), client.Request{}, path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "", ""}, s.ranges)
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+}
+
+func TestDownloadRetriesInterrupted(t *testing.T) {
+	srv, s := resumableServer(archive, true)
+	defer srv.Close()
+	subject := newClient(t, srv)
+
+	path := filepath.Join(t.TempDir(), "images.tar")
+	s.interrupt = 1
+	res, err := subject.Download(context.Background(), client.Request{}, path)
+	assert.NoError(t, err)
+	assert.True(t, res.Resumed)
+	assert.Equal(t, []string{"", "bytes=5000-"}, s.ranges)
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+
+	// Archives without an ETag are downloaded again.
+	srv, s = resumableServer(archive, false)
+	defer srv.Close()
+	subject = newClient(t, srv)
+	s.interrupt = 1
+	res, err = subject.Download(context.Background(), client.Request{}, path)
+	assert.NoError(t, err)
+	assert.False(t, res.Resumed)
+	assert.Equal(t, []string{"", ""}, s.ranges)
+	b, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, b)
+}
+
+func TestDownloadErrors(t *testing.T) {
+	calls := 0
+	status := http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message":"missing images.txt, docker-compose.yml or manifests.yaml"}`))
+	}))
+	defer srv.Close()
+	subject := newClient(t, srv)
+	path := filepath.Join(t.TempDir(), "images.tar")
+
+	_, err := subject.Download(context.Background(), client.Request{}, path)
+	var e *client.Error
+	assert.True(t, errors.As(err, &e))
+	assert.EqualError(t, err, "server responded 400 Bad Request: missing images.txt, docker-compose.yml or manifests.yaml")
+	assert.Equal(t, 1, calls)
+
+	calls, status = 0, http.StatusBadGateway
+	_, err = subject.Download(context.Background(), client.Request{}, path)
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(client.HeaderSHA256, sha256Hex([]byte("other")))
+		w.Write(archive)
+	}))
+	defer srv.Close()
+	subject := newClient(t, srv)
+
+	_, err := subject.Download(context.Background(), client.Request{}, filepath.Join(t.TempDir(), "images.tar"))
+	assert.True(t, errors.Is(err, client.ErrChecksumMismatch))
+}
+
+func TestStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseMultipartForm(1<<20))
+		assert.Equal(t, "/saveomat/tar", r.URL.Path)
+		assert.Equal(t, []string{"oci"}, r.MultipartForm.Value["format"])
+		f, fh, err := r.FormFile("images.txt")
+		if assert.NoError(t, err) {
+			b, _ := ioutil.ReadAll(f)
+			assert.Equal(t, "busybox\n", string(b))
+			assert.Equal(t, "base.txt", fh.Filename)
+		}
+
+		// Streamed archives have a trailer.
+		w.Header().Set("Trailer", client.HeaderSHA256)
+		w.WriteHeader(http.StatusOK)
+		w.Write(archive)
+		w.Header().Set(client.HeaderSHA256, sha256Hex(archive))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/saveomat/")
+	assert.NoError(t, err)
+	subject := &client.Client{URL: u}
+
+	buf := new(bytes.Buffer)
+	res, err := subject.Stream(context.Background(), client.Request{
+		Uploads: []client.Upload{{Field: "images.txt", Filename: "base.txt", Content: []byte("busybox\n")}},
+		Params:  url.Values{"format": {"oci"}},
+	}, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, sha256Hex(archive), res.SHA256)
+	assert.Equal(t, archive, buf.Bytes())
+}
+
+func TestDownloadList(t *testing.T) {
+	srv, _ := archiveServer(t, archive)
+	defer srv.Close()
+	var paths []string
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("list archive"))
+	})
+	subject := newClient(t, srv)
+	subject.Token = "secret"
+
+	path := filepath.Join(t.TempDir(), "images.tar")
+	_, err := subject.DownloadList(context.Background(), "platform-base", path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /lists/platform-base/latest.tar"}, paths)
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/distribution/reference"
+)
+
+// authEntry is an entry of "auths" in a docker config file.
+type authEntry struct {
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// Credentials returns a docker config file with the credentials needed to
+// pull the images. Credentials kept by credential helpers are resolved
+// locally, the server only receives plain "auths" keyed by repository.
+func Credentials(cfg *configfile.ConfigFile, images []string) ([]byte, error) {
+	authn := auth.FromConfigFile(cfg)
+	auths := map[string]authEntry{}
+	for _, img := range images {
+		ref, err := reference.ParseNormalizedNamed(img)
+		if err != nil {
+			// Left to the server to report.
+			continue
+		}
+		ac, err := auth.AuthConfigFor(authn, img)
+		if err != nil {
+			return nil, fmt.Errorf("getting credentials for %s: %w", img, err)
+		}
+		e := authEntry{IdentityToken: ac.IdentityToken, RegistryToken: ac.RegistryToken}
+		if ac.Username != "" || ac.Password != "" {
+			e.Auth = base64.StdEncoding.EncodeToString([]byte(ac.Username + ":" + ac.Password))
+		}
+		if e != (authEntry{}) {
+			auths[ref.Name()] = e
+		}
+	}
+	return json.Marshal(struct {
+		Auths map[string]authEntry `json:"auths"`
+	}{auths})
+}
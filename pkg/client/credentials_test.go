@@ -0,0 +1,78 @@
+package client_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/saveomat/pkg/client"
+	"github.com/docker/cli/cli/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHelper installs docker-credential-fake on the PATH. It knows the
+// credentials of private.example.com only.
+func fakeHelper(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read server
+case "$server" in
+*private.example.com*)
+	echo '{"ServerURL":"private.example.com","Username":"helper","Secret":"s3cret"}';;
+*)
+	echo "credentials not found in native keychain"
+	exit 1;;
+esac
+`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(script), 0o755))
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+}
+
+func decodeAuths(t *testing.T, b []byte) map[string]string {
+	var cfg struct {
+		Auths map[string]struct{ Auth string }
+	}
+	assert.NoError(t, json.Unmarshal(b, &cfg))
+	auths := map[string]string{}
+	for repo, a := range cfg.Auths {
+		userpass, err := base64.StdEncoding.DecodeString(a.Auth)
+		assert.NoError(t, err)
+		auths[repo] = string(userpass)
+	}
+	return auths
+}
+
+func TestCredentials(t *testing.T) {
+	cfg, err := config.LoadFromReader(strings.NewReader(`{
+		"auths": {
+			"test.io": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("test:test")) + `"}
+		}
+	}`))
+	assert.NoError(t, err)
+
+	b, err := client.Credentials(cfg, []string{"test.io/app:1.0", "test.io/other", "busybox", "INVALID REF"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"test.io/app":   "test:test",
+		"test.io/other": "test:test",
+	}, decodeAuths(t, b))
+}
+
+func TestCredentialsHelper(t *testing.T) {
+	fakeHelper(t)
+	cfg, err := config.LoadFromReader(strings.NewReader(`{"credsStore": "fake"}`))
+	assert.NoError(t, err)
+
+	b, err := client.Credentials(cfg, []string{"private.example.com/app", "busybox"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"private.example.com/app": "helper:s3cret",
+	}, decodeAuths(t, b))
+	assert.NotContains(t, string(b), "credsStore")
+}
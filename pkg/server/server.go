@@ -0,0 +1,179 @@
+// Package server embeds a saveomat server into other applications.
+//
+// Server is an http.Handler. Mount it below ServerOpts.BaseURL of an
+// existing mux or Echo instance:
+//
+//	s, err := server.NewServer(server.ServerOpts{BaseURL: "/saveomat", DockerClient: cli})
+//	mux.Handle("/saveomat/", s)
+//	e.Any("/saveomat/*", echo.WrapHandler(s))
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/bastjan/saveomat/internal/pkg/auth"
+	"github.com/bastjan/saveomat/internal/pkg/mirror"
+	"github.com/bastjan/saveomat/internal/pkg/s3"
+	internal "github.com/bastjan/saveomat/internal/pkg/server"
+	"github.com/bastjan/saveomat/internal/pkg/split"
+	"github.com/bastjan/saveomat/internal/pkg/vuln"
+	"github.com/bastjan/saveomat/internal/pkg/webhook"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/docker/client"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// HeaderImages lists the images contained in an archive.
+	HeaderImages = internal.HeaderImages
+	// HeaderSHA256 holds the hex encoded SHA-256 of the whole archive.
+	HeaderSHA256 = internal.HeaderSHA256
+)
+
+// ServerOpts configure a server. They correspond to the environment
+// variables of the saveomat binary.
+type ServerOpts struct {
+	// BaseURL is the path the server is mounted below, e.g. "/saveomat".
+	BaseURL string
+	// DockerClient pulls and saves images. Required for the docker format.
+	DockerClient client.ImageAPIClient
+	// Mirrors are rules in the format of REGISTRY_MIRRORS.
+	Mirrors string
+	// SpoolDir enables spooling archives to disk before sending them.
+	SpoolDir string
+	// SigningKey signs the checksums added to archives.
+	SigningKey ed25519.PrivateKey
+	// CosignKeys enables signature verification. Only images signed by one
+	// of the keys are bundled.
+	CosignKeys []crypto.PublicKey
+	// VulnDB is the path of a vulnerability database, see VULN_DB.
+	VulnDB string
+	// FailOn is a severity like "high", see VULN_FAIL_SEVERITY. Requires
+	// VulnDB.
+	FailOn string
+	// ObjectStorage enables uploading archives instead of sending them.
+	ObjectStorage *ObjectStorage
+	// Webhooks are notified of every archive.
+	Webhooks []string
+	// WebhookSecret signs the events sent to Webhooks, but not to the
+	// webhooks given per request.
+	WebhookSecret []byte
+	// ListsDir enables storing image lists and their archives.
+	ListsDir string
+	// ListsAuth holds the credentials for archives of stored lists. It
+	// requires ListsToken.
+	ListsAuth *configfile.ConfigFile
+	// ListsToken is required as bearer token for the lists routes if set.
+	ListsToken string
+	// PushLimit is the maximum size of archives uploaded to /push, like
+	// "10G". Defaults to 4G.
+	PushLimit string
+}
+
+// ObjectStorage is an S3 compatible object storage.
+type ObjectStorage struct {
+	// Endpoint is the base URL of the object storage, e.g. https://minio:9000.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PartSize is the size of multipart upload parts, if not the default.
+	PartSize int64
+	// Prefix is prepended to the keys of uploaded archives.
+	Prefix string
+	// PresignExpiry is the validity of download URLs. Defaults to 24 hours.
+	PresignExpiry time.Duration
+}
+
+// Server serves the saveomat API and web interface.
+type Server struct {
+	*echo.Echo
+	server *internal.Server
+}
+
+// NewServer validates the options and returns the server.
+func NewServer(opts ServerOpts) (*Server, error) {
+	mirrors, err := mirror.Parse(opts.Mirrors)
+	if err != nil {
+		return nil, fmt.Errorf("parsing mirrors: %w", err)
+	}
+	o := internal.ServerOpts{
+		BaseURL:       opts.BaseURL,
+		DockerClient:  opts.DockerClient,
+		Mirrors:       mirrors,
+		SpoolDir:      opts.SpoolDir,
+		SigningKey:    opts.SigningKey,
+		CosignKeys:    opts.CosignKeys,
+		Webhooks:      opts.Webhooks,
+		WebhookSecret: opts.WebhookSecret,
+		ListsDir:      opts.ListsDir,
+		ListsToken:    opts.ListsToken,
+	}
+
+	if opts.VulnDB != "" {
+		if o.VulnDB, err = vuln.LoadFile(opts.VulnDB); err != nil {
+			return nil, fmt.Errorf("loading %s: %w", opts.VulnDB, err)
+		}
+	}
+	if opts.FailOn != "" {
+		if o.VulnDB == nil {
+			return nil, fmt.Errorf("a fail on severity requires a vulnerability database")
+		}
+		if o.FailOn, err = vuln.ParseSeverity(opts.FailOn); err != nil {
+			return nil, err
+		}
+	}
+
+	if st := opts.ObjectStorage; st != nil {
+		u, err := url.Parse(st.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("parsing object storage endpoint: %w", err)
+		}
+		if st.Bucket == "" {
+			return nil, fmt.Errorf("object storage bucket is required")
+		}
+		o.ObjectStorage = &s3.Client{
+			Endpoint:  u,
+			Region:    st.Region,
+			Bucket:    st.Bucket,
+			AccessKey: st.AccessKey,
+			SecretKey: st.SecretKey,
+			PartSize:  st.PartSize,
+		}
+		o.ObjectPrefix, o.PresignExpiry = st.Prefix, st.PresignExpiry
+	}
+
+	for _, hook := range opts.Webhooks {
+		if err := webhook.ValidateURL(hook); err != nil {
+			return nil, fmt.Errorf("parsing webhooks: %w", err)
+		}
+	}
+	if opts.ListsAuth != nil {
+		// Anyone could add a list of private images and download the
+		// archive otherwise.
+		if opts.ListsToken == "" {
+			return nil, fmt.Errorf("lists auth requires a lists token")
+		}
+		o.ListsAuth = auth.FromConfigFile(opts.ListsAuth)
+	}
+	if opts.PushLimit != "" {
+		if o.PushLimit, err = split.ParseSize(opts.PushLimit); err != nil {
+			return nil, fmt.Errorf("parsing push limit: %w", err)
+		}
+	}
+
+	s := internal.NewServer(o)
+	return &Server{Echo: s.Echo, server: s}, nil
+}
+
+// RunScheduler builds the archives of stored lists with a schedule until ctx
+// is done.
+func (s *Server) RunScheduler(ctx context.Context) {
+	s.server.RunScheduler(ctx)
+}
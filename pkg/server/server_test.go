@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bastjan/saveomat/pkg/server"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, handler http.Handler, path string) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code
+}
+
+func TestEmbedInServeMux(t *testing.T) {
+	subject, err := server.NewServer(server.ServerOpts{BaseURL: "/saveomat"})
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle("/saveomat/", subject)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	assert.Equal(t, http.StatusOK, get(t, mux, "/healthz"))
+	assert.Equal(t, http.StatusOK, get(t, mux, "/saveomat/"))
+	assert.Equal(t, http.StatusBadRequest, get(t, mux, "/saveomat/tar"))
+}
+
+func TestEmbedInEcho(t *testing.T) {
+	subject, err := server.NewServer(server.ServerOpts{BaseURL: "/saveomat"})
+	assert.NoError(t, err)
+	e := echo.New()
+	e.Any("/saveomat/*", echo.WrapHandler(subject))
+
+	assert.Equal(t, http.StatusOK, get(t, e, "/saveomat/"))
+	assert.Equal(t, http.StatusBadRequest, get(t, e, "/saveomat/tar"))
+	assert.Equal(t, http.StatusNotFound, get(t, e, "/tar"))
+}
+
+func TestNewServerValidates(t *testing.T) {
+	for name, opts := range map[string]server.ServerOpts{
+		"mirrors":        {Mirrors: "docker.io"},
+		"fail on":        {FailOn: "high"},
+		"vuln db":        {VulnDB: "does-not-exist.json"},
+		"object storage": {ObjectStorage: &server.ObjectStorage{Endpoint: "https://minio:9000"}},
+		"webhooks":       {Webhooks: []string{"ftp://example.com"}},
+		"push limit":     {PushLimit: "lots"},
+		"lists auth":     {ListsDir: "lists", ListsAuth: configfile.New("config.json")},
+	} {
+		_, err := server.NewServer(opts)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestListsToken(t *testing.T) {
+	subject, err := server.NewServer(server.ServerOpts{ListsDir: t.TempDir(), ListsAuth: configfile.New("config.json"), ListsToken: "secret"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, get(t, subject, "/lists"))
+	req := httptest.NewRequest(http.MethodGet, "/lists", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	subject.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}